@@ -2,10 +2,12 @@ package forester
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"math"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -16,42 +18,264 @@ import (
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-func adifToProto(adifString string, createTime time.Time) (*adifpb.Adif, error) {
+// parseMode says what to do with records which can't be completely parsed.
+type parseMode int
+
+const (
+	// parseLenient leaves out fields which can't be parsed, and skips records which can't be parsed
+	// at all. Both are reported.
+	parseLenient parseMode = iota
+	// parseStrict fails the whole document if any record has a problem, reporting all of them.
+	parseStrict
+)
+
+// FieldError is a field value which couldn't be parsed.
+type FieldError struct {
+	Field string `json:"field"`
+	Value string `json:"value"`
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// RecordError describes the problems with a record. Record counts the records in the document from
+// 1, and Line is the line the record starts on. A Skipped record wasn't parsed at all, because of
+// Error or one of its Fields; otherwise only the bad fields were left out.
+type RecordError struct {
+	Record  int          `json:"record"`
+	Line    int          `json:"line"`
+	Skipped bool         `json:"skipped"`
+	Error   string       `json:"error,omitempty"`
+	Fields  []FieldError `json:"fields,omitempty"`
+}
+
+func (e RecordError) String() string {
+	var problems []string
+	if e.Error != "" {
+		problems = append(problems, e.Error)
+	}
+	for _, f := range e.Fields {
+		problems = append(problems, fmt.Sprintf("%v %q on line %d: %v", f.Field, f.Value, f.Line, f.Error))
+	}
+	return fmt.Sprintf("record %d on line %d: %v", e.Record, e.Line, strings.Join(problems, "; "))
+}
+
+// ParseErrors are the problems found by a strict parse.
+type ParseErrors []RecordError
+
+func (e ParseErrors) Error() string {
+	if len(e) == 1 {
+		return "bad ADIF " + e[0].String()
+	}
+	return fmt.Sprintf("%d bad ADIF records; the first is %v", len(e), e[0])
+}
+
+// adifToProto parses the ADIF leniently, logging and returning the problems with its records.
+func adifToProto(adifString string, createTime time.Time) (*adifpb.Adif, []RecordError, error) {
+	adi, recordErrs, err := parseAdif(adifString, createTime, parseLenient)
+	for _, recordErr := range recordErrs {
+		log.Printf("Problem parsing ADIF %v", recordErr)
+	}
+	return adi, recordErrs, err
+}
+
+// parseAdif parses the ADIF, returning the problems with its records. In strict mode, problems are
+// returned as ParseErrors instead. The ADIF's header is kept, with createTime as its created time
+// if it doesn't have one.
+func parseAdif(adifString string, createTime time.Time, mode parseMode) (*adifpb.Adif, []RecordError, error) {
 	adi := new(adifpb.Adif)
-	created := timestamppb.New(createTime)
-	adi.Header = &adifpb.Header{
-		AdifVersion:      "3.1.1",
-		CreatedTimestamp: created,
-		ProgramId:        "forester-func",
-		ProgramVersion:   "0.0.2",
+	decoder := newAdifDecoder(strings.NewReader(adifString))
+	for {
+		qso, err := decoder.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, decoder.Errors(), err
+		}
+		adi.Qsos = append(adi.Qsos, qso)
+	}
+	if mode == parseStrict && len(decoder.Errors()) > 0 {
+		return nil, decoder.Errors(), ParseErrors(decoder.Errors())
+	}
+	adi.Header = decoder.Header()
+	if adi.Header == nil {
+		adi.Header = new(adifpb.Header)
+	}
+	if adi.Header.CreatedTimestamp == nil {
+		adi.Header.CreatedTimestamp = timestamppb.New(createTime)
+	}
+	return adi, decoder.Errors(), nil
+}
+
+// parseRawRecord parses one record, leaving out fields with bad values, including values of
+// user-defined fields which don't fit their definitions. If the contact's time is bad, the record is
+// skipped and the QSO is nil.
+func parseRawRecord(raw rawRecord, userDefs map[string]UserDef) (*adifpb.Qso, *RecordError) {
+	reader := adif.NewADIDocumentReader(strings.NewReader(raw.text), false)
+	record, _, err := reader.Next()
+	if err != nil {
+		return nil, &RecordError{Line: raw.line, Skipped: true, Error: err.Error()}
+	}
+	var recordErr *RecordError
+	var badFields []adifield.Field
+	for field, value := range record.Fields() {
+		check, ok := fieldChecks[field]
+		if userDef, isUserDef := userDefs[string(field)]; isUserDef {
+			check, ok = userDef.check, true
+		}
+		if !ok || value == "" {
+			continue
+		}
+		if err := check(value); err != nil {
+			if recordErr == nil {
+				recordErr = &RecordError{Line: raw.line}
+			}
+			recordErr.Fields = append(recordErr.Fields, FieldError{
+				Field: string(field),
+				Value: value,
+				Line:  raw.fieldLine(string(field)),
+				Error: err.Error(),
+			})
+			badFields = append(badFields, field)
+			if field == adifield.QSO_DATE || field == adifield.TIME_ON {
+				recordErr.Skipped = true
+			}
+		}
+	}
+	if recordErr != nil {
+		sort.Slice(recordErr.Fields, func(i, j int) bool {
+			return recordErr.Fields[i].Line < recordErr.Fields[j].Line ||
+				recordErr.Fields[i].Line == recordErr.Fields[j].Line && recordErr.Fields[i].Field < recordErr.Fields[j].Field
+		})
+		if recordErr.Skipped {
+			return nil, recordErr
+		}
+	}
+	for _, field := range badFields {
+		record.Set(field, "")
+	}
+	return recordToQso(record), recordErr
+}
+
+// fieldChecks validates the fields which are parsed into something other than a string.
+var fieldChecks = map[adifield.Field]func(string) error{}
+
+func init() {
+	checks := []struct {
+		check  func(string) error
+		fields []adifield.Field
+	}{
+		{checkDate, []adifield.Field{adifield.QSO_DATE, adifield.QSO_DATE_OFF, adifield.QSLRDATE,
+			adifield.QSLSDATE, adifield.EQSL_QSLRDATE, adifield.EQSL_QSLSDATE, adifield.LOTW_QSLRDATE,
+			adifield.LOTW_QSLSDATE, adifield.QRZCOM_QSO_UPLOAD_DATE, adifield.HRDLOG_QSO_UPLOAD_DATE,
+			adifield.CLUBLOG_QSO_UPLOAD_DATE}},
+		{checkTime, []adifield.Field{adifield.TIME_ON, adifield.TIME_OFF}},
+		{checkLatLon, []adifield.Field{adifield.LAT, adifield.LON, adifield.MY_LAT, adifield.MY_LON}},
+		{checkFloat, []adifield.Field{adifield.FREQ, adifield.FREQ_RX, adifield.RX_PWR, adifield.TX_PWR}},
+		{checkUint, []adifield.Field{adifield.AGE, adifield.CQZ, adifield.DISTANCE, adifield.DXCC,
+			adifield.FISTS, adifield.FISTS_CC, adifield.IOTA_ISLAND_ID, adifield.ITUZ, adifield.TEN_TEN,
+			adifield.UKSMG, adifield.MY_CQ_ZONE, adifield.MY_DXCC, adifield.MY_FISTS,
+			adifield.MY_IOTA_ISLAND_ID, adifield.MY_ITU_ZONE, adifield.A_INDEX, adifield.K_INDEX,
+			adifield.MAX_BURSTS, adifield.NR_BURSTS, adifield.NR_PINGS, adifield.SFI}},
+		{checkInt, []adifield.Field{adifield.ANT_AZ, adifield.ANT_EL}},
+		{checkBool, []adifield.Field{adifield.QSO_RANDOM, adifield.SWL, adifield.SILENT_KEY,
+			adifield.FORCE_INIT}},
+	}
+	for _, c := range checks {
+		for _, field := range c.fields {
+			fieldChecks[field] = c.check
+		}
+	}
+}
+
+func checkDate(st string) error {
+	_, err := time.Parse("20060102", st)
+	if err != nil {
+		return errors.New("not a date like YYYYMMDD")
+	}
+	return nil
+}
+
+func checkTime(st string) error {
+	layout := "150405"
+	if len(st) == 4 {
+		layout = "1504"
 	}
-	reader := adif.NewADIDocumentReader(strings.NewReader(adifString), true)
-	rec, _, err := reader.Next()
-	for err == nil {
-		adi.Qsos = append(adi.Qsos, recordToQso(rec))
-		rec, _, err = reader.Next()
+	_, err := time.Parse(layout, st)
+	if err != nil {
+		return errors.New("not a time like HHMM or HHMMSS")
+	}
+	return nil
+}
+
+func checkLatLon(st string) error {
+	_, err := parseLatLon(st)
+	return err
+}
+
+func checkFloat(st string) error {
+	_, err := strconv.ParseFloat(st, 64)
+	if err != nil {
+		return errors.New("not a number")
+	}
+	return nil
+}
+
+func checkUint(st string) error {
+	_, err := strconv.ParseUint(st, 10, 32)
+	if err != nil {
+		return errors.New("not a whole number")
+	}
+	return nil
+}
+
+func checkInt(st string) error {
+	_, err := strconv.ParseInt(st, 10, 32)
+	if err != nil {
+		return errors.New("not a whole number")
 	}
-	if !errors.Is(err, io.EOF) {
-		return nil, err
+	return nil
+}
+
+func checkBool(st string) error {
+	if st != "Y" && st != "N" && st != "y" && st != "n" {
+		return errors.New("not Y or N")
 	}
-	return adi, nil
+	return nil
 }
 
 func recordToQso(record adif.Record) *adifpb.Qso {
 	qso := new(adifpb.Qso)
-	parseTopLevel(record, qso)
-	parseAppDefined(record, qso)
-	parseContactedStation(record, qso)
-	parseLoggingStation(record, qso)
-	parseContest(record, qso)
-	parsePropagation(record, qso)
-	parseAwardsAndCredit(record, qso)
-	parseUploads(record, qso)
-	parseQsls(record, qso)
+	fields := &recordFields{record: record, read: map[string]bool{}}
+	parseTopLevel(fields, qso)
+	parseContactedStation(fields, qso)
+	parseLoggingStation(fields, qso)
+	parseContest(fields, qso)
+	parsePropagation(fields, qso)
+	parseAwardsAndCredit(fields, qso)
+	parseUploads(fields, qso)
+	parseQsls(fields, qso)
+	parseAppDefined(record, fields.read, qso)
 	return qso
 }
 
-func parseTopLevel(record adif.Record, qso *adifpb.Qso) {
+// fieldGetter is the part of adif.Record which the parse functions use.
+type fieldGetter interface {
+	Get(field adifield.Field) string
+}
+
+// recordFields remembers which fields of the record have been read.
+type recordFields struct {
+	record adif.Record
+	read   map[string]bool
+}
+
+func (f *recordFields) Get(field adifield.Field) string {
+	f.read[strings.ToUpper(string(field))] = true
+	return f.record.Get(field)
+}
+
+func parseTopLevel(record fieldGetter, qso *adifpb.Qso) {
 	qso.Band = record.Get(adifield.BAND)
 	qso.BandRx = record.Get(adifield.BAND_RX)
 	qso.Comment = record.Get(adifield.COMMENT)
@@ -71,11 +295,14 @@ func parseTopLevel(record adif.Record, qso *adifpb.Qso) {
 	qso.Swl = getBool(record.Get(adifield.SWL))
 }
 
-func parseAppDefined(record adif.Record, qso *adifpb.Qso) {
+// parseAppDefined keeps the APP_ fields, and any other fields which weren't read into the QSO, like
+// ones from newer versions of ADIF. They're written back out as they were, so nothing is lost.
+func parseAppDefined(record adif.Record, read map[string]bool, qso *adifpb.Qso) {
 	appDefined := map[string]string{}
 	for field, value := range record.Fields() {
-		if strings.HasPrefix(string(field), adifield.APP_) {
-			appDefined[strings.ToLower(string(field))] = value // TODO converting to lower for compatibility. could this be left upper?
+		name := strings.ToUpper(string(field))
+		if value != "" && (strings.HasPrefix(name, adifield.APP_) || !read[name]) {
+			appDefined[strings.ToLower(name)] = value // TODO converting to lower for compatibility. could this be left upper?
 		}
 	}
 	if len(appDefined) > 0 {
@@ -83,7 +310,7 @@ func parseAppDefined(record adif.Record, qso *adifpb.Qso) {
 	}
 }
 
-func parseContactedStation(record adif.Record, qso *adifpb.Qso) {
+func parseContactedStation(record fieldGetter, qso *adifpb.Qso) {
 	qso.ContactedStation = new(adifpb.Station)
 	qso.ContactedStation.Address = record.Get(adifield.ADDRESS)
 	qso.ContactedStation.Age = getUint32(record.Get(adifield.AGE))
@@ -125,7 +352,7 @@ func parseContactedStation(record adif.Record, qso *adifpb.Qso) {
 	qso.ContactedStation.Web = record.Get(adifield.WEB)
 }
 
-func parseLoggingStation(record adif.Record, qso *adifpb.Qso) {
+func parseLoggingStation(record fieldGetter, qso *adifpb.Qso) {
 	qso.LoggingStation = new(adifpb.Station)
 	qso.LoggingStation.AntennaAzimuth = getInt32(record.Get(adifield.ANT_AZ))
 	qso.LoggingStation.AntennaElevation = getInt32(record.Get(adifield.ANT_EL))
@@ -158,7 +385,7 @@ func parseLoggingStation(record adif.Record, qso *adifpb.Qso) {
 	qso.LoggingStation.Power = getFloat64(record.Get(adifield.TX_PWR))
 }
 
-func parseContest(record adif.Record, qso *adifpb.Qso) {
+func parseContest(record fieldGetter, qso *adifpb.Qso) {
 	contestID := record.Get(adifield.CONTEST_ID)
 	if contestID != "" {
 		qso.Contest = new(adifpb.ContestData)
@@ -178,7 +405,7 @@ func parseContest(record adif.Record, qso *adifpb.Qso) {
 	}
 }
 
-func parsePropagation(record adif.Record, qso *adifpb.Qso) {
+func parsePropagation(record fieldGetter, qso *adifpb.Qso) {
 	qso.Propagation = new(adifpb.Propagation)
 	qso.Propagation.AIndex = getUint32(record.Get(adifield.A_INDEX))
 	qso.Propagation.AntPath = record.Get(adifield.ANT_PATH)
@@ -194,7 +421,7 @@ func parsePropagation(record adif.Record, qso *adifpb.Qso) {
 	qso.Propagation.SolarFluxIndex = getUint32(record.Get(adifield.SFI))
 }
 
-func parseAwardsAndCredit(record adif.Record, qso *adifpb.Qso) {
+func parseAwardsAndCredit(record fieldGetter, qso *adifpb.Qso) {
 	qso.AwardSubmitted = parseAwards(record.Get(adifield.AWARD_SUBMITTED))
 	qso.AwardGranted = parseAwards(record.Get(adifield.AWARD_GRANTED))
 	qso.CreditSubmitted = parseCredit(record.Get(adifield.CREDIT_SUBMITTED))
@@ -226,7 +453,7 @@ func parseCredit(creditString string) []*adifpb.Credit {
 	return ret
 }
 
-func parseUploads(record adif.Record, qso *adifpb.Qso) {
+func parseUploads(record fieldGetter, qso *adifpb.Qso) {
 	qrzStatus := record.Get(adifield.QRZCOM_QSO_UPLOAD_STATUS)
 	if qrzStatus != "" {
 		qso.Qrzcom = new(adifpb.Upload)
@@ -262,28 +489,38 @@ func translateUploadStatus(status string) adifpb.UploadStatus {
 	}
 }
 
-func parseQsls(record adif.Record, qso *adifpb.Qso) {
+// parseQsls reads the card, eQSL and LoTW QSLs. eQSL's Authenticity Guaranteed flag, APP_EQSL_AG,
+// has no place in the Qsl message; it's an application-defined field, so it's kept in AppDefined
+// and written back out from there.
+func parseQsls(record fieldGetter, qso *adifpb.Qso) {
 	qso.Card = parseCardQsl(record)
-
 	qso.Eqsl = parseQsl(record.Get(adifield.EQSL_QSL_SENT), record.Get(adifield.EQSL_QSL_RCVD), record.Get(adifield.EQSL_QSLRDATE), record.Get(adifield.EQSL_QSLSDATE))
-	qso.Eqsl = parseQsl(record.Get(adifield.LOTW_QSL_SENT), record.Get(adifield.LOTW_QSL_RCVD), record.Get(adifield.LOTW_QSLRDATE), record.Get(adifield.LOTW_QSLSDATE))
+	qso.Lotw = parseQsl(record.Get(adifield.LOTW_QSL_SENT), record.Get(adifield.LOTW_QSL_RCVD), record.Get(adifield.LOTW_QSLRDATE), record.Get(adifield.LOTW_QSLSDATE))
+	if qso.Card == nil && qso.Eqsl != nil {
+		// Without a card, the QSL message is the eQSL's, like in the eQSL.cc inbox
+		qso.Eqsl.ReceivedMessage = record.Get(adifield.QSLMSG)
+	}
 }
 
-func parseCardQsl(record adif.Record) *adifpb.Qsl {
+func parseCardQsl(record fieldGetter) *adifpb.Qsl {
 	card := parseQsl(record.Get(adifield.QSL_SENT), record.Get(adifield.QSL_RCVD), record.Get(adifield.QSLRDATE), record.Get(adifield.QSLSDATE))
-	if card == nil {
+	sentVia, receivedVia := record.Get(adifield.QSL_SENT_VIA), record.Get(adifield.QSL_RCVD_VIA)
+	if card == nil && sentVia == "" && receivedVia == "" {
 		return nil
 	}
-	card.SentVia = record.Get(adifield.QSL_SENT_VIA)
-	card.ReceivedVia = record.Get(adifield.QSL_RCVD_VIA)
+	if card == nil {
+		card = new(adifpb.Qsl)
+	}
+	card.SentVia = sentVia
+	card.ReceivedVia = receivedVia
 	card.ReceivedMessage = record.Get(adifield.QSLMSG)
 	return card
 }
 
+// parseQsl returns nil only if none of the fields are given. A status of N is kept, so it's
+// written back out.
 func parseQsl(sent, received, receivedDate, sentDate string) *adifpb.Qsl {
-	var noQsl = (sent == "" || sent == "N") &&
-		(received == "" || received == "N")
-	if noQsl {
+	if sent == "" && received == "" && receivedDate == "" && sentDate == "" {
 		return nil
 	}
 	qsl := new(adifpb.Qsl)
@@ -296,21 +533,34 @@ func parseQsl(sent, received, receivedDate, sentDate string) *adifpb.Qsl {
 }
 
 func getLatLon(st string) float64 {
+	latLon, _ := parseLatLon(st)
+	return latLon
+}
+
+var latLonRegexp = regexp.MustCompile(`^([NESW])(\d+) ([\d.]+)$`)
+
+// parseLatLon parses a location like N039 25.212, returning 0 if it's empty.
+func parseLatLon(st string) (float64, error) {
 	if st == "" {
-		return 0
+		return 0, nil
+	}
+	groups := latLonRegexp.FindStringSubmatch(st)
+	if groups == nil {
+		return 0, errors.New("not a location like N039 25.212")
 	}
-	r := regexp.MustCompile(`([NESW])(\d+) ([\d.]+)`)
-	groups := r.FindStringSubmatch(st)
 	cardinal := groups[1]
 	degrees, _ := strconv.ParseFloat(groups[2], 64)
-	minutes, _ := strconv.ParseFloat(groups[3], 64)
+	minutes, err := strconv.ParseFloat(groups[3], 64)
+	if err != nil {
+		return 0, errors.New("not a location like N039 25.212")
+	}
 	retval := degrees + (minutes / 60.0)
 	if cardinal == "S" || cardinal == "W" {
 		retval *= -1
 	}
 	// 4 decimal places is enough; https://xkcd.com/2170/
 	retval = math.Round(retval*10000) / 10000
-	return retval
+	return retval, nil
 }
 
 func getBool(st string) bool {
@@ -336,12 +586,15 @@ func getTimestamp(dateStr string, timeStr string) *timestamppb.Timestamp {
 	if dateStr == "" {
 		return nil
 	}
-	if len(timeStr) == 4 {
+	switch len(timeStr) {
+	case 0:
+		timeStr = "000000"
+	case 4:
 		timeStr += "00"
 	}
 	t, err := time.Parse("20060102 150405", dateStr+" "+timeStr)
 	if err != nil {
-		log.Print(err)
+		return nil
 	}
 	return timestamppb.New(t)
 }
@@ -352,7 +605,7 @@ func getDate(dateStr string) *timestamppb.Timestamp {
 	}
 	t, err := time.Parse("20060102", dateStr)
 	if err != nil {
-		log.Print(err)
+		return nil
 	}
 	return timestamppb.New(t)
 }
@@ -12,10 +12,7 @@ func Test_adifToProto(t *testing.T) {
 	createTime := time.Now()
 	createStamp := timestamppb.New(createTime)
 	standardHeader := &adifpb.Header{
-		AdifVersion:      "3.1.1",
 		CreatedTimestamp: createStamp,
-		ProgramId:        "forester-func",
-		ProgramVersion:   "0.0.2",
 	}
 	type args struct {
 		adifString string
@@ -234,7 +231,7 @@ QSL RX SINCE: 2020-10-31 21:08:24 (system supplied default)
 				createTime: createTime,
 			},
 			want: &adifpb.Adif{
-				Header: standardHeader,
+				Header: &adifpb.Header{CreatedTimestamp: createStamp, ProgramId: "LoTW"},
 				Qsos: []*adifpb.Qso{
 					{
 						Band:             "20M",
@@ -262,7 +259,7 @@ QSL RX SINCE: 2020-10-31 21:08:24 (system supplied default)
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := adifToProto(tt.args.adifString, tt.args.createTime)
+			got, _, err := adifToProto(tt.args.adifString, tt.args.createTime)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("adifToProto() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -273,3 +270,93 @@ QSL RX SINCE: 2020-10-31 21:08:24 (system supplied default)
 		})
 	}
 }
+
+func Test_parseAdif_errors(t *testing.T) {
+	adi := `Exported by a logger
+<eoh>
+<call:4>N6DN<qso_date:8>20201025<time_on:4>2015<lat:11>N039 25.212<eor>
+<call:4>K9IJ<qso_date:8>20201325<time_on:4>2015<eor>
+<call:6>KE0RCW<qso_date:8>20201025<time_on:4>2016
+<lat:7>garbage<freq:3>abc<eor>
+<call:4 >W1AW<qso_date:8>20201025<time_on:4>2017<eor>
+<call:4>K0SW<qso_date:8>20201025<time_on:4>2018<eor>
+`
+	tests := []struct {
+		name      string
+		mode      parseMode
+		wantCalls []string
+		wantErrs  []RecordError
+		wantErr   bool
+	}{
+		{
+			name:      "lenient",
+			mode:      parseLenient,
+			wantCalls: []string{"N6DN", "KE0RCW", "K0SW"},
+			wantErrs: []RecordError{
+				{Record: 2, Line: 4, Skipped: true, Fields: []FieldError{
+					{Field: "QSO_DATE", Value: "20201325", Line: 4, Error: "not a date like YYYYMMDD"},
+				}},
+				{Record: 3, Line: 5, Fields: []FieldError{
+					{Field: "FREQ", Value: "abc", Line: 6, Error: "not a number"},
+					{Field: "LAT", Value: "garbage", Line: 6, Error: "not a location like N039 25.212"},
+				}},
+				{Record: 4, Line: 7, Skipped: true, Error: "malformed length in field <call:4 >"},
+			},
+		},
+		{
+			name:    "strict",
+			mode:    parseStrict,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotErrs, err := parseAdif(adi, time.Now(), tt.mode)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAdif() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(gotErrs) != 3 {
+				t.Errorf("parseAdif() got %d record errors, want 3", len(gotErrs))
+			}
+			if tt.wantErr {
+				return
+			}
+			var gotCalls []string
+			for _, qso := range got.Qsos {
+				gotCalls = append(gotCalls, qso.ContactedStation.StationCall)
+			}
+			if !reflect.DeepEqual(gotCalls, tt.wantCalls) {
+				t.Errorf("parseAdif() calls got = %v, want %v", gotCalls, tt.wantCalls)
+			}
+			if !reflect.DeepEqual(gotErrs, tt.wantErrs) {
+				t.Errorf("parseAdif() errors got = %+v, want %+v", gotErrs, tt.wantErrs)
+			}
+			if lat := got.Qsos[0].ContactedStation.Latitude; lat != 39.4202 {
+				t.Errorf("parseAdif() latitude got = %v, want 39.4202", lat)
+			}
+			if got.Qsos[1].ContactedStation.Latitude != 0 || got.Qsos[1].Freq != 0 {
+				t.Errorf("parseAdif() bad fields got = %v", got.Qsos[1])
+			}
+		})
+	}
+}
+
+func Test_getLatLon(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want float64
+	}{
+		{"", 0},
+		{"N039 25.212", 39.4202},
+		{"W105 11.820", -105.197},
+		{"39.4202", 0},
+		{"N039", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			if got := getLatLon(tt.arg); got != tt.want {
+				t.Errorf("getLatLon() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
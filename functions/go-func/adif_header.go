@@ -0,0 +1,245 @@
+package forester
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// adifVersion is the ADIF spec version which the ADIF we write declares.
+const adifVersion = "3.1.4"
+
+// userDefsProperty is the logbook property holding the user-defined fields declared by the ADIF
+// imported into the logbook, as a JSON object of upper case name to UserDef. They're declared again
+// in the logbook's exports.
+const userDefsProperty = "adifUserDefs"
+
+// UserDef is a user-defined field, declared by a USERDEFn field in an ADIF header. Its values are
+// kept in each QSO's AppDefined, like other fields which aren't mapped. If it has an Enum or a
+// range, its values must be in it.
+type UserDef struct {
+	ID       int      `json:"id"`
+	Name     string   `json:"name"`
+	DataType string   `json:"dataType,omitempty"`
+	Enum     []string `json:"enum,omitempty"`
+	HasRange bool     `json:"hasRange,omitempty"`
+	Min      float64  `json:"min,omitempty"`
+	Max      float64  `json:"max,omitempty"`
+}
+
+// String is the USERDEF field value declaring u, like SweaterSize,{S,M,L}.
+func (u UserDef) String() string {
+	switch {
+	case len(u.Enum) > 0:
+		return u.Name + ",{" + strings.Join(u.Enum, ",") + "}"
+	case u.HasRange:
+		return fmt.Sprintf("%v,{%v:%v}", u.Name, u.Min, u.Max)
+	default:
+		return u.Name
+	}
+}
+
+func (u UserDef) check(value string) error {
+	if len(u.Enum) > 0 {
+		for _, e := range u.Enum {
+			if strings.EqualFold(e, value) {
+				return nil
+			}
+		}
+		return fmt.Errorf("not one of %v", strings.Join(u.Enum, ", "))
+	}
+	if u.DataType == "N" || u.HasRange {
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return errors.New("not a number")
+		}
+		if u.HasRange && (n < u.Min || n > u.Max) {
+			return fmt.Errorf("not between %v and %v", u.Min, u.Max)
+		}
+	}
+	return nil
+}
+
+// parseAdifHeader reads the header's fields. adifpb.Header has no place for user-defined fields, so
+// they're returned separately, keyed by upper case name.
+func parseAdifHeader(raw rawRecord) (*adifpb.Header, map[string]UserDef, *RecordError) {
+	header := new(adifpb.Header)
+	userDefs := map[string]UserDef{}
+	var recordErr *RecordError
+	fail := func(f rawField, err error) {
+		if recordErr == nil {
+			recordErr = &RecordError{Line: raw.line}
+		}
+		recordErr.Fields = append(recordErr.Fields, FieldError{
+			Field: f.name, Value: f.value, Line: f.line, Error: err.Error(),
+		})
+	}
+	for _, f := range raw.fields {
+		switch {
+		case f.name == "ADIF_VER":
+			header.AdifVersion = f.value
+		case f.name == "CREATED_TIMESTAMP":
+			t, err := time.Parse("20060102 150405", f.value)
+			if err != nil {
+				fail(f, errors.New("not a timestamp like YYYYMMDD HHMMSS"))
+				continue
+			}
+			header.CreatedTimestamp = timestamppb.New(t)
+		case f.name == "PROGRAMID":
+			header.ProgramId = f.value
+		case f.name == "PROGRAMVERSION":
+			header.ProgramVersion = f.value
+		case strings.HasPrefix(f.name, "USERDEF"):
+			userDef, err := parseUserDef(f)
+			if err != nil {
+				fail(f, err)
+				continue
+			}
+			userDefs[strings.ToUpper(userDef.Name)] = userDef
+		}
+	}
+	return header, userDefs, recordErr
+}
+
+// parseUserDef reads a declaration like <USERDEF2:19:E>SweaterSize,{S,M,L} or
+// <USERDEF3:15:N>ShoeSize,{5:20}.
+func parseUserDef(f rawField) (UserDef, error) {
+	id, err := strconv.Atoi(strings.TrimPrefix(f.name, "USERDEF"))
+	if err != nil || id < 1 {
+		return UserDef{}, errors.New("not a USERDEF number")
+	}
+	name, rest, _ := strings.Cut(f.value, ",")
+	userDef := UserDef{ID: id, Name: strings.TrimSpace(name), DataType: f.dataType}
+	if userDef.Name == "" {
+		return UserDef{}, errors.New("no field name")
+	}
+	rest = strings.TrimSpace(rest)
+	if rest == "" {
+		return userDef, nil
+	}
+	if !strings.HasPrefix(rest, "{") || !strings.HasSuffix(rest, "}") {
+		return UserDef{}, errors.New("values aren't in {braces}")
+	}
+	rest = rest[1 : len(rest)-1]
+	if low, high, isRange := strings.Cut(rest, ":"); isRange && !strings.Contains(rest, ",") {
+		userDef.Min, err = strconv.ParseFloat(strings.TrimSpace(low), 64)
+		if err != nil {
+			return UserDef{}, errors.New("range minimum isn't a number")
+		}
+		userDef.Max, err = strconv.ParseFloat(strings.TrimSpace(high), 64)
+		if err != nil {
+			return UserDef{}, errors.New("range maximum isn't a number")
+		}
+		userDef.HasRange = true
+		return userDef, nil
+	}
+	for _, e := range strings.Split(rest, ",") {
+		userDef.Enum = append(userDef.Enum, strings.TrimSpace(e))
+	}
+	return userDef, nil
+}
+
+// loadUserDefs reads the logbook's user-defined fields.
+func loadUserDefs(store QsoStore) (map[string]UserDef, error) {
+	userDefs := map[string]UserDef{}
+	prop, err := store.GetLogbookProperty(userDefsProperty)
+	if err != nil {
+		return nil, err
+	}
+	if prop == "" || prop == "<nil>" {
+		return userDefs, nil
+	}
+	err = json.Unmarshal([]byte(prop), &userDefs)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse %v: %w", userDefsProperty, err)
+	}
+	return userDefs, nil
+}
+
+// saveUserDefs adds the user-defined fields to the logbook's, replacing any of the same name. The
+// logbook numbers its fields itself, since different files may use the same numbers.
+func saveUserDefs(store QsoStore, declared []UserDef) error {
+	if len(declared) == 0 {
+		return nil
+	}
+	userDefs, err := loadUserDefs(store)
+	if err != nil {
+		return err
+	}
+	lastID := 0
+	for _, userDef := range userDefs {
+		lastID = max(lastID, userDef.ID)
+	}
+	for _, userDef := range declared {
+		name := strings.ToUpper(userDef.Name)
+		if existing, ok := userDefs[name]; ok {
+			userDef.ID = existing.ID
+		} else {
+			lastID++
+			userDef.ID = lastID
+		}
+		userDefs[name] = userDef
+	}
+	marshal, err := json.Marshal(userDefs)
+	if err != nil {
+		return err
+	}
+	return store.SetLogbookProperty(userDefsProperty, string(marshal))
+}
+
+// sortedUserDefs lists the user-defined fields by ID.
+func sortedUserDefs(userDefs map[string]UserDef) []UserDef {
+	sorted := make([]UserDef, 0, len(userDefs))
+	for _, userDef := range userDefs {
+		sorted = append(sorted, userDef)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	return sorted
+}
+
+// writeAdifHeader writes a header saying this program created the ADIF at the header's created
+// time, or now if it doesn't have one. The header's other fields describe the ADIF it was read from,
+// so they aren't written.
+func writeAdifHeader(w io.Writer, header *adifpb.Header, userDefs []UserDef) error {
+	var b strings.Builder
+	b.WriteString("Exported from Forester\n")
+	for _, f := range exportHeaderFields(header) {
+		writeHeaderField(&b, f.name, "", f.value)
+	}
+	for _, userDef := range userDefs {
+		writeHeaderField(&b, "USERDEF"+strconv.Itoa(userDef.ID), userDef.DataType, userDef.String())
+	}
+	b.WriteString("<EOH>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// exportHeaderFields are the header fields of ADIF this program writes, besides USERDEFs.
+func exportHeaderFields(header *adifpb.Header) []rawField {
+	created := time.Now()
+	if header.CreatedTimestamp != nil {
+		created = header.CreatedTimestamp.AsTime()
+	}
+	return []rawField{
+		{name: "ADIF_VER", value: adifVersion},
+		{name: "CREATED_TIMESTAMP", value: created.UTC().Format("20060102 150405")},
+		{name: "PROGRAMID", value: programID},
+		{name: "PROGRAMVERSION", value: programVersion},
+	}
+}
+
+func writeHeaderField(b *strings.Builder, name string, dataType string, value string) {
+	tag := name + ":" + strconv.Itoa(len(value))
+	if dataType != "" {
+		tag += ":" + dataType
+	}
+	_, _ = fmt.Fprintf(b, "<%s>%s\n", tag, value)
+}
@@ -0,0 +1,100 @@
+package forester
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const userDefAdif = `Exported by N1MM
+<ADIF_VER:5>3.1.4
+<CREATED_TIMESTAMP:15>20201101 123000
+<PROGRAMID:4>N1MM
+<PROGRAMVERSION:6>1.0.86
+<USERDEF1:3:N>EPC
+<USERDEF2:19:E>SweaterSize,{S,M,L}
+<USERDEF3:15:N>ShoeSize,{5:20}
+<EOH>
+<CALL:4>N6DN<QSO_DATE:8>20201025<TIME_ON:4>2015<EPC:5>12345<SWEATERSIZE:1>M<SHOESIZE:2>11<EOR>
+<CALL:4>K9IJ<QSO_DATE:8>20201025<TIME_ON:4>2016<SWEATERSIZE:2>XL<SHOESIZE:2>30<EOR>
+`
+
+func Test_adifDecoder_header(t *testing.T) {
+	decoder := newAdifDecoder(strings.NewReader(userDefAdif))
+	var qsos []*adifpb.Qso
+	for {
+		qso, err := decoder.Next()
+		if err != nil {
+			break
+		}
+		qsos = append(qsos, qso)
+	}
+	wantHeader := &adifpb.Header{
+		AdifVersion:      "3.1.4",
+		CreatedTimestamp: timestamppb.New(time.Date(2020, 11, 1, 12, 30, 0, 0, time.UTC)),
+		ProgramId:        "N1MM",
+		ProgramVersion:   "1.0.86",
+	}
+	if !proto.Equal(decoder.Header(), wantHeader) {
+		t.Errorf("Header() got = %v, want %v", decoder.Header(), wantHeader)
+	}
+	wantUserDefs := []UserDef{
+		{ID: 1, Name: "EPC", DataType: "N"},
+		{ID: 2, Name: "SweaterSize", DataType: "E", Enum: []string{"S", "M", "L"}},
+		{ID: 3, Name: "ShoeSize", DataType: "N", HasRange: true, Min: 5, Max: 20},
+	}
+	if !reflect.DeepEqual(decoder.UserDefs(), wantUserDefs) {
+		t.Errorf("UserDefs() got = %+v, want %+v", decoder.UserDefs(), wantUserDefs)
+	}
+	wantAppDefined := []map[string]string{
+		{"epc": "12345", "sweatersize": "M", "shoesize": "11"},
+		nil,
+	}
+	for i, qso := range qsos {
+		if !reflect.DeepEqual(qso.AppDefined, wantAppDefined[i]) {
+			t.Errorf("Next() app defined got = %v, want %v", qso.AppDefined, wantAppDefined[i])
+		}
+	}
+	errs := decoder.Errors()
+	if len(errs) != 1 || len(errs[0].Fields) != 2 || errs[0].Fields[0].Error != "not between 5 and 20" ||
+		errs[0].Fields[1].Error != "not one of S, M, L" {
+		t.Errorf("Errors() got = %+v", errs)
+	}
+}
+
+func Test_writeAdifHeader(t *testing.T) {
+	decoder := newAdifDecoder(strings.NewReader(userDefAdif))
+	_, _ = decoder.Next()
+	var b strings.Builder
+	err := writeAdifHeader(&b, decoder.Header(), decoder.UserDefs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := b.String()
+	for _, want := range []string{
+		"<ADIF_VER:5>3.1.4",
+		"<CREATED_TIMESTAMP:15>20201101 123000",
+		"<PROGRAMID:13>forester-func",
+		"<PROGRAMVERSION:" + strconv.Itoa(len(programVersion)) + ">" + programVersion,
+		"<USERDEF1:3:N>EPC",
+		"<USERDEF2:19:E>SweaterSize,{S,M,L}",
+		"<USERDEF3:15:N>ShoeSize,{5:20}",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("writeAdifHeader() got = %v, want %v", got, want)
+		}
+	}
+
+	// What's written can be read back
+	reread := newAdifDecoder(strings.NewReader(got + "<CALL:4>N6DN<QSO_DATE:8>20201025<EOR>"))
+	_, err = reread.Next()
+	if err != nil || !reflect.DeepEqual(reread.UserDefs(), decoder.UserDefs()) {
+		t.Errorf("writeAdifHeader() didn't round-trip: %v, %+v", err, reread.UserDefs())
+	}
+}
@@ -0,0 +1,186 @@
+package forester
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxAdifFieldLength is the longest field value the scanner accepts, so a bad length can't make it
+// allocate too much.
+const maxAdifFieldLength = 1 << 20
+
+// rawRecord is the ADIF text of one record, along with the line it starts on and its fields. If the
+// record is malformed, problem says why.
+type rawRecord struct {
+	text     string
+	line     int
+	fields   []rawField
+	isHeader bool
+	problem  string
+}
+
+// rawField is a field as it appeared in the ADIF. name is upper case, and dataType is the optional
+// data type indicator, like the N in <FREQ:6:N>.
+type rawField struct {
+	name     string
+	dataType string
+	value    string
+	line     int
+}
+
+// fieldLine is the line the field starts on, or 0 if the record doesn't have it.
+func (r rawRecord) fieldLine(name string) int {
+	for _, f := range r.fields {
+		if f.name == name {
+			return f.line
+		}
+	}
+	return 0
+}
+
+// adiText writes the fields as an ADI record, or a header, for the ADIF reader.
+func adiText(fields []rawField, isHeader bool) string {
+	var text strings.Builder
+	for _, f := range fields {
+		tag := f.name + ":" + strconv.Itoa(len(f.value))
+		if f.dataType != "" {
+			tag += ":" + f.dataType
+		}
+		text.WriteString("<" + tag + ">" + f.value)
+	}
+	if isHeader {
+		text.WriteString("<EOH>")
+	} else {
+		text.WriteString("<EOR>")
+	}
+	return text.String()
+}
+
+// adifScanner splits an ADIF document into records before they're parsed, so the records can be
+// reported by line number, and so a malformed record can be skipped without losing the rest. It
+// only holds one record at a time.
+type adifScanner struct {
+	r    *bufio.Reader
+	line int
+}
+
+func newAdifScanner(r io.Reader) *adifScanner {
+	return &adifScanner{r: bufio.NewReader(r), line: 1}
+}
+
+// next returns the next record, or io.EOF at the end of the document. A malformed record is
+// returned with its problem and the fields before it, after skipping to the end of it. Other errors
+// are from the reader.
+func (s *adifScanner) next() (rawRecord, error) {
+	var text strings.Builder
+	var rec rawRecord
+	for {
+		err := s.skipTo('<')
+		if err == io.EOF && text.Len() > 0 {
+			rec.problem = "unexpected end of file; the last record has no <EOR>"
+			return rec, nil
+		}
+		if err != nil {
+			return rec, err
+		}
+		if rec.line == 0 {
+			rec.line = s.line
+		}
+		tagLine := s.line
+		tag, err := s.readTo('>')
+		if err == io.EOF {
+			rec.problem = "unexpected end of file in a field name"
+			return rec, nil
+		}
+		if err != nil {
+			return rec, err
+		}
+		switch strings.ToUpper(tag) {
+		case "EOH":
+			rec.isHeader = true
+			fallthrough
+		case "EOR":
+			text.WriteString("<" + tag + ">")
+			rec.text = text.String()
+			return rec, nil
+		}
+		name, length, dataType, err := parseTag(tag)
+		if err != nil {
+			rec.problem = err.Error()
+			rec.isHeader, err = s.skipRecord()
+			return rec, err
+		}
+		value := make([]byte, length)
+		_, err = io.ReadFull(s.r, value)
+		s.line += strings.Count(string(value), "\n")
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			rec.problem = fmt.Sprintf("unexpected end of file in the value of %v", name)
+			return rec, nil
+		}
+		if err != nil {
+			return rec, err
+		}
+		rec.fields = append(rec.fields, rawField{strings.ToUpper(name), strings.ToUpper(dataType), string(value), tagLine})
+		text.WriteString("<" + tag + ">")
+		text.Write(value)
+	}
+}
+
+// parseTag reads a field tag like CALL:4 or FREQ:6:N, without the angle brackets.
+func parseTag(tag string) (name string, length int, dataType string, err error) {
+	parts := strings.Split(tag, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+		return "", 0, "", fmt.Errorf("malformed field <%v>", tag)
+	}
+	length, err = strconv.Atoi(parts[1])
+	if err != nil || length < 0 {
+		return "", 0, "", fmt.Errorf("malformed length in field <%v>", tag)
+	}
+	if length > maxAdifFieldLength {
+		return "", 0, "", fmt.Errorf("field <%v> is too long", tag)
+	}
+	if len(parts) == 3 {
+		dataType = parts[2]
+	}
+	return parts[0], length, dataType, nil
+}
+
+// skipRecord skips past the next <EOR>, or the <EOH> if the malformed record is the header, which
+// it says. Reaching the end of the file is fine.
+func (s *adifScanner) skipRecord() (bool, error) {
+	for {
+		err := s.skipTo('<')
+		if err == nil {
+			var tag string
+			tag, err = s.readTo('>')
+			if err == nil && (strings.EqualFold(tag, "EOR") || strings.EqualFold(tag, "EOH")) {
+				return strings.EqualFold(tag, "EOH"), nil
+			}
+		}
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+// skipTo skips past the next occurrence of delim.
+func (s *adifScanner) skipTo(delim byte) error {
+	_, err := s.readTo(delim)
+	return err
+}
+
+// readTo reads up to the next occurrence of delim, returning what came before it.
+func (s *adifScanner) readTo(delim byte) (string, error) {
+	st, err := s.r.ReadString(delim)
+	s.line += strings.Count(st, "\n")
+	if err != nil {
+		return "", err
+	}
+	return st[:len(st)-1], nil
+}
@@ -0,0 +1,188 @@
+package forester
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/farmergreg/adif/v5"
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+)
+
+// adifDecoder reads QSOs from ADIF one at a time, so a large log doesn't have to fit in memory.
+// Like a lenient parseAdif, it leaves out fields and skips records which can't be parsed, and
+// keeps track of them. Problems with the header are reported as record 0.
+type adifDecoder struct {
+	scanner  recordScanner
+	header   *adifpb.Header
+	userDefs map[string]UserDef
+	records  int
+	errs     []RecordError
+}
+
+// recordScanner splits ADIF into records; see adifScanner and adxScanner.
+type recordScanner interface {
+	next() (rawRecord, error)
+}
+
+// newAdifDecoder reads either ADIF format, going by how the content starts.
+func newAdifDecoder(r io.Reader) *adifDecoder {
+	br := bufio.NewReader(r)
+	if isAdx(br) {
+		return &adifDecoder{scanner: newAdxScanner(br)}
+	}
+	return &adifDecoder{scanner: newAdifScanner(br)}
+}
+
+// Next reads the next QSO, or returns io.EOF after the last one. Other errors are from the reader.
+func (d *adifDecoder) Next() (*adifpb.Qso, error) {
+	for {
+		raw, err := d.scanner.next()
+		if err != nil {
+			return nil, err
+		}
+		if raw.isHeader {
+			var headerErr *RecordError
+			d.header, d.userDefs, headerErr = parseAdifHeader(raw)
+			if raw.problem != "" {
+				// The fields before the malformed one are still read
+				if headerErr == nil {
+					headerErr = &RecordError{Line: raw.line}
+				}
+				headerErr.Error = raw.problem
+			}
+			if headerErr != nil {
+				d.errs = append(d.errs, *headerErr)
+			}
+			continue
+		}
+		d.records++
+		if raw.problem != "" {
+			d.errs = append(d.errs, RecordError{Record: d.records, Line: raw.line, Skipped: true, Error: raw.problem})
+			continue
+		}
+		qso, recordErr := parseRawRecord(raw, d.userDefs)
+		if recordErr != nil {
+			recordErr.Record = d.records
+			d.errs = append(d.errs, *recordErr)
+		}
+		if qso != nil {
+			return qso, nil
+		}
+	}
+}
+
+// Header returns the ADIF's header, or nil if it doesn't have one. It's read with the first QSO.
+func (d *adifDecoder) Header() *adifpb.Header {
+	return d.header
+}
+
+// UserDefs returns the user-defined fields declared in the header, by ID.
+func (d *adifDecoder) UserDefs() []UserDef {
+	return sortedUserDefs(d.userDefs)
+}
+
+// Errors returns the problems with the records read so far.
+func (d *adifDecoder) Errors() []RecordError {
+	return d.errs
+}
+
+// qsoDecoder reads QSOs from an uploaded log one at a time; see adifDecoder and cabrilloDecoder.
+type qsoDecoder interface {
+	// Next reads the next QSO, or returns io.EOF after the last one.
+	Next() (*adifpb.Qso, error)
+	// Errors returns the problems with the records read so far.
+	Errors() []RecordError
+}
+
+// newQsoDecoder reads ADI, ADX or Cabrillo, going by how the content starts.
+func newQsoDecoder(r io.Reader) qsoDecoder {
+	br := bufio.NewReader(r)
+	if isCabrillo(br) {
+		return newCabrilloDecoder(br)
+	}
+	return newAdifDecoder(br)
+}
+
+// adifFormat is one of the ADIF file formats: ADI, the tagged text format, or ADX, the XML one.
+type adifFormat string
+
+const (
+	formatAdi adifFormat = "adi"
+	formatAdx adifFormat = "adx"
+)
+
+// parseAdifFormat reads a format name like "adx", defaulting to ADI.
+func parseAdifFormat(name string) (adifFormat, error) {
+	switch adifFormat(strings.ToLower(name)) {
+	case "", formatAdi:
+		return formatAdi, nil
+	case formatAdx:
+		return formatAdx, nil
+	default:
+		return "", fmt.Errorf("unknown ADIF format %q, want adi or adx", name)
+	}
+}
+
+// qsoEncoder writes QSOs in one of the ADIF formats one at a time, so a large log doesn't have to
+// fit in memory.
+type qsoEncoder interface {
+	// WriteHeader writes a header; see writeAdifHeader. If it's called, it must be called first.
+	WriteHeader(header *adifpb.Header, userDefs []UserDef) error
+	// Encode writes the QSO. It may be buffered until Close.
+	Encode(qso *adifpb.Qso) error
+	// Close writes anything buffered and ends the document. It doesn't close the writer.
+	Close() error
+}
+
+func newQsoEncoder(w io.Writer, format adifFormat) qsoEncoder {
+	if format == formatAdx {
+		return newAdxEncoder(w)
+	}
+	return newAdifEncoder(w)
+}
+
+// recordWriter is the part of the ADIF document writer which adifEncoder uses.
+type recordWriter interface {
+	WriteRecord(record adif.Record) error
+	Flush() error
+}
+
+// adifEncoder is a qsoEncoder for ADI.
+type adifEncoder struct {
+	w      io.Writer
+	writer recordWriter
+}
+
+func newAdifEncoder(w io.Writer) *adifEncoder {
+	return &adifEncoder{w: w, writer: adif.NewADIDocumentWriter(w)}
+}
+
+func (e *adifEncoder) WriteHeader(header *adifpb.Header, userDefs []UserDef) error {
+	return writeAdifHeader(e.w, header, userDefs)
+}
+
+func (e *adifEncoder) Encode(qso *adifpb.Qso) error {
+	return e.writer.WriteRecord(writeQso(qso))
+}
+
+func (e *adifEncoder) Close() error {
+	return e.writer.Flush()
+}
+
+// fixedDecoder passes each QSO which the decoder reads through fix, which corrects a remote
+// logbook's quirks; see fixLotwQsl.
+type fixedDecoder struct {
+	qsoDecoder
+	fix func(qso *adifpb.Qso)
+}
+
+func (d fixedDecoder) Next() (*adifpb.Qso, error) {
+	qso, err := d.qsoDecoder.Next()
+	if err != nil {
+		return nil, err
+	}
+	d.fix(qso)
+	return qso, nil
+}
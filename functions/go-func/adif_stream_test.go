@@ -0,0 +1,97 @@
+package forester
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+type failingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		return n, f.err
+	}
+	return n, err
+}
+
+func Test_adifDecoder(t *testing.T) {
+	const adi = `<eoh>
+<call:4>N6DN<qso_date:8>20201025<time_on:4>2015<eor>
+<call:4>K9IJ<qso_date:8>2020102<time_on:4>2016<eor>
+<call:6>KE0RCW<qso_date:8>20201025<time_on:4>2017<eor>
+<call:4>W1AW<qso_date:8>2020`
+	tests := []struct {
+		name      string
+		r         io.Reader
+		wantCalls []string
+		wantErrs  int
+		wantErr   error
+	}{
+		{
+			name:      "truncated file",
+			r:         strings.NewReader(adi),
+			wantCalls: []string{"N6DN", "KE0RCW"},
+			wantErrs:  2,
+			wantErr:   io.EOF,
+		},
+		{
+			name:      "reader fails",
+			r:         &failingReader{strings.NewReader(adi), errors.New("request body too large")},
+			wantCalls: []string{"N6DN", "KE0RCW"},
+			wantErrs:  1,
+			wantErr:   errors.New("request body too large"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoder := newAdifDecoder(tt.r)
+			var gotCalls []string
+			var err error
+			for {
+				qso, nextErr := decoder.Next()
+				if nextErr != nil {
+					err = nextErr
+					break
+				}
+				gotCalls = append(gotCalls, qso.ContactedStation.StationCall)
+			}
+			if err.Error() != tt.wantErr.Error() {
+				t.Errorf("Next() error = %v, want %v", err, tt.wantErr)
+			}
+			if strings.Join(gotCalls, " ") != strings.Join(tt.wantCalls, " ") {
+				t.Errorf("Next() got = %v, want %v", gotCalls, tt.wantCalls)
+			}
+			if len(decoder.Errors()) != tt.wantErrs {
+				t.Errorf("Errors() got = %v, want %d", decoder.Errors(), tt.wantErrs)
+			}
+		})
+	}
+}
+
+func Test_adifDecoder_badHeaderField(t *testing.T) {
+	const adi = `Exported by a logger
+<programid:7>Logger1<adif_ver:x>3.1.4<programversion:3>1.0<eoh>
+<call:4>N6DN<qso_date:8>20201025<time_on:4>2015<eor>
+`
+	decoder := newAdifDecoder(strings.NewReader(adi))
+	qso, err := decoder.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if qso.ContactedStation.StationCall != "N6DN" {
+		t.Errorf("Next() got = %v, want N6DN", qso.ContactedStation.StationCall)
+	}
+	if decoder.Header().GetProgramId() != "Logger1" {
+		t.Errorf("Header() got = %v, want the fields before the bad one", decoder.Header())
+	}
+	errs := decoder.Errors()
+	if len(errs) != 1 || errs[0].Record != 0 || errs[0].Line != 2 || errs[0].Error != "malformed length in field <adif_ver:x>" {
+		t.Errorf("Errors() got = %+v, want one header error", errs)
+	}
+}
@@ -0,0 +1,272 @@
+package forester
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/farmergreg/spec/v6/adifield"
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+)
+
+// isAdx says whether the ADIF is ADX, the XML format, rather than ADI. ADI can't start with an XML
+// declaration or an <ADX> tag, so the first few bytes are enough to tell.
+func isAdx(r *bufio.Reader) bool {
+	start, _ := r.Peek(512)
+	start = bytes.TrimPrefix(start, []byte("\xef\xbb\xbf"))
+	start = bytes.ToUpper(bytes.TrimSpace(start))
+	return bytes.HasPrefix(start, []byte("<?XML")) || bytes.HasPrefix(start, []byte("<ADX"))
+}
+
+// adxScanner splits an ADX document into records like adifScanner does for ADI, so the rest of the
+// parsing is shared. Each record is rewritten as ADI text, with APP and USERDEF elements named the
+// way ADI names them. XML which isn't well-formed can't be resynced, so it's an error rather than
+// a record problem.
+type adxScanner struct {
+	d *xml.Decoder
+}
+
+func newAdxScanner(r io.Reader) *adxScanner {
+	return &adxScanner{d: xml.NewDecoder(r)}
+}
+
+// next returns the next HEADER or RECORD, or io.EOF at the end of the document.
+func (s *adxScanner) next() (rawRecord, error) {
+	for {
+		tok, err := s.d.Token()
+		if err != nil {
+			return rawRecord{}, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch strings.ToUpper(start.Name.Local) {
+		case "ADX", "RECORDS":
+			// Their children are read in turn
+		case "HEADER":
+			return s.readRecord(true)
+		case "RECORD":
+			return s.readRecord(false)
+		default:
+			err = s.d.Skip()
+			if err != nil {
+				return rawRecord{}, err
+			}
+		}
+	}
+}
+
+// readRecord reads the fields of the HEADER or RECORD element which was just started.
+func (s *adxScanner) readRecord(isHeader bool) (rawRecord, error) {
+	rec := rawRecord{line: s.line(), isHeader: isHeader}
+	for {
+		tok, err := s.d.Token()
+		if err != nil {
+			return rec, unexpectedEOF(err)
+		}
+		switch tok := tok.(type) {
+		case xml.EndElement:
+			rec.text = adiText(rec.fields, isHeader)
+			return rec, nil
+		case xml.StartElement:
+			line := s.line()
+			value, nested, err := s.readValue()
+			if err != nil {
+				return rec, err
+			}
+			if rec.problem != "" {
+				continue
+			}
+			if nested {
+				rec.problem = fmt.Sprintf("element <%v> on line %d has elements in it", tok.Name.Local, line)
+				continue
+			}
+			f, err := adxField(tok, value, isHeader)
+			if err != nil {
+				rec.problem = fmt.Sprintf("%v on line %d", err, line)
+				continue
+			}
+			f.line = line
+			rec.fields = append(rec.fields, f)
+		}
+	}
+}
+
+// readValue reads the text of the field element which was just started, and whether it had other
+// elements in it, which fields mustn't.
+func (s *adxScanner) readValue() (string, bool, error) {
+	var value strings.Builder
+	nested := false
+	for depth := 1; depth > 0; {
+		tok, err := s.d.Token()
+		if err != nil {
+			return "", false, unexpectedEOF(err)
+		}
+		switch tok := tok.(type) {
+		case xml.CharData:
+			if depth == 1 {
+				value.Write(tok)
+			}
+		case xml.StartElement:
+			nested = true
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+	}
+	return value.String(), nested, nil
+}
+
+// adxField converts a field element to the field ADI would have. <APP PROGRAMID="X" FIELDNAME="Y">
+// is APP_X_Y, <USERDEF FIELDNAME="Y"> in a record is Y, and <USERDEF FIELDID="n" ...> in the header
+// is USERDEFn, with the enumeration or range after the name like in ADI.
+func adxField(start xml.StartElement, value string, isHeader bool) (rawField, error) {
+	attrs := map[string]string{}
+	for _, attr := range start.Attr {
+		attrs[strings.ToUpper(attr.Name.Local)] = attr.Value
+	}
+	f := rawField{name: strings.ToUpper(start.Name.Local), dataType: strings.ToUpper(attrs["TYPE"]), value: value}
+	switch {
+	case f.name == "APP":
+		if attrs["PROGRAMID"] == "" || attrs["FIELDNAME"] == "" {
+			return f, errors.New("<APP> needs PROGRAMID and FIELDNAME attributes")
+		}
+		f.name = strings.ToUpper("APP_" + attrs["PROGRAMID"] + "_" + attrs["FIELDNAME"])
+	case f.name == "USERDEF" && isHeader:
+		if attrs["FIELDID"] == "" {
+			return f, errors.New("<USERDEF> needs a FIELDID attribute")
+		}
+		f.name = "USERDEF" + attrs["FIELDID"]
+		if enum := attrs["ENUM"]; enum != "" {
+			f.value += "," + enum
+		} else if valueRange := attrs["RANGE"]; valueRange != "" {
+			f.value += "," + valueRange
+		}
+	case f.name == "USERDEF":
+		if attrs["FIELDNAME"] == "" {
+			return f, errors.New("<USERDEF> needs a FIELDNAME attribute")
+		}
+		f.name = strings.ToUpper(attrs["FIELDNAME"])
+	}
+	return f, nil
+}
+
+func (s *adxScanner) line() int {
+	line, _ := s.d.InputPos()
+	return line
+}
+
+// unexpectedEOF turns an io.EOF in the middle of the document into an error, since the caller
+// would take io.EOF as the end of it.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// adxEncoder is a qsoEncoder for ADX. The fields of each record are written in name order;
+// APP_ fields and user-defined fields are written as APP and USERDEF elements.
+type adxEncoder struct {
+	w         *bufio.Writer
+	userDefs  map[string]bool
+	started   bool
+	inRecords bool
+}
+
+func newAdxEncoder(w io.Writer) *adxEncoder {
+	return &adxEncoder{w: bufio.NewWriter(w), userDefs: map[string]bool{}}
+}
+
+// WriteHeader writes the same header as writeAdifHeader does for ADI.
+func (e *adxEncoder) WriteHeader(header *adifpb.Header, userDefs []UserDef) error {
+	e.writeProlog()
+	e.w.WriteString("<HEADER>\n")
+	for _, f := range exportHeaderFields(header) {
+		e.writeElement(f.name, "", f.value)
+	}
+	for _, userDef := range userDefs {
+		e.userDefs[strings.ToUpper(userDef.Name)] = true
+		attrs := fmt.Sprintf(` FIELDID="%d"`, userDef.ID)
+		if userDef.DataType != "" {
+			attrs += ` TYPE="` + escapeXML(userDef.DataType) + `"`
+		}
+		if len(userDef.Enum) > 0 {
+			attrs += ` ENUM="{` + escapeXML(strings.Join(userDef.Enum, ",")) + `}"`
+		} else if userDef.HasRange {
+			attrs += fmt.Sprintf(` RANGE="{%v:%v}"`, userDef.Min, userDef.Max)
+		}
+		e.writeElement("USERDEF", attrs, userDef.Name)
+	}
+	_, err := e.w.WriteString("</HEADER>\n")
+	return err
+}
+
+func (e *adxEncoder) Encode(qso *adifpb.Qso) error {
+	e.startRecords()
+	record := writeQso(qso)
+	var names []string
+	for field := range record.Fields() {
+		names = append(names, strings.ToUpper(string(field)))
+	}
+	sort.Strings(names)
+	e.w.WriteString("<RECORD>\n")
+	for _, name := range names {
+		value := record.Get(adifield.New(name))
+		if value == "" {
+			continue
+		}
+		switch {
+		case e.userDefs[name]:
+			e.writeElement("USERDEF", ` FIELDNAME="`+escapeXML(name)+`"`, value)
+		case strings.HasPrefix(name, "APP_"):
+			programID, fieldName, _ := strings.Cut(strings.TrimPrefix(name, "APP_"), "_")
+			e.writeElement("APP", fmt.Sprintf(` PROGRAMID="%v" FIELDNAME="%v" TYPE="S"`,
+				escapeXML(programID), escapeXML(fieldName)), value)
+		default:
+			e.writeElement(name, "", value)
+		}
+	}
+	_, err := e.w.WriteString("</RECORD>\n")
+	return err
+}
+
+func (e *adxEncoder) Close() error {
+	e.startRecords()
+	e.w.WriteString("</RECORDS>\n</ADX>\n")
+	return e.w.Flush()
+}
+
+// writeProlog starts the document, if it hasn't been started.
+func (e *adxEncoder) writeProlog() {
+	if !e.started {
+		e.w.WriteString(xml.Header + "<ADX>\n")
+		e.started = true
+	}
+}
+
+// startRecords opens <RECORDS>, if it hasn't been opened. It comes after the header, so it's opened
+// by the first record, or by Close if there aren't any.
+func (e *adxEncoder) startRecords() {
+	e.writeProlog()
+	if !e.inRecords {
+		e.w.WriteString("<RECORDS>\n")
+		e.inRecords = true
+	}
+}
+
+func (e *adxEncoder) writeElement(name string, attrs string, value string) {
+	_, _ = fmt.Fprintf(e.w, "<%v%v>%v</%v>\n", name, attrs, escapeXML(value), name)
+}
+
+func escapeXML(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
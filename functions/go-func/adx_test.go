@@ -0,0 +1,178 @@
+package forester
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const userDefAdx = `<?xml version="1.0" encoding="UTF-8"?>
+<ADX>
+  <HEADER>
+    <ADIF_VER>3.1.4</ADIF_VER>
+    <PROGRAMID>N1MM</PROGRAMID>
+    <USERDEF FIELDID="1" TYPE="N">EPC</USERDEF>
+    <USERDEF FIELDID="2" TYPE="E" ENUM="{S,M,L}">SweaterSize</USERDEF>
+  </HEADER>
+  <RECORDS>
+    <RECORD>
+      <CALL>N6DN</CALL>
+      <QSO_DATE>20201025</QSO_DATE>
+      <TIME_ON>2015</TIME_ON>
+      <NAME>Paul &amp; Co</NAME>
+      <APP PROGRAMID="N1MM" FIELDNAME="ID" TYPE="S">123</APP>
+      <USERDEF FIELDNAME="SweaterSize">M</USERDEF>
+    </RECORD>
+    <RECORD>
+      <CALL>K9IJ<B>bold</B></CALL>
+      <QSO_DATE>20201025</QSO_DATE>
+    </RECORD>
+    <RECORD>
+      <CALL>KE0RCW</CALL>
+      <QSO_DATE>20201026</QSO_DATE>
+      <TIME_ON>0000</TIME_ON>
+      <USERDEF FIELDNAME="SweaterSize">XL</USERDEF>
+    </RECORD>
+  </RECORDS>
+</ADX>
+`
+
+func Test_isAdx(t *testing.T) {
+	tests := []struct {
+		name  string
+		start string
+		want  bool
+	}{
+		{name: "declaration", start: `<?xml version="1.0"?><ADX>`, want: true},
+		{name: "no declaration", start: "\n  <adx><RECORDS>", want: true},
+		{name: "byte order mark", start: "\xef\xbb\xbf<?xml version=\"1.0\"?>", want: true},
+		{name: "ADI", start: "<CALL:4>N6DN<EOR>", want: false},
+		{name: "ADI header", start: "Exported from WSJT-X<EOH>", want: false},
+		{name: "empty", start: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isAdx(bufio.NewReader(strings.NewReader(tt.start))); got != tt.want {
+				t.Errorf("isAdx() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_adifDecoder_adx(t *testing.T) {
+	decoder := newAdifDecoder(strings.NewReader(userDefAdx))
+	var qsos []*adifpb.Qso
+	for {
+		qso, err := decoder.Next()
+		if err != nil {
+			break
+		}
+		qsos = append(qsos, qso)
+	}
+	wantHeader := &adifpb.Header{AdifVersion: "3.1.4", ProgramId: "N1MM"}
+	if !proto.Equal(decoder.Header(), wantHeader) {
+		t.Errorf("Header() got = %v, want %v", decoder.Header(), wantHeader)
+	}
+	wantUserDefs := []UserDef{
+		{ID: 1, Name: "EPC", DataType: "N"},
+		{ID: 2, Name: "SweaterSize", DataType: "E", Enum: []string{"S", "M", "L"}},
+	}
+	if !reflect.DeepEqual(decoder.UserDefs(), wantUserDefs) {
+		t.Errorf("UserDefs() got = %+v, want %+v", decoder.UserDefs(), wantUserDefs)
+	}
+	if len(qsos) != 2 {
+		t.Fatalf("Next() got %d QSOs, want 2", len(qsos))
+	}
+	if got := qsos[0].ContactedStation.OpName; got != "Paul & Co" {
+		t.Errorf("Next() name got = %v, want Paul & Co", got)
+	}
+	wantAppDefined := map[string]string{"app_n1mm_id": "123", "sweatersize": "M"}
+	if !reflect.DeepEqual(qsos[0].AppDefined, wantAppDefined) {
+		t.Errorf("Next() AppDefined got = %v, want %v", qsos[0].AppDefined, wantAppDefined)
+	}
+	errs := decoder.Errors()
+	if len(errs) != 2 {
+		t.Fatalf("Errors() got = %+v, want 2", errs)
+	}
+	if errs[0].Record != 2 || errs[0].Line != 18 || !errs[0].Skipped {
+		t.Errorf("Errors() got = %+v, want record 2 on line 18 skipped", errs[0])
+	}
+	if errs[1].Record != 3 || errs[1].Skipped || len(errs[1].Fields) != 1 || errs[1].Fields[0].Line != 26 {
+		t.Errorf("Errors() got = %+v, want record 3 with a bad field on line 26", errs[1])
+	}
+}
+
+func Test_adifDecoder_adxSyntaxError(t *testing.T) {
+	adx := `<?xml version="1.0"?><ADX><RECORDS><RECORD><CALL>N6DN</RECORD></RECORDS></ADX>`
+	decoder := newAdifDecoder(strings.NewReader(adx))
+	if _, err := decoder.Next(); err == nil {
+		t.Errorf("Next() got nil error for malformed XML")
+	}
+}
+
+func Test_adxRoundTrip(t *testing.T) {
+	pb := &adifpb.Adif{
+		Header: &adifpb.Header{CreatedTimestamp: timestamppb.New(time.Date(2020, 11, 1, 12, 30, 0, 0, time.UTC))},
+		Qsos: []*adifpb.Qso{{
+			TimeOn:           timestamppb.New(time.Date(2020, 10, 25, 20, 15, 0, 0, time.UTC)),
+			Band:             "20m",
+			Mode:             "SSB",
+			Comment:          "<big> signal & \"loud\"",
+			LoggingStation:   &adifpb.Station{StationCall: "K0SWE"},
+			ContactedStation: &adifpb.Station{StationCall: "N6DN", OpName: "Jürgen"},
+			Propagation:      &adifpb.Propagation{},
+			Lotw:             &adifpb.Qsl{SentStatus: "Y", ReceivedStatus: "Y"},
+			AppDefined:       map[string]string{"app_n1mm_id": "123", "pota_ref": "K-0001"},
+		}},
+	}
+	adx, err := protoToAdx(pb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<CREATED_TIMESTAMP>20201101 123000</CREATED_TIMESTAMP>",
+		`<APP PROGRAMID="N1MM" FIELDNAME="ID" TYPE="S">123</APP>`,
+		"<POTA_REF>K-0001</POTA_REF>",
+		"</RECORDS>\n</ADX>",
+	} {
+		if !strings.Contains(adx, want) {
+			t.Errorf("protoToAdx() got = %v, want %v", adx, want)
+		}
+	}
+	got, _, err := parseAdif(adx, time.Now(), parseStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(got.Qsos[0], pb.Qsos[0]) {
+		t.Errorf("parseAdif() got = %v, want %v", got.Qsos[0], pb.Qsos[0])
+	}
+	adi, err := protoToAdif(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromAdi, _, err := parseAdif(adi, time.Now(), parseStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(fromAdi.Qsos[0], pb.Qsos[0]) {
+		t.Errorf("parseAdif() from ADI got = %v, want %v", fromAdi.Qsos[0], pb.Qsos[0])
+	}
+}
+
+func Test_adxEncoder_empty(t *testing.T) {
+	var b strings.Builder
+	encoder := newAdxEncoder(&b)
+	if err := encoder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<ADX>\n<RECORDS>\n</RECORDS>\n</ADX>\n"
+	if got := b.String(); got != want {
+		t.Errorf("Close() got = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,371 @@
+package forester
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+)
+
+// cabrilloVersion is the Cabrillo spec version which the logs we write declare.
+const cabrilloVersion = "3.0"
+
+// CabrilloHeader is what a Cabrillo log says about the entry. Each field is written as the tag of
+// the same name, like CATEGORY-OPERATOR for CategoryOperator; empty ones are left out.
+type CabrilloHeader struct {
+	Contest             string
+	Callsign            string
+	Location            string
+	CategoryOperator    string
+	CategoryAssisted    string
+	CategoryBand        string
+	CategoryMode        string
+	CategoryPower       string
+	CategoryStation     string
+	CategoryTransmitter string
+	ClaimedScore        string
+	Club                string
+	Operators           []string
+	Name                string
+	Address             []string
+	AddressCity         string
+	AddressStateProv    string
+	AddressPostalcode   string
+	AddressCountry      string
+	GridLocator         string
+	Email               string
+	Soapbox             []string
+}
+
+// sentExchange is the part of the exchange we sent which is the same for every contact, so it isn't
+// kept on each QSO.
+type sentExchange struct {
+	// class is the Field Day class, like 2A.
+	class string
+	// precedence and check are the Sweepstakes precedence, like A, and check, like 99.
+	precedence string
+	check      string
+	// name is the name sent in NAQP.
+	name string
+	// location is the ARRL section, state or province sent, like CO.
+	location string
+	// cqZone is the CQ zone sent in CQ WW.
+	cqZone string
+}
+
+// cabrilloLayout is the exchange of a contest's QSO: lines. Each side of the exchange has the same
+// fields, which are padded to the widths so the columns line up.
+type cabrilloLayout struct {
+	widths   []int
+	sent     func(qso *adifpb.Qso, sent sentExchange) []string
+	received func(qso *adifpb.Qso) []string
+	// section is whether the location sent is an ARRL section. A section isn't always the station's
+	// state, so it has to be given rather than worked out from the QSOs.
+	section bool
+	// missing lists the parts of the exchange we sent which the contest needs but which aren't set,
+	// by their params; see parseCabrilloRequest.
+	missing func(sent sentExchange) []string
+	// read sets the QSO's exchange from the fields of a QSO: line, which has one for each width on
+	// each side.
+	read func(qso *adifpb.Qso, sent []string, received []string)
+}
+
+// missingParams lists the params, given with their values as name, value pairs, which are empty.
+func missingParams(pairs ...string) []string {
+	var missing []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] == "" {
+			missing = append(missing, pairs[i])
+		}
+	}
+	return missing
+}
+
+var (
+	fieldDayLayout = cabrilloLayout{
+		widths:  []int{3, 3},
+		section: true,
+		sent: func(qso *adifpb.Qso, sent sentExchange) []string {
+			return []string{sent.class, sent.location}
+		},
+		received: func(qso *adifpb.Qso) []string {
+			return []string{qso.Contest.GetStationClass(), qso.Contest.GetArrlSection()}
+		},
+		missing: func(sent sentExchange) []string {
+			return missingParams("sentClass", sent.class, "location", sent.location)
+		},
+		read: func(qso *adifpb.Qso, sent []string, received []string) {
+			qso.Contest.StationClass = received[0]
+			qso.Contest.ArrlSection = received[1]
+		},
+	}
+	sweepstakesLayout = cabrilloLayout{
+		widths:  []int{4, 1, 2, 3},
+		section: true,
+		sent: func(qso *adifpb.Qso, sent sentExchange) []string {
+			return []string{qso.Contest.GetSerialSent(), sent.precedence, sent.check, sent.location}
+		},
+		received: func(qso *adifpb.Qso) []string {
+			c := qso.Contest
+			return []string{c.GetSerialReceived(), c.GetPrecedence(), c.GetCheck(), c.GetArrlSection()}
+		},
+		missing: func(sent sentExchange) []string {
+			return missingParams("sentPrecedence", sent.precedence, "sentCheck", sent.check, "location", sent.location)
+		},
+		read: func(qso *adifpb.Qso, sent []string, received []string) {
+			qso.Contest.SerialSent = sent[0]
+			qso.Contest.SerialReceived = received[0]
+			qso.Contest.Precedence = received[1]
+			qso.Contest.Check = received[2]
+			qso.Contest.ArrlSection = received[3]
+		},
+	}
+	cqWorldWideLayout = cabrilloLayout{
+		widths: []int{3, 2},
+		sent: func(qso *adifpb.Qso, sent sentExchange) []string {
+			zone := sent.cqZone
+			if zone == "" && qso.LoggingStation.GetCqZone() != 0 {
+				zone = strconv.Itoa(int(qso.LoggingStation.GetCqZone()))
+			}
+			return []string{cabrilloRst(qso.RstSent, qso), zone}
+		},
+		received: func(qso *adifpb.Qso) []string {
+			zone := ""
+			if qso.ContactedStation.GetCqZone() != 0 {
+				zone = strconv.Itoa(int(qso.ContactedStation.GetCqZone()))
+			}
+			return []string{cabrilloRst(qso.RstReceived, qso), zone}
+		},
+		read: func(qso *adifpb.Qso, sent []string, received []string) {
+			qso.RstSent = sent[0]
+			qso.LoggingStation.CqZone = getUint32(sent[1])
+			qso.RstReceived = received[0]
+			qso.ContactedStation.CqZone = getUint32(received[1])
+		},
+	}
+	naqpLayout = cabrilloLayout{
+		widths: []int{10, 3},
+		sent: func(qso *adifpb.Qso, sent sentExchange) []string {
+			return []string{sent.name, sent.location}
+		},
+		received: func(qso *adifpb.Qso) []string {
+			location := qso.ContactedStation.GetState()
+			if location == "" {
+				location = qso.ContactedStation.GetPfx()
+			}
+			return []string{firstName(qso.ContactedStation.GetOpName()), location}
+		},
+		missing: func(sent sentExchange) []string {
+			return missingParams("sentName", sent.name, "location", sent.location)
+		},
+		read: func(qso *adifpb.Qso, sent []string, received []string) {
+			if qso.LoggingStation.OpName == "" {
+				qso.LoggingStation.OpName = sent[0]
+			}
+			if qso.LoggingStation.State == "" {
+				qso.LoggingStation.State = sent[1]
+			}
+			qso.ContactedStation.OpName = received[0]
+			qso.ContactedStation.State = received[1]
+		},
+	}
+	// serialLayout is the signal report and serial number exchange which many other contests use.
+	serialLayout = cabrilloLayout{
+		widths: []int{3, 4},
+		sent: func(qso *adifpb.Qso, sent sentExchange) []string {
+			return []string{cabrilloRst(qso.RstSent, qso), qso.Contest.GetSerialSent()}
+		},
+		received: func(qso *adifpb.Qso) []string {
+			return []string{cabrilloRst(qso.RstReceived, qso), qso.Contest.GetSerialReceived()}
+		},
+		read: func(qso *adifpb.Qso, sent []string, received []string) {
+			qso.RstSent = sent[0]
+			qso.Contest.SerialSent = sent[1]
+			qso.RstReceived = received[0]
+			qso.Contest.SerialReceived = received[1]
+		},
+	}
+)
+
+// cabrilloLayouts are the exchanges of contests by their ADIF CONTEST_ID. Other contests use
+// serialLayout.
+var cabrilloLayouts = map[string]cabrilloLayout{
+	"ARRL-FD":     fieldDayLayout,
+	"ARRL-SS-CW":  sweepstakesLayout,
+	"ARRL-SS-SSB": sweepstakesLayout,
+	"CQ-WW-CW":    cqWorldWideLayout,
+	"CQ-WW-SSB":   cqWorldWideLayout,
+	"CQ-WW-RTTY":  cqWorldWideLayout,
+	"NAQP-CW":     naqpLayout,
+	"NAQP-SSB":    naqpLayout,
+	"NAQP-RTTY":   naqpLayout,
+}
+
+func cabrilloLayoutFor(contestID string) cabrilloLayout {
+	if layout, ok := cabrilloLayouts[strings.ToUpper(contestID)]; ok {
+		return layout
+	}
+	return serialLayout
+}
+
+// writeCabrillo writes a complete Cabrillo log of the QSOs, in the order given.
+func writeCabrillo(w io.Writer, header CabrilloHeader, sent sentExchange, qsos []*adifpb.Qso) error {
+	var b strings.Builder
+	writeTag := func(tag string, value string) {
+		if value != "" {
+			b.WriteString(tag + ": " + value + "\n")
+		}
+	}
+	writeTag("START-OF-LOG", cabrilloVersion)
+	writeTag("CREATED-BY", programID+" "+programVersion)
+	writeTag("CONTEST", header.Contest)
+	writeTag("CALLSIGN", header.Callsign)
+	writeTag("LOCATION", header.Location)
+	writeTag("CATEGORY-OPERATOR", header.CategoryOperator)
+	writeTag("CATEGORY-ASSISTED", header.CategoryAssisted)
+	writeTag("CATEGORY-BAND", header.CategoryBand)
+	writeTag("CATEGORY-MODE", header.CategoryMode)
+	writeTag("CATEGORY-POWER", header.CategoryPower)
+	writeTag("CATEGORY-STATION", header.CategoryStation)
+	writeTag("CATEGORY-TRANSMITTER", header.CategoryTransmitter)
+	writeTag("CLAIMED-SCORE", header.ClaimedScore)
+	writeTag("CLUB", header.Club)
+	writeTag("OPERATORS", strings.Join(header.Operators, " "))
+	writeTag("NAME", header.Name)
+	for _, line := range header.Address {
+		writeTag("ADDRESS", line)
+	}
+	writeTag("ADDRESS-CITY", header.AddressCity)
+	writeTag("ADDRESS-STATE-PROVINCE", header.AddressStateProv)
+	writeTag("ADDRESS-POSTALCODE", header.AddressPostalcode)
+	writeTag("ADDRESS-COUNTRY", header.AddressCountry)
+	writeTag("GRID-LOCATOR", header.GridLocator)
+	writeTag("EMAIL", header.Email)
+	for _, line := range header.Soapbox {
+		writeTag("SOAPBOX", line)
+	}
+	layout := cabrilloLayoutFor(header.Contest)
+	for _, qso := range qsos {
+		writeTag("QSO", cabrilloQso(qso, header.Callsign, layout, sent))
+	}
+	b.WriteString("END-OF-LOG:\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// cabrilloQso formats the part of a QSO: line after the tag, like
+// "14025 CW 2020-11-28 0001 K0SWE         599 04  N6DN          599 03".
+func cabrilloQso(qso *adifpb.Qso, myCall string, layout cabrilloLayout, sent sentExchange) string {
+	timeOn := qso.TimeOn.AsTime().UTC()
+	sentCall := qso.LoggingStation.GetStationCall()
+	if sentCall == "" {
+		sentCall = myCall
+	}
+	fields := []string{
+		fmt.Sprintf("%5s", cabrilloFreq(qso)),
+		cabrilloMode(qso),
+		timeOn.Format("2006-01-02"),
+		timeOn.Format("1504"),
+		fmt.Sprintf("%-13s", strings.ToUpper(sentCall)),
+	}
+	fields = append(fields, padExchange(layout.sent(qso, sent), layout.widths)...)
+	fields = append(fields, fmt.Sprintf("%-13s", strings.ToUpper(qso.ContactedStation.GetStationCall())))
+	fields = append(fields, padExchange(layout.received(qso), layout.widths)...)
+	return strings.TrimRight(strings.Join(fields, " "), " ")
+}
+
+func padExchange(values []string, widths []int) []string {
+	padded := make([]string, len(values))
+	for i, value := range values {
+		value = strings.ToUpper(strings.ReplaceAll(value, " ", ""))
+		if value == "" {
+			// Cabrillo fields are separated by spaces, so an empty one would shift the rest
+			value = "-"
+		}
+		padded[i] = fmt.Sprintf("%-*s", widths[i], value)
+	}
+	return padded
+}
+
+// cabrilloBandEdges are the lowest frequencies of the HF bands, in kHz, for QSOs which only have a
+// band.
+var cabrilloBandEdges = map[string]int{
+	"160m": 1800, "80m": 3500, "60m": 5330, "40m": 7000, "30m": 10100, "20m": 14000,
+	"17m": 18068, "15m": 21000, "12m": 24890, "10m": 28000,
+}
+
+// cabrilloBands are the band designators which Cabrillo uses instead of frequencies from 6m up.
+var cabrilloBands = map[string]string{
+	"6m": "50", "4m": "70", "2m": "144", "1.25m": "222", "70cm": "432", "33cm": "902",
+	"23cm": "1.2G", "13cm": "2.3G", "9cm": "3.4G", "6cm": "5.7G", "3cm": "10G", "1.25cm": "24G",
+	"6mm": "47G", "4mm": "75G", "2.5mm": "119G", "2mm": "142G", "1mm": "241G", "submm": "LIGHT",
+}
+
+// cabrilloFreq is the QSO's frequency in kHz below 30 MHz, and its band designator above. If the
+// QSO has no band, it's worked out from the frequency.
+func cabrilloFreq(qso *adifpb.Qso) string {
+	band := strings.ToLower(qso.Band)
+	if band == "" {
+		band = bandForFreq(qso.Freq)
+	}
+	if designator, ok := cabrilloBands[band]; ok {
+		return designator
+	}
+	if qso.Freq > 0 && qso.Freq < 30 {
+		return strconv.Itoa(int(qso.Freq*1000 + 0.5))
+	}
+	if edge, ok := cabrilloBandEdges[band]; ok {
+		return strconv.Itoa(edge)
+	}
+	return "-"
+}
+
+// cabrilloMode is one of Cabrillo's modes: CW, PH (phone), FM, RY (RTTY) or DG (other digital).
+func cabrilloMode(qso *adifpb.Qso) string {
+	switch strings.ToUpper(qso.Mode) {
+	case "CW":
+		return "CW"
+	case "SSB", "AM", "DIGITALVOICE":
+		return "PH"
+	case "FM":
+		return "FM"
+	case "RTTY":
+		return "RY"
+	default:
+		return "DG"
+	}
+}
+
+// cabrilloRst is the signal report, or the usual one for the mode if it wasn't logged.
+func cabrilloRst(rst string, qso *adifpb.Qso) string {
+	if rst != "" {
+		return rst
+	}
+	switch cabrilloMode(qso) {
+	case "PH", "FM":
+		return "59"
+	default:
+		return "599"
+	}
+}
+
+func firstName(name string) string {
+	first, _, _ := strings.Cut(strings.TrimSpace(name), " ")
+	return first
+}
+
+// cabrilloOperators lists the distinct operators of the QSOs, in the order they first appear.
+func cabrilloOperators(qsos []*adifpb.Qso) []string {
+	seen := map[string]bool{}
+	var operators []string
+	for _, qso := range qsos {
+		op := strings.ToUpper(qso.LoggingStation.GetOpCall())
+		if op != "" && !seen[op] {
+			seen[op] = true
+			operators = append(operators, op)
+		}
+	}
+	return operators
+}
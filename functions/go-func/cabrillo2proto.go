@@ -0,0 +1,223 @@
+package forester
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// isCabrillo says whether the log is Cabrillo, which starts with a START-OF-LOG tag.
+func isCabrillo(r *bufio.Reader) bool {
+	start, _ := r.Peek(512)
+	start = bytes.TrimPrefix(start, []byte("\xef\xbb\xbf"))
+	return bytes.HasPrefix(bytes.ToUpper(bytes.TrimSpace(start)), []byte("START-OF-LOG:"))
+}
+
+// cabrilloDecoder reads QSOs from a Cabrillo log one at a time, like adifDecoder does for ADIF.
+// The header tags before the QSO: lines describe the logging station of each QSO. Lines which
+// can't be parsed are skipped and kept track of, as records numbered by QSO: line.
+type cabrilloDecoder struct {
+	r       *bufio.Reader
+	line    int
+	header  CabrilloHeader
+	records int
+	errs    []RecordError
+}
+
+func newCabrilloDecoder(r io.Reader) *cabrilloDecoder {
+	return &cabrilloDecoder{r: bufio.NewReader(r)}
+}
+
+// Next reads the next QSO, or returns io.EOF after the last one. Other errors are from the reader.
+// X-QSO: lines, which the contest sponsor is asked to ignore, are still contacts, so they're read
+// too.
+func (d *cabrilloDecoder) Next() (*adifpb.Qso, error) {
+	for {
+		line, err := d.r.ReadString('\n')
+		if line == "" && err != nil {
+			return nil, err
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		d.line++
+		tag, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		tag = strings.ToUpper(strings.TrimSpace(tag))
+		value = strings.TrimSpace(value)
+		switch tag {
+		case "END-OF-LOG":
+			return nil, io.EOF
+		case "QSO", "X-QSO":
+			d.records++
+			qso, err := d.parseQso(value)
+			if err != nil {
+				d.errs = append(d.errs, RecordError{Record: d.records, Line: d.line, Skipped: true, Error: err.Error()})
+				continue
+			}
+			return qso, nil
+		default:
+			d.header.set(tag, value)
+		}
+	}
+}
+
+// Header returns the header tags read so far.
+func (d *cabrilloDecoder) Header() CabrilloHeader {
+	return d.header
+}
+
+// Errors returns the problems with the QSO: lines read so far.
+func (d *cabrilloDecoder) Errors() []RecordError {
+	return d.errs
+}
+
+// set reads a header tag. Tags which aren't in CabrilloHeader are ignored.
+func (h *CabrilloHeader) set(tag string, value string) {
+	fields := map[string]*string{
+		"CONTEST":                &h.Contest,
+		"CALLSIGN":               &h.Callsign,
+		"LOCATION":               &h.Location,
+		"CATEGORY-OPERATOR":      &h.CategoryOperator,
+		"CATEGORY-ASSISTED":      &h.CategoryAssisted,
+		"CATEGORY-BAND":          &h.CategoryBand,
+		"CATEGORY-MODE":          &h.CategoryMode,
+		"CATEGORY-POWER":         &h.CategoryPower,
+		"CATEGORY-STATION":       &h.CategoryStation,
+		"CATEGORY-TRANSMITTER":   &h.CategoryTransmitter,
+		"CLAIMED-SCORE":          &h.ClaimedScore,
+		"CLUB":                   &h.Club,
+		"NAME":                   &h.Name,
+		"ADDRESS-CITY":           &h.AddressCity,
+		"ADDRESS-STATE-PROVINCE": &h.AddressStateProv,
+		"ADDRESS-POSTALCODE":     &h.AddressPostalcode,
+		"ADDRESS-COUNTRY":        &h.AddressCountry,
+		"GRID-LOCATOR":           &h.GridLocator,
+		"EMAIL":                  &h.Email,
+	}
+	switch tag {
+	case "OPERATORS":
+		for _, op := range strings.Fields(strings.ReplaceAll(value, ",", " ")) {
+			// A host station is written like @K0SWE; it's the callsign, not an operator
+			if !strings.HasPrefix(op, "@") {
+				h.Operators = append(h.Operators, strings.ToUpper(op))
+			}
+		}
+	case "ADDRESS":
+		h.Address = append(h.Address, value)
+	case "SOAPBOX":
+		h.Soapbox = append(h.Soapbox, value)
+	default:
+		if field, ok := fields[tag]; ok {
+			*field = value
+		}
+	}
+}
+
+// loggingStation is the station described by the header, which worked the QSO as call.
+func (h CabrilloHeader) loggingStation(call string) *adifpb.Station {
+	station := &adifpb.Station{
+		StationCall: strings.ToUpper(call),
+		OpName:      h.Name,
+		GridSquare:  h.GridLocator,
+		Street:      strings.Join(h.Address, ", "),
+		City:        h.AddressCity,
+		State:       h.AddressStateProv,
+		PostalCode:  h.AddressPostalcode,
+		Country:     h.AddressCountry,
+		Email:       h.Email,
+	}
+	if station.StationCall == "" {
+		station.StationCall = strings.ToUpper(h.Callsign)
+	}
+	if len(h.Operators) == 1 {
+		station.OpCall = h.Operators[0]
+	}
+	return station
+}
+
+// parseQso reads the value of a QSO: line, like
+// "14025 CW 2020-11-28 0001 K0SWE 599 04 N6DN 599 03". The exchange is read with the contest's
+// layout if it has the expected number of fields; otherwise each side is kept whole as the serial
+// number, the way ADIF's STX_STRING and SRX_STRING would be.
+func (d *cabrilloDecoder) parseQso(value string) (*adifpb.Qso, error) {
+	fields := strings.Fields(value)
+	if len(fields) < 6 {
+		return nil, errors.New("too few fields; want at least frequency, mode, date, time and two calls")
+	}
+	rest := fields[4:]
+	if len(rest)%2 == 1 {
+		// The last field is the transmitter ID, for multi-transmitter entries
+		rest = rest[:len(rest)-1]
+	}
+	exchangeLen := (len(rest) - 2) / 2
+	for i, f := range rest {
+		if f == "-" {
+			rest[i] = ""
+		}
+	}
+	sent, received := rest[1:1+exchangeLen], rest[2+exchangeLen:]
+
+	timeOn, err := time.Parse("2006-01-02 1504", fields[2]+" "+fields[3])
+	if err != nil {
+		return nil, fmt.Errorf("bad date and time %v %v; want YYYY-MM-DD HHMM", fields[2], fields[3])
+	}
+	qso := &adifpb.Qso{
+		TimeOn:           timestamppb.New(timeOn),
+		Mode:             cabrilloModes[strings.ToUpper(fields[1])],
+		LoggingStation:   d.header.loggingStation(rest[0]),
+		ContactedStation: &adifpb.Station{StationCall: strings.ToUpper(rest[1+exchangeLen])},
+		Contest:          &adifpb.ContestData{ContestId: strings.ToUpper(d.header.Contest)},
+	}
+	qso.Freq, qso.Band, err = parseCabrilloFreq(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	if layout := cabrilloLayoutFor(d.header.Contest); len(layout.widths) == exchangeLen {
+		layout.read(qso, sent, received)
+	} else {
+		qso.Contest.SerialSent = strings.Join(sent, " ")
+		qso.Contest.SerialReceived = strings.Join(received, " ")
+	}
+	return qso, nil
+}
+
+// cabrilloModes are the ADIF modes of Cabrillo's modes. DG could be any digital mode, so it's left
+// blank.
+var cabrilloModes = map[string]string{
+	"CW": "CW",
+	"PH": "SSB",
+	"FM": "FM",
+	"RY": "RTTY",
+	"DG": "",
+}
+
+// parseCabrilloFreq reads a frequency in kHz, returning it in MHz with its band, or a band
+// designator like 144 or 1.2G, returning the band alone.
+func parseCabrilloFreq(s string) (float64, string, error) {
+	for band, designator := range cabrilloBands {
+		if strings.EqualFold(s, designator) {
+			return 0, band, nil
+		}
+	}
+	khz, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("bad frequency %v; want kHz or a band like 144", s)
+	}
+	mhz := khz / 1000
+	band := bandForFreq(mhz)
+	if band == "" {
+		return 0, "", fmt.Errorf("frequency %v kHz isn't in an amateur band", s)
+	}
+	return mhz, band, nil
+}
@@ -0,0 +1,145 @@
+package forester
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func Test_cabrilloDecoder(t *testing.T) {
+	log := `START-OF-LOG: 3.0
+CONTEST: ARRL-SS-CW
+CALLSIGN: K0SWE
+LOCATION: CO
+OPERATORS: KE0RCW @K0SWE
+NAME: Chris Keller
+GRID-LOCATOR: DM79
+QSO:  7025 CW 2020-11-07 2101 K0SWE         1    A 99 CO  N6DN          15   U 72 SV
+QSO:  7030 CW 2020-11-07 25:00 K0SWE        2    A 99 CO  K9IJ          3    Q 88 IL
+X-QSO:  144 CW 2020-11-07 2110 K0SWE        3    A 99 CO  W1AW          4    M 36 CT  1
+END-OF-LOG:
+QSO: 14025 CW 2020-11-07 2200 K0SWE         4    A 99 CO  KE0RCW        5    A 10 CO
+`
+	decoder := newCabrilloDecoder(strings.NewReader(log))
+	var qsos []*adifpb.Qso
+	for {
+		qso, err := decoder.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		qsos = append(qsos, qso)
+	}
+	station := &adifpb.Station{StationCall: "K0SWE", OpCall: "KE0RCW", OpName: "Chris Keller", GridSquare: "DM79"}
+	want := []*adifpb.Qso{
+		{
+			TimeOn:           timestamppb.New(time.Date(2020, 11, 7, 21, 1, 0, 0, time.UTC)),
+			Freq:             7.025,
+			Band:             "40m",
+			Mode:             "CW",
+			LoggingStation:   station,
+			ContactedStation: &adifpb.Station{StationCall: "N6DN"},
+			Contest: &adifpb.ContestData{
+				ContestId: "ARRL-SS-CW", SerialSent: "1", SerialReceived: "15",
+				Precedence: "U", Check: "72", ArrlSection: "SV",
+			},
+		},
+		{
+			TimeOn:           timestamppb.New(time.Date(2020, 11, 7, 21, 10, 0, 0, time.UTC)),
+			Band:             "2m",
+			Mode:             "CW",
+			LoggingStation:   station,
+			ContactedStation: &adifpb.Station{StationCall: "W1AW"},
+			Contest: &adifpb.ContestData{
+				ContestId: "ARRL-SS-CW", SerialSent: "3", SerialReceived: "4",
+				Precedence: "M", Check: "36", ArrlSection: "CT",
+			},
+		},
+	}
+	if len(qsos) != len(want) {
+		t.Fatalf("Next() got %d QSOs, want %d", len(qsos), len(want))
+	}
+	for i := range want {
+		if !proto.Equal(qsos[i], want[i]) {
+			t.Errorf("Next() got = %v, want %v", qsos[i], want[i])
+		}
+	}
+	errs := decoder.Errors()
+	if len(errs) != 1 || errs[0].Record != 2 || errs[0].Line != 9 || !errs[0].Skipped {
+		t.Errorf("Errors() got = %+v, want record 2 on line 9 skipped", errs)
+	}
+}
+
+func Test_cabrilloRoundTrip(t *testing.T) {
+	qsos := []*adifpb.Qso{
+		{
+			TimeOn:           timestamppb.New(time.Date(2020, 11, 28, 0, 1, 0, 0, time.UTC)),
+			Freq:             14.025,
+			Band:             "20m",
+			Mode:             "CW",
+			RstSent:          "599",
+			RstReceived:      "579",
+			LoggingStation:   &adifpb.Station{StationCall: "K0SWE", CqZone: 4},
+			ContactedStation: &adifpb.Station{StationCall: "DL1ABC", CqZone: 14},
+			Contest:          &adifpb.ContestData{ContestId: "CQ-WW-CW"},
+		},
+		{
+			TimeOn:           timestamppb.New(time.Date(2020, 11, 28, 0, 2, 0, 0, time.UTC)),
+			Freq:             21.0305,
+			Band:             "15m",
+			Mode:             "CW",
+			RstSent:          "599",
+			RstReceived:      "599",
+			LoggingStation:   &adifpb.Station{StationCall: "K0SWE", CqZone: 4},
+			ContactedStation: &adifpb.Station{StationCall: "JA1XYZ", CqZone: 25},
+			Contest:          &adifpb.ContestData{ContestId: "CQ-WW-CW"},
+		},
+	}
+	var b strings.Builder
+	err := writeCabrillo(&b, CabrilloHeader{Contest: "CQ-WW-CW", Callsign: "K0SWE"}, sentExchange{}, qsos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder := newCabrilloDecoder(strings.NewReader(b.String()))
+	for _, want := range qsos {
+		got, err := decoder.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Cabrillo frequencies are whole kHz
+		want.Freq = float64(int(want.Freq*1000+0.5)) / 1000
+		if !proto.Equal(got, want) {
+			t.Errorf("Next() got = %v, want %v", got, want)
+		}
+	}
+	if _, err := decoder.Next(); err != io.EOF {
+		t.Errorf("Next() got = %v, want EOF", err)
+	}
+}
+
+func Test_isCabrillo(t *testing.T) {
+	tests := []struct {
+		start string
+		want  bool
+	}{
+		{start: "START-OF-LOG: 3.0\nCONTEST: ARRL-FD", want: true},
+		{start: "\xef\xbb\xbf  start-of-log: 2.0", want: true},
+		{start: "Exported from N1MM<EOH>", want: false},
+		{start: "<?xml version=\"1.0\"?><ADX>", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.start, func(t *testing.T) {
+			if got := isCabrillo(bufio.NewReader(strings.NewReader(tt.start))); got != tt.want {
+				t.Errorf("isCabrillo() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
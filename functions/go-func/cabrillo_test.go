@@ -0,0 +1,135 @@
+package forester
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func Test_cabrilloQso(t *testing.T) {
+	timeOn := timestamppb.New(time.Date(2020, 6, 27, 18, 5, 0, 0, time.UTC))
+	tests := []struct {
+		name    string
+		contest string
+		qso     *adifpb.Qso
+		sent    sentExchange
+		want    string
+	}{
+		{
+			name:    "Field Day",
+			contest: "ARRL-FD",
+			qso: &adifpb.Qso{
+				TimeOn: timeOn, Band: "20m", Freq: 14.025, Mode: "CW",
+				ContactedStation: &adifpb.Station{StationCall: "n6dn"},
+				Contest:          &adifpb.ContestData{StationClass: "1D", ArrlSection: "SV"},
+			},
+			sent: sentExchange{class: "2A", location: "CO"},
+			want: "14025 CW 2020-06-27 1805 K0SWE         2A  CO  N6DN          1D  SV",
+		},
+		{
+			name:    "Sweepstakes",
+			contest: "ARRL-SS-SSB",
+			qso: &adifpb.Qso{
+				TimeOn: timeOn, Band: "40m", Mode: "SSB", Submode: "LSB",
+				ContactedStation: &adifpb.Station{StationCall: "K9IJ"},
+				Contest: &adifpb.ContestData{
+					SerialSent: "12", SerialReceived: "345", Precedence: "U", Check: "72", ArrlSection: "IL",
+				},
+			},
+			sent: sentExchange{precedence: "A", check: "99", location: "CO"},
+			want: " 7000 PH 2020-06-27 1805 K0SWE         12   A 99 CO  K9IJ          345  U 72 IL",
+		},
+		{
+			name:    "CQ WW",
+			contest: "CQ-WW-CW",
+			qso: &adifpb.Qso{
+				TimeOn: timeOn, Band: "15m", Freq: 21.0305, Mode: "CW", RstReceived: "579",
+				LoggingStation:   &adifpb.Station{StationCall: "K0SWE", CqZone: 4},
+				ContactedStation: &adifpb.Station{StationCall: "DL1ABC", CqZone: 14},
+			},
+			want: "21031 CW 2020-06-27 1805 K0SWE         599 4  DL1ABC        579 14",
+		},
+		{
+			name:    "NAQP",
+			contest: "NAQP-SSB",
+			qso: &adifpb.Qso{
+				TimeOn: timeOn, Band: "2m", Mode: "FM",
+				ContactedStation: &adifpb.Station{StationCall: "KE0RCW", OpName: "Paul M St John", State: "CO"},
+			},
+			sent: sentExchange{name: "Chris", location: "CO"},
+			want: "  144 FM 2020-06-27 1805 K0SWE         CHRIS      CO  KE0RCW        PAUL       CO",
+		},
+		{
+			name:    "frequency without a band",
+			contest: "CQ-WPX-SSB",
+			qso: &adifpb.Qso{
+				TimeOn: timeOn, Freq: 432.1, Mode: "SSB",
+				ContactedStation: &adifpb.Station{StationCall: "W0ABC"},
+				Contest:          &adifpb.ContestData{SerialSent: "2", SerialReceived: "17"},
+			},
+			want: "  432 PH 2020-06-27 1805 K0SWE         59  2    W0ABC         59  17",
+		},
+		{
+			name:    "other contest",
+			contest: "CQ-WPX-RTTY",
+			qso: &adifpb.Qso{
+				TimeOn: timeOn, Band: "80m", Freq: 3.58, Mode: "RTTY",
+				ContactedStation: &adifpb.Station{StationCall: "W1AW"},
+				Contest:          &adifpb.ContestData{SerialSent: "1"},
+			},
+			want: " 3580 RY 2020-06-27 1805 K0SWE         599 1    W1AW          599 -",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := cabrilloQso(tt.qso, "K0SWE", cabrilloLayoutFor(tt.contest), tt.sent)
+			if got != tt.want {
+				t.Errorf("cabrilloQso() got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_writeCabrillo(t *testing.T) {
+	header := CabrilloHeader{
+		Contest:          "ARRL-FD",
+		Callsign:         "K0SWE",
+		Location:         "CO",
+		CategoryOperator: "SINGLE-OP",
+		ClaimedScore:     "1234",
+		Operators:        []string{"K0SWE", "KE0RCW"},
+		Address:          []string{"123 Main St"},
+	}
+	qso := &adifpb.Qso{
+		TimeOn:           timestamppb.New(time.Date(2020, 6, 27, 18, 5, 0, 0, time.UTC)),
+		Band:             "20m",
+		Mode:             "FT8",
+		ContactedStation: &adifpb.Station{StationCall: "N6DN"},
+		Contest:          &adifpb.ContestData{StationClass: "1D", ArrlSection: "SV"},
+	}
+	var b strings.Builder
+	err := writeCabrillo(&b, header, sentExchange{class: "2A", location: "CO"}, []*adifpb.Qso{qso})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	want := []string{
+		"START-OF-LOG: 3.0",
+		"CREATED-BY: forester-func " + programVersion,
+		"CONTEST: ARRL-FD",
+		"CALLSIGN: K0SWE",
+		"LOCATION: CO",
+		"CATEGORY-OPERATOR: SINGLE-OP",
+		"CLAIMED-SCORE: 1234",
+		"OPERATORS: K0SWE KE0RCW",
+		"ADDRESS: 123 Main St",
+		"QSO: 14000 DG 2020-06-27 1805 K0SWE         2A  CO  N6DN          1D  SV",
+		"END-OF-LOG:",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("writeCabrillo() got = %v, want %v", lines, want)
+	}
+}
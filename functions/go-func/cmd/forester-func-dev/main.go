@@ -17,6 +17,11 @@ func main() {
 	const addr = "localhost:8080"
 	http.HandleFunc("/ImportQrz", forester.ImportQrz)
 	http.HandleFunc("/ImportLotw", forester.ImportLotw)
+	http.HandleFunc("/ImportEqsl", forester.ImportEqsl)
+	http.HandleFunc("/ImportAdif", forester.ImportAdif)
+	http.HandleFunc("/ImportCsv", forester.ImportCsv)
+	http.HandleFunc("/ExportAdif", forester.ExportAdif)
+	http.HandleFunc("/ExportCabrillo", forester.ExportCabrillo)
 	http.HandleFunc("/UpdateSecret", forester.UpdateSecret)
 	log.Printf("Ready to serve on http://%s", addr)
 	log.Fatal(http.ListenAndServe(addr, nil))
@@ -46,5 +46,5 @@ func ParseFirestoreQso(qsoDoc *firestore.DocumentSnapshot) (FirestoreQso, error)
 	marshal, _ := json.Marshal(buf)
 	var qso adifpb.Qso
 	err := protojson.Unmarshal(marshal, &qso)
-	return FirestoreQso{&qso, qsoDoc.Ref}, err
+	return FirestoreQso{&qso, qsoDoc.Ref, qsoDoc.Ref.ID}, err
 }
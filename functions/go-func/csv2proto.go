@@ -0,0 +1,247 @@
+package forester
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+)
+
+// CsvProfile says how to read a CSV log: which column holds which ADIF field, and how its dates,
+// times and frequencies are written. The first row of the CSV names the columns.
+type CsvProfile struct {
+	// Columns maps column names to ADIF field names, like {"Callsign": "CALL", "Date": "QSO_DATE"}.
+	// Columns which aren't listed are ignored.
+	Columns map[string]string `json:"columns"`
+	// DateFormat is how QSO_DATE and QSO_DATE_OFF are written, like MM/DD/YYYY; see csvLayout. It
+	// can include the time, if there's no TIME_ON column. The default is YYYY-MM-DD.
+	DateFormat string `json:"dateFormat,omitempty"`
+	// TimeFormat is how TIME_ON and TIME_OFF are written, like HH:mm. The default is HH:mm.
+	TimeFormat string `json:"timeFormat,omitempty"`
+	// Timezone is the IANA time zone of the dates and times, like America/Denver. The default is
+	// UTC.
+	Timezone string `json:"timezone,omitempty"`
+	// FreqUnit is the unit of FREQ and FREQ_RX: Hz, kHz, MHz or GHz. The default is MHz.
+	FreqUnit string `json:"freqUnit,omitempty"`
+	// Delimiter separates the columns. The default is a comma.
+	Delimiter string `json:"delimiter,omitempty"`
+}
+
+// csvFreqUnits are how many of each unit there are in a MHz.
+var csvFreqUnits = map[string]float64{
+	"hz":  1e6,
+	"khz": 1e3,
+	"mhz": 1,
+	"ghz": 1e-3,
+}
+
+// csvLayout converts a date or time format like MM/DD/YYYY HH:mm to a Go time layout. M, D and H
+// match one or two digits.
+var csvLayout = strings.NewReplacer(
+	"YYYY", "2006", "YY", "06",
+	"MM", "01", "M", "1",
+	"DD", "02", "D", "2",
+	"HH", "15", "H", "15",
+	"mm", "04", "ss", "05",
+).Replace
+
+// validate checks the profile and fills in its defaults.
+func (p *CsvProfile) validate() error {
+	fields := map[string]bool{}
+	for column, field := range p.Columns {
+		field = strings.ToUpper(strings.TrimSpace(field))
+		if field == "" || strings.ContainsAny(field, " ,:<>{}") {
+			return fmt.Errorf("column %q has a bad ADIF field name %q", column, field)
+		}
+		if fields[field] {
+			return fmt.Errorf("more than one column is %v", field)
+		}
+		fields[field] = true
+		p.Columns[column] = field
+	}
+	if !fields["CALL"] || !fields["QSO_DATE"] {
+		return errors.New("the CALL and QSO_DATE fields need columns")
+	}
+	if p.DateFormat == "" {
+		p.DateFormat = "YYYY-MM-DD"
+	}
+	if p.TimeFormat == "" {
+		p.TimeFormat = "HH:mm"
+	}
+	if p.Timezone == "" {
+		p.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(p.Timezone); err != nil {
+		return fmt.Errorf("unknown timezone %q", p.Timezone)
+	}
+	if p.FreqUnit == "" {
+		p.FreqUnit = "MHz"
+	}
+	if _, ok := csvFreqUnits[strings.ToLower(p.FreqUnit)]; !ok {
+		return fmt.Errorf("unknown frequency unit %q, want Hz, kHz, MHz or GHz", p.FreqUnit)
+	}
+	if p.Delimiter == "" {
+		p.Delimiter = ","
+	}
+	if len([]rune(p.Delimiter)) != 1 {
+		return fmt.Errorf("the delimiter %q isn't one character", p.Delimiter)
+	}
+	return nil
+}
+
+// csvDecoder reads QSOs from a CSV log one at a time, like adifDecoder does for ADIF. Each row is
+// converted to an ADIF record, so it's checked the same way; rows which can't be parsed are skipped
+// and kept track of, as records numbered by row.
+type csvDecoder struct {
+	r        *csv.Reader
+	profile  CsvProfile
+	location *time.Location
+	// fields are the ADIF field names of the columns, or "" for ignored ones.
+	fields  []string
+	records int
+	errs    []RecordError
+}
+
+// newCsvDecoder reads the header row, and checks that the profile's columns are all in it.
+func newCsvDecoder(r io.Reader, profile CsvProfile) (*csvDecoder, error) {
+	err := profile.validate()
+	if err != nil {
+		return nil, err
+	}
+	location, _ := time.LoadLocation(profile.Timezone)
+	reader := csv.NewReader(r)
+	reader.Comma = []rune(profile.Delimiter)[0]
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("the CSV is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	fields := make([]string, len(header))
+	found := map[string]bool{}
+	for i, column := range header {
+		column = strings.TrimSpace(strings.TrimPrefix(column, "\ufeff"))
+		fields[i] = profile.Columns[column]
+		found[column] = true
+	}
+	for column := range profile.Columns {
+		if !found[column] {
+			return nil, fmt.Errorf("the CSV has no %q column", column)
+		}
+	}
+	return &csvDecoder{r: reader, profile: profile, location: location, fields: fields}, nil
+}
+
+// Next reads the next QSO, or returns io.EOF after the last one. Other errors are from the reader.
+func (d *csvDecoder) Next() (*adifpb.Qso, error) {
+	for {
+		row, err := d.r.Read()
+		if err == io.EOF {
+			return nil, err
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			d.records++
+			d.errs = append(d.errs, RecordError{Record: d.records, Line: parseErr.StartLine, Skipped: true, Error: parseErr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		d.records++
+		line, _ := d.r.FieldPos(0)
+		qso, recordErr := parseRawRecord(d.rowRecord(row, line), nil)
+		if recordErr != nil {
+			recordErr.Record = d.records
+			d.errs = append(d.errs, *recordErr)
+		}
+		if qso != nil {
+			return qso, nil
+		}
+	}
+}
+
+// Errors returns the problems with the rows read so far.
+func (d *csvDecoder) Errors() []RecordError {
+	return d.errs
+}
+
+// rowRecord converts the row to an ADIF record. Dates, times and frequencies are converted to
+// ADIF's formats and units; a value which can't be converted is left as it is, so it's reported
+// when the record is parsed. BAND is filled in from FREQ if it doesn't have a column.
+func (d *csvDecoder) rowRecord(row []string, line int) rawRecord {
+	values := map[string]string{}
+	var order []string
+	for i, value := range row {
+		value = strings.TrimSpace(value)
+		if i < len(d.fields) && d.fields[i] != "" && value != "" {
+			values[d.fields[i]] = value
+			order = append(order, d.fields[i])
+		}
+	}
+	d.convertTime(values, "QSO_DATE", "TIME_ON", &order)
+	d.convertTime(values, "QSO_DATE_OFF", "TIME_OFF", &order)
+	for _, field := range []string{"FREQ", "FREQ_RX"} {
+		if value, ok := values[field]; ok {
+			n, err := strconv.ParseFloat(value, 64)
+			if err == nil {
+				mhz := n / csvFreqUnits[strings.ToLower(d.profile.FreqUnit)]
+				values[field] = strconv.FormatFloat(mhz, 'f', -1, 64)
+			}
+		}
+	}
+	if _, ok := values["BAND"]; !ok {
+		mhz, err := strconv.ParseFloat(values["FREQ"], 64)
+		if band := bandForFreq(mhz); err == nil && band != "" {
+			values["BAND"] = band
+			order = append(order, "BAND")
+		}
+	}
+	raw := rawRecord{line: line}
+	for _, field := range order {
+		raw.fields = append(raw.fields, rawField{name: field, value: values[field], line: line})
+	}
+	raw.text = adiText(raw.fields, false)
+	return raw
+}
+
+// convertTime converts the date field, and the time field if there is one, to UTC in ADIF's
+// formats. If the date format includes the time, the time field is filled in from the date.
+func (d *csvDecoder) convertTime(values map[string]string, dateField string, timeField string, order *[]string) {
+	date, ok := values[dateField]
+	if !ok {
+		return
+	}
+	layout := csvLayout(d.profile.DateFormat)
+	timeValue, hasTime := values[timeField]
+	if hasTime {
+		layout += " " + csvLayout(d.profile.TimeFormat)
+		date += " " + timeValue
+	}
+	dateHasTime := strings.Contains(layout, "15")
+	location := d.location
+	if !dateHasTime {
+		// A date alone can't be moved to another timezone
+		location = time.UTC
+	}
+	t, err := time.ParseInLocation(layout, date, location)
+	if err != nil {
+		return
+	}
+	t = t.UTC()
+	values[dateField] = t.Format("20060102")
+	if dateHasTime {
+		if !hasTime {
+			*order = append(*order, timeField)
+		}
+		values[timeField] = t.Format("150405")
+	}
+}
@@ -0,0 +1,147 @@
+package forester
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func Test_csvDecoder(t *testing.T) {
+	profile := CsvProfile{
+		Columns: map[string]string{
+			"Call": "call", "Date": "QSO_DATE", "Time": "TIME_ON", "kHz": "FREQ", "Mode": "MODE",
+			"Sent": "RST_SENT", "Notes": "APP_PAPER_NOTES",
+		},
+		DateFormat: "M/D/YYYY",
+		TimeFormat: "HHmm",
+		Timezone:   "America/Denver",
+		FreqUnit:   "kHz",
+	}
+	csv := "\ufeffCall,Date,Time,kHz,Mode,Sent,Ignored,Notes\n" +
+		"n6dn,6/27/2020,1805,14025,CW,599,x,\"rare, one\"\n" +
+		"K9IJ,27/6/2020,1810,7030,CW,579,x,\n" +
+		"\n" +
+		"KE0RCW,6/27/2020,2330,146520,FM,,x,short\n"
+	decoder, err := newCsvDecoder(strings.NewReader(csv), profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var qsos []*adifpb.Qso
+	for {
+		qso, err := decoder.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		qsos = append(qsos, qso)
+	}
+	want := []*adifpb.Qso{
+		{
+			TimeOn:           timestamppb.New(time.Date(2020, 6, 28, 0, 5, 0, 0, time.UTC)),
+			Freq:             14.025,
+			Band:             "20m",
+			Mode:             "CW",
+			RstSent:          "599",
+			LoggingStation:   &adifpb.Station{},
+			ContactedStation: &adifpb.Station{StationCall: "n6dn"},
+			Propagation:      &adifpb.Propagation{},
+			AppDefined:       map[string]string{"app_paper_notes": "rare, one"},
+		},
+		{
+			TimeOn:           timestamppb.New(time.Date(2020, 6, 28, 5, 30, 0, 0, time.UTC)),
+			Freq:             146.52,
+			Band:             "2m",
+			Mode:             "FM",
+			LoggingStation:   &adifpb.Station{},
+			ContactedStation: &adifpb.Station{StationCall: "KE0RCW"},
+			Propagation:      &adifpb.Propagation{},
+			AppDefined:       map[string]string{"app_paper_notes": "short"},
+		},
+	}
+	if len(qsos) != len(want) {
+		t.Fatalf("Next() got %d QSOs, want %d", len(qsos), len(want))
+	}
+	for i := range want {
+		if !proto.Equal(qsos[i], want[i]) {
+			t.Errorf("Next() got = %v, want %v", qsos[i], want[i])
+		}
+	}
+	errs := decoder.Errors()
+	if len(errs) != 1 || errs[0].Record != 2 || errs[0].Line != 3 || !errs[0].Skipped ||
+		len(errs[0].Fields) != 1 || errs[0].Fields[0].Field != "QSO_DATE" {
+		t.Errorf("Errors() got = %+v, want a bad QSO_DATE in record 2 on line 3", errs)
+	}
+}
+
+func Test_csvDecoder_dateWithTime(t *testing.T) {
+	profile := CsvProfile{
+		Columns:    map[string]string{"Call": "CALL", "When": "QSO_DATE"},
+		DateFormat: "YYYY-MM-DD HH:mm:ss",
+		Delimiter:  ";",
+	}
+	decoder, err := newCsvDecoder(strings.NewReader("Call;When\nN6DN;2020-10-25 20:15:10\n"), profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qso, err := decoder.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2020, 10, 25, 20, 15, 10, 0, time.UTC)
+	if !qso.TimeOn.AsTime().Equal(want) {
+		t.Errorf("Next() TimeOn got = %v, want %v", qso.TimeOn.AsTime(), want)
+	}
+}
+
+func Test_newCsvDecoder_errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile CsvProfile
+		csv     string
+	}{
+		{
+			name:    "no date column",
+			profile: CsvProfile{Columns: map[string]string{"Call": "CALL"}},
+			csv:     "Call\nN6DN\n",
+		},
+		{
+			name:    "two columns for one field",
+			profile: CsvProfile{Columns: map[string]string{"Call": "CALL", "Date": "QSO_DATE", "Other": "call"}},
+			csv:     "Call,Date,Other\n",
+		},
+		{
+			name:    "bad timezone",
+			profile: CsvProfile{Columns: map[string]string{"Call": "CALL", "Date": "QSO_DATE"}, Timezone: "Mars/Olympus"},
+			csv:     "Call,Date\n",
+		},
+		{
+			name:    "bad frequency unit",
+			profile: CsvProfile{Columns: map[string]string{"Call": "CALL", "Date": "QSO_DATE"}, FreqUnit: "furlongs"},
+			csv:     "Call,Date\n",
+		},
+		{
+			name:    "missing column",
+			profile: CsvProfile{Columns: map[string]string{"Call": "CALL", "Date": "QSO_DATE"}},
+			csv:     "Call,Time\n",
+		},
+		{
+			name:    "empty",
+			profile: CsvProfile{Columns: map[string]string{"Call": "CALL", "Date": "QSO_DATE"}},
+			csv:     "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newCsvDecoder(strings.NewReader(tt.csv), tt.profile); err == nil {
+				t.Errorf("newCsvDecoder() got nil error")
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package forester
+
+import (
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	"net/http"
+	"reflect"
+	"sort"
+)
+
+// QsoDiff describes a contact an import would create or modify.
+type QsoDiff struct {
+	ContactID string                 `json:"contactId,omitempty" firestore:"contactId,omitempty"`
+	Call      string                 `json:"call" firestore:"call"`
+	TimeOn    string                 `json:"timeOn" firestore:"timeOn"`
+	Qso       map[string]interface{} `json:"qso,omitempty" firestore:"qso,omitempty"`
+	Changes   []FieldChange          `json:"changes,omitempty" firestore:"changes,omitempty"`
+}
+
+// FieldChange is a single field of a contact which an import would change. Field is the dotted
+// path of the field in the contact's JSON, e.g. "contactedStation.opName".
+type FieldChange struct {
+	Field string      `json:"field" firestore:"field"`
+	Old   interface{} `json:"old" firestore:"old"`
+	New   interface{} `json:"new" firestore:"new"`
+}
+
+// dryRunStore is a QsoStore which records the writes it's asked to make instead of making them.
+// Reads are passed through to the real store.
+type dryRunStore struct {
+	QsoStore
+	originals   map[string]*adifpb.Qso
+	wouldCreate []QsoDiff
+	wouldModify []QsoDiff
+}
+
+// isDryRun checks for the dryRun=true param.
+func isDryRun(r *http.Request) bool {
+	return r.URL.Query().Get("dryRun") == "true"
+}
+
+// newDryRunStore wraps the store. The existing contacts are copied before they're merged into, so
+// their original values can be reported.
+func newDryRunStore(store QsoStore, existing []FirestoreQso) *dryRunStore {
+	originals := make(map[string]*adifpb.Qso, len(existing))
+	for _, fsQso := range existing {
+		originals[fsQso.id] = cloneQso(fsQso.qsopb)
+	}
+	return &dryRunStore{QsoStore: store, originals: originals}
+}
+
+func (s *dryRunStore) Create(qso *adifpb.Qso) error {
+	buf, err := qsoToJSON(qso)
+	if err != nil {
+		return err
+	}
+	diff := describeQso(qso)
+	diff.Qso = buf
+	s.wouldCreate = append(s.wouldCreate, diff)
+	return nil
+}
+
+func (s *dryRunStore) Update(qso FirestoreQso) error {
+	changes, err := diffQsos(s.originals[qso.id], qso.qsopb)
+	if err != nil {
+		return err
+	}
+	diff := describeQso(qso.qsopb)
+	diff.ContactID = qso.id
+	diff.Changes = changes
+	s.wouldModify = append(s.wouldModify, diff)
+	return nil
+}
+
+func (s *dryRunStore) WriteAll(creates []*adifpb.Qso, updates []FirestoreQso) []WriteFailure {
+	return writeEach(s, creates, updates)
+}
+
+func (s *dryRunStore) Delete(_ FirestoreQso) error {
+	return nil
+}
+
+func (s *dryRunStore) SetLogbookProperty(_ string, _ string) error {
+	return nil
+}
+
+// addToReport adds the would-be writes to an import report.
+func (s *dryRunStore) addToReport(report map[string]interface{}) {
+	report["dryRun"] = true
+	report["wouldCreate"] = s.wouldCreate
+	report["wouldModify"] = s.wouldModify
+}
+
+func describeQso(qso *adifpb.Qso) QsoDiff {
+	diff := QsoDiff{Call: qso.GetContactedStation().GetStationCall()}
+	if qso.TimeOn != nil {
+		diff.TimeOn = qso.TimeOn.AsTime().Format("2006-01-02T15:04:05Z")
+	}
+	return diff
+}
+
+// diffQsos lists the fields which differ between the two contacts, sorted by field.
+func diffQsos(before *adifpb.Qso, after *adifpb.Qso) ([]FieldChange, error) {
+	if before == nil {
+		before = &adifpb.Qso{}
+	}
+	beforeJSON, err := qsoToJSON(before)
+	if err != nil {
+		return nil, err
+	}
+	afterJSON, err := qsoToJSON(after)
+	if err != nil {
+		return nil, err
+	}
+	beforeFields := map[string]interface{}{}
+	flattenJSON("", beforeJSON, beforeFields)
+	afterFields := map[string]interface{}{}
+	flattenJSON("", afterJSON, afterFields)
+
+	var changes []FieldChange
+	for field, newValue := range afterFields {
+		if oldValue := beforeFields[field]; !reflect.DeepEqual(oldValue, newValue) {
+			changes = append(changes, FieldChange{field, oldValue, newValue})
+		}
+	}
+	for field, oldValue := range beforeFields {
+		if _, ok := afterFields[field]; !ok {
+			changes = append(changes, FieldChange{field, oldValue, nil})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes, nil
+}
+
+func flattenJSON(prefix string, value map[string]interface{}, out map[string]interface{}) {
+	for k, v := range value {
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+		if nested, ok := v.(map[string]interface{}); ok {
+			flattenJSON(path, nested, out)
+		} else {
+			out[path] = v
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package forester
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func Test_dryRunStore(t *testing.T) {
+	store := NewMemoryQsoStore()
+	_ = store.Create(&adifpb.Qso{
+		Band:             "20m",
+		TimeOn:           timestamppb.New(time.Date(2020, 10, 25, 20, 15, 0, 0, time.UTC)),
+		LoggingStation:   &adifpb.Station{StationCall: "K0SWE"},
+		ContactedStation: &adifpb.Station{StationCall: "N6DN"},
+	})
+	remote := &adifpb.Adif{Qsos: []*adifpb.Qso{
+		{
+			Mode:             "FT8",
+			TimeOn:           timestamppb.New(time.Date(2020, 10, 25, 20, 15, 0, 0, time.UTC)),
+			LoggingStation:   &adifpb.Station{StationCall: "K0SWE"},
+			ContactedStation: &adifpb.Station{StationCall: "N6DN", OpName: "Paul"},
+		},
+		{
+			TimeOn:           timestamppb.New(time.Date(2019, 5, 20, 23, 30, 0, 0, time.UTC)),
+			LoggingStation:   &adifpb.Station{StationCall: "K0SWE"},
+			ContactedStation: &adifpb.Station{StationCall: "KE0RCW"},
+		},
+	}}
+
+	existing, _ := store.GetContacts()
+	dryRun := newDryRunStore(store, existing)
+	result := MergeQsos(dryRun, SourceQrz, existing, remote)
+	if result.Created != 1 || result.Modified != 1 || result.NoDiff != 0 {
+		t.Errorf("MergeQsos() got = %d, %d, %d, want 1, 1, 0",
+			result.Created, result.Modified, result.NoDiff)
+	}
+	_ = storeLastFetched(dryRun, lotwLastFetchedDate)
+
+	after, _ := store.GetContacts()
+	if len(after) != 1 || after[0].qsopb.Mode != "" {
+		t.Errorf("dry run wrote to the store: %v", after)
+	}
+	if got, _ := store.GetLogbookProperty(lotwLastFetchedDate); got != "<nil>" {
+		t.Errorf("dry run stored %v = %v", lotwLastFetchedDate, got)
+	}
+
+	if len(dryRun.wouldCreate) != 1 || dryRun.wouldCreate[0].Call != "KE0RCW" ||
+		dryRun.wouldCreate[0].TimeOn != "2019-05-20T23:30:00Z" {
+		t.Errorf("wouldCreate got = %v", dryRun.wouldCreate)
+	}
+	wantChanges := []FieldChange{
+		{Field: "contactedStation.opName", Old: nil, New: "Paul"},
+		{Field: "mode", Old: nil, New: "FT8"},
+	}
+	if len(dryRun.wouldModify) != 1 || !reflect.DeepEqual(dryRun.wouldModify[0].Changes, wantChanges) {
+		t.Errorf("wouldModify got = %v, want changes %v", dryRun.wouldModify, wantChanges)
+	}
+}
@@ -0,0 +1,438 @@
+package forester
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+)
+
+// dxccPrefixFileEnv names the environment variable holding the path of the prefix file: a Club Log
+// cty.xml, or a cty.dat from country-files.com, which may be gzipped. cty.dat has no ADIF entity
+// codes or dates, so deploys use Club Log's. Without it, callsigns aren't resolved.
+const dxccPrefixFileEnv = "DXCC_PREFIX_FILE"
+
+// dxccEntity is what a callsign resolves to: a DXCC entity, with the zones and continent of the
+// part of it the call is in.
+type dxccEntity struct {
+	// dxcc is the ADIF entity code. cty.dat doesn't have them, so it's 0 from there.
+	dxcc      uint32
+	name      string
+	cqZone    uint32
+	ituZone   uint32
+	continent string
+}
+
+// dxccEntry is what a prefix or a whole callsign resolves to between start and end, which are zero
+// if it's not limited. An invalid entry is an operation which doesn't count for any entity.
+type dxccEntry struct {
+	entity  dxccEntity
+	start   time.Time
+	end     time.Time
+	invalid bool
+}
+
+func (e dxccEntry) dated() bool {
+	return !e.start.IsZero() || !e.end.IsZero()
+}
+
+func (e dxccEntry) covers(t time.Time) bool {
+	return (e.start.IsZero() || !t.Before(e.start)) && (e.end.IsZero() || !t.After(e.end))
+}
+
+// dxccResolver resolves callsigns to entities, from whole callsigns which are exceptions to their
+// prefix, or else from the longest prefix which matches.
+type dxccResolver struct {
+	calls    map[string][]dxccEntry
+	prefixes map[string][]dxccEntry
+	// zones are CQ zone exceptions for whole callsigns, which only set entity.cqZone.
+	zones   map[string][]dxccEntry
+	longest int
+}
+
+func newDxccResolver() *dxccResolver {
+	return &dxccResolver{
+		calls:    map[string][]dxccEntry{},
+		prefixes: map[string][]dxccEntry{},
+		zones:    map[string][]dxccEntry{},
+	}
+}
+
+func (r *dxccResolver) addPrefix(prefix string, entry dxccEntry) {
+	r.prefixes[prefix] = append(r.prefixes[prefix], entry)
+	r.longest = max(r.longest, len(prefix))
+}
+
+// pickDxccEntry finds the entry in effect at the time. Dated entries are exceptions to the undated
+// ones, so they win.
+func pickDxccEntry(entries []dxccEntry, at time.Time) (dxccEntry, bool) {
+	var found dxccEntry
+	ok := false
+	for _, e := range entries {
+		if !e.covers(at) {
+			continue
+		}
+		if e.dated() {
+			return e, true
+		}
+		if !ok {
+			found, ok = e, true
+		}
+	}
+	return found, ok
+}
+
+// resolve finds the entity the callsign was in at the time. Portable calls like KH6/K0SWE,
+// K0SWE/KH6 and W1AW/4 resolve by their prefix; maritime and aeronautical mobile calls aren't in
+// any entity.
+func (r *dxccResolver) resolve(call string, at time.Time) (dxccEntity, bool) {
+	call = strings.ToUpper(strings.TrimSpace(call))
+	if call == "" {
+		return dxccEntity{}, false
+	}
+	entity, ok := r.lookup(call, at)
+	if ok {
+		if zone, found := pickDxccEntry(r.zones[call], at); found {
+			entity.cqZone = zone.entity.cqZone
+		}
+	}
+	return entity, ok
+}
+
+func (r *dxccResolver) lookup(call string, at time.Time) (dxccEntity, bool) {
+	if e, ok := pickDxccEntry(r.calls[call], at); ok {
+		return e.entity, !e.invalid
+	}
+	prefix, ok := dxccPrefixCall(call)
+	if !ok {
+		return dxccEntity{}, false
+	}
+	if e, ok := pickDxccEntry(r.calls[prefix], at); ok {
+		return e.entity, !e.invalid
+	}
+	for n := min(len(prefix), r.longest); n > 0; n-- {
+		if e, ok := pickDxccEntry(r.prefixes[prefix[:n]], at); ok {
+			return e.entity, !e.invalid
+		}
+	}
+	return dxccEntity{}, false
+}
+
+// dxccSuffixes are the portable suffixes which don't change a call's entity.
+var dxccSuffixes = map[string]bool{"P": true, "M": true, "A": true, "B": true, "QRP": true, "QRPP": true, "LH": true}
+
+// dxccPrefixCall finds the part of a portable callsign which says where it is: the shorter of a
+// prefix and a home call, or the home call in a new call area for W1AW/4. It's false for maritime
+// and aeronautical mobile calls.
+func dxccPrefixCall(call string) (string, bool) {
+	var parts []string
+	for _, part := range strings.Split(call, "/") {
+		switch {
+		case part == "" || dxccSuffixes[part]:
+		case part == "MM" || part == "AM":
+			return "", false
+		default:
+			parts = append(parts, part)
+		}
+	}
+	switch len(parts) {
+	case 0:
+		return "", false
+	case 1:
+		return parts[0], true
+	}
+	home, other := parts[0], parts[len(parts)-1]
+	if len(other) == 1 && other[0] >= '0' && other[0] <= '9' {
+		i := strings.LastIndexAny(home, "0123456789")
+		if i < 0 {
+			return home + other, true
+		}
+		return home[:i] + other + home[i+1:], true
+	}
+	if len(other) < len(home) {
+		return other, true
+	}
+	return home, true
+}
+
+// ctyOverrideEnds are the closing characters of a cty.dat alias's overrides: (CQ zone), [ITU zone],
+// <lat/lon>, {continent} and ~UTC offset~.
+var ctyOverrideEnds = map[byte]byte{'(': ')', '[': ']', '<': '>', '{': '}', '~': '~'}
+
+// parseCtyDat reads a cty.dat file. Each entity is a line of colon-separated fields (name, CQ zone,
+// ITU zone, continent, latitude, longitude, UTC offset and primary prefix), then a comma-separated
+// list of its prefixes ending in a semicolon. Aliases starting with = are whole callsigns, and any
+// alias can override the entity's zones and continent. Entities whose primary prefix starts with *
+// only count for the DARC WAE award, like Sicily, so they're skipped and their calls resolve to the
+// DXCC entity they're part of.
+func parseCtyDat(r io.Reader) (*dxccResolver, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	resolver := newDxccResolver()
+	for _, record := range strings.Split(string(data), ";") {
+		if strings.TrimSpace(record) == "" {
+			continue
+		}
+		fields := strings.SplitN(record, ":", 9)
+		if len(fields) != 9 {
+			return nil, fmt.Errorf("bad cty.dat entity %q", strings.TrimSpace(record))
+		}
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		if strings.HasPrefix(fields[7], "*") {
+			continue
+		}
+		entity := dxccEntity{name: fields[0], continent: fields[3]}
+		cq, cqErr := strconv.ParseUint(fields[1], 10, 32)
+		itu, ituErr := strconv.ParseUint(fields[2], 10, 32)
+		if cqErr != nil || ituErr != nil {
+			return nil, fmt.Errorf("bad zones for cty.dat entity %q", entity.name)
+		}
+		entity.cqZone, entity.ituZone = uint32(cq), uint32(itu)
+		resolver.addPrefix(fields[7], dxccEntry{entity: entity})
+		for _, alias := range strings.Split(fields[8], ",") {
+			alias = strings.TrimSpace(alias)
+			if alias == "" {
+				continue
+			}
+			err = resolver.addCtyAlias(alias, entity)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return resolver, nil
+}
+
+func (r *dxccResolver) addCtyAlias(alias string, entity dxccEntity) error {
+	exact := strings.HasPrefix(alias, "=")
+	name := strings.TrimPrefix(alias, "=")
+	overrides := ""
+	if i := strings.IndexAny(name, "([<{~"); i >= 0 {
+		name, overrides = name[:i], name[i:]
+	}
+	for overrides != "" {
+		end := strings.IndexByte(overrides[1:], ctyOverrideEnds[overrides[0]])
+		if end < 0 {
+			return fmt.Errorf("bad cty.dat alias %q", alias)
+		}
+		value := overrides[1 : end+1]
+		switch overrides[0] {
+		case '(':
+			zone, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return fmt.Errorf("bad CQ zone in cty.dat alias %q", alias)
+			}
+			entity.cqZone = uint32(zone)
+		case '[':
+			zone, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return fmt.Errorf("bad ITU zone in cty.dat alias %q", alias)
+			}
+			entity.ituZone = uint32(zone)
+		case '{':
+			entity.continent = value
+		}
+		overrides = overrides[end+2:]
+	}
+	if exact {
+		r.calls[name] = append(r.calls[name], dxccEntry{entity: entity})
+	} else {
+		r.addPrefix(name, dxccEntry{entity: entity})
+	}
+	return nil
+}
+
+// clubLogCty is a Club Log cty.xml file. Every kind of record can be limited by dates.
+type clubLogCty struct {
+	Entities   []clubLogRecord `xml:"entities>entity"`
+	Exceptions []clubLogRecord `xml:"exceptions>exception"`
+	Prefixes   []clubLogRecord `xml:"prefixes>prefix"`
+	Invalid    []clubLogRecord `xml:"invalid_operations>invalid"`
+	Zones      []clubLogRecord `xml:"zone_exceptions>zone_exception"`
+}
+
+type clubLogRecord struct {
+	Call string `xml:"call"`
+	// Name is an entity's name; Entity is the name of the entity a call or prefix is in.
+	Name   string `xml:"name"`
+	Entity string `xml:"entity"`
+	Adif   uint32 `xml:"adif"`
+	CqZone uint32 `xml:"cqz"`
+	// Zone is a zone exception's CQ zone.
+	Zone      uint32 `xml:"zone"`
+	Continent string `xml:"cont"`
+	Start     string `xml:"start"`
+	End       string `xml:"end"`
+}
+
+func (c clubLogRecord) entry() (dxccEntry, error) {
+	entry := dxccEntry{entity: dxccEntity{
+		dxcc: c.Adif, name: c.Entity, cqZone: max(c.CqZone, c.Zone), continent: c.Continent,
+	}}
+	var err error
+	if c.Start != "" {
+		entry.start, err = time.Parse(time.RFC3339, c.Start)
+		if err != nil {
+			return entry, fmt.Errorf("bad start for %v: %w", c.Call, err)
+		}
+	}
+	if c.End != "" {
+		entry.end, err = time.Parse(time.RFC3339, c.End)
+		if err != nil {
+			return entry, fmt.Errorf("bad end for %v: %w", c.Call, err)
+		}
+	}
+	return entry, nil
+}
+
+// parseClubLogXML reads a Club Log cty.xml file. It doesn't have ITU zones.
+func parseClubLogXML(r io.Reader) (*dxccResolver, error) {
+	var cty clubLogCty
+	err := xml.NewDecoder(r).Decode(&cty)
+	if err != nil {
+		return nil, err
+	}
+	resolver := newDxccResolver()
+	names := map[uint32]string{}
+	for _, entity := range cty.Entities {
+		names[entity.Adif] = entity.Name
+	}
+	add := func(records []clubLogRecord, to func(string, dxccEntry)) error {
+		for _, record := range records {
+			entry, err := record.entry()
+			if err != nil {
+				return err
+			}
+			if name, ok := names[record.Adif]; ok {
+				entry.entity.name = name
+			}
+			to(strings.ToUpper(record.Call), entry)
+		}
+		return nil
+	}
+	addCall := func(call string, entry dxccEntry) {
+		resolver.calls[call] = append(resolver.calls[call], entry)
+	}
+	err = errors.Join(
+		add(cty.Prefixes, resolver.addPrefix),
+		add(cty.Exceptions, addCall),
+		add(cty.Invalid, func(call string, entry dxccEntry) {
+			entry.invalid = true
+			addCall(call, entry)
+		}),
+		add(cty.Zones, func(call string, entry dxccEntry) {
+			resolver.zones[call] = append(resolver.zones[call], entry)
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return resolver, nil
+}
+
+// readDxccPrefixes reads a prefix file in either format, gzipped or not.
+func readDxccPrefixes(r io.Reader) (*dxccResolver, error) {
+	reader := bufio.NewReader(r)
+	if magic, _ := reader.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		reader = bufio.NewReader(gz)
+	}
+	start, _ := reader.Peek(512)
+	start = bytes.TrimSpace(bytes.TrimPrefix(start, []byte("\xef\xbb\xbf")))
+	if bytes.HasPrefix(start, []byte("<")) {
+		return parseClubLogXML(reader)
+	}
+	return parseCtyDat(reader)
+}
+
+// openDxccPrefixFile opens the prefix file. A relative path which isn't in the working directory
+// is looked for with the deployed function's source, where Cloud Functions puts it.
+func openDxccPrefixFile(path string) (*os.File, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) && !filepath.IsAbs(path) {
+		return os.Open(filepath.Join("serverless_function_source_code", path))
+	}
+	return f, err
+}
+
+// loadDxccResolver loads the prefix file the first time it's needed, returning nil if there isn't
+// one. It's a var so tests can use their own prefixes.
+var loadDxccResolver = sync.OnceValue(func() *dxccResolver {
+	path := os.Getenv(dxccPrefixFileEnv)
+	if path == "" {
+		log.Printf("%v isn't set; not resolving DXCC entities", dxccPrefixFileEnv)
+		return nil
+	}
+	f, err := openDxccPrefixFile(path)
+	if err != nil {
+		log.Printf("Couldn't open the DXCC prefix file: %v", err)
+		return nil
+	}
+	defer f.Close()
+	resolver, err := readDxccPrefixes(f)
+	if err != nil {
+		log.Printf("Couldn't read the DXCC prefix file %v: %v", path, err)
+		return nil
+	}
+	log.Printf("Loaded %d DXCC prefixes and %d callsign exceptions from %v",
+		len(resolver.prefixes), len(resolver.calls), path)
+	return resolver
+})
+
+// fillFromDxcc fills in the contacted station's DXCC entity, country, zones and continent where
+// they're empty, by resolving its callsign as of the QSO's date. Nothing is filled if the station
+// already has a different DXCC entity. Returns whether anything changed.
+func fillFromDxcc(qso *adifpb.Qso) bool {
+	resolver := loadDxccResolver()
+	station := qso.ContactedStation
+	if resolver == nil || station == nil {
+		return false
+	}
+	at := time.Now()
+	if qso.TimeOn != nil {
+		at = qso.TimeOn.AsTime()
+	}
+	entity, ok := resolver.resolve(station.StationCall, at)
+	if !ok || (station.Dxcc != 0 && entity.dxcc != 0 && station.Dxcc != entity.dxcc) {
+		return false
+	}
+	changed := false
+	fill := func(value *uint32, from uint32) {
+		if *value == 0 && from != 0 {
+			*value = from
+			changed = true
+		}
+	}
+	fill(&station.Dxcc, entity.dxcc)
+	fill(&station.CqZone, entity.cqZone)
+	fill(&station.ItuZone, entity.ituZone)
+	if station.Country == "" && entity.name != "" {
+		station.Country = fixToTitle(entity.name)
+		changed = true
+	}
+	if station.Continent == "" && entity.continent != "" {
+		station.Continent = fixToUpper(entity.continent)
+		changed = true
+	}
+	return changed
+}
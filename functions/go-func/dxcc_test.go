@@ -0,0 +1,240 @@
+package forester
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+	"time"
+
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const testCtyDat = `Hawaii:                   31:  61:  OC:   21.12:   157.48:    10.0:  KH6:
+    AH6,AH7,KH6,KH7,NH6,NH7,WH6,WH7,=K0SWE/KH6(32);
+United States:            05:  08:  NA:   37.53:    91.67:     5.0:  K:
+    4U1WB,AA,K,N,W,
+    AA0(4)[7],K0(4)[7],N0(4)[7],W0(4)[7],
+    =KL7XX{OC},
+    AA4,K4,N4,W4;
+Federal Republic of Germany: 14: 28: EU: 51.00: -10.00: -1.0: DL:
+    DA,DB,DC,DD,DE,DF,DG,DH,DI,DJ,DK,DL,DM,DN,DO,DP,DQ,DR;
+Italy:                    15:  28:  EU:   42.82:   -12.58:    -1.0:  I:
+    I;
+Sicily:                   15:  28:  EU:   37.50:   -14.00:    -1.0:  *IT9:
+    IB9,ID9,IE9,IF9,II9,IJ9,IO9,IQ9,IR9,IT9,IU9,IW9,IY9;
+`
+
+const testClubLogXML = `<?xml version="1.0" encoding="UTF-8"?>
+<clublog date="2020-11-01T00:00:00+00:00" xmlns="https://clublog.org/cty/v1.2">
+<entities>
+<entity><adif>291</adif><name>UNITED STATES OF AMERICA</name><prefix>K</prefix><cqz>5</cqz><cont>NA</cont></entity>
+<entity><adif>22</adif><name>PALAU</name><prefix>T8</prefix><cqz>27</cqz><cont>OC</cont></entity>
+<entity><adif>517</adif><name>CURACAO</name><prefix>PJ2</prefix><cqz>9</cqz><cont>SA</cont><start>2010-10-10T00:00:00+00:00</start></entity>
+<entity><adif>520</adif><name>BONAIRE</name><prefix>PJ4</prefix><cqz>9</cqz><cont>SA</cont><start>2010-10-10T00:00:00+00:00</start></entity>
+<entity><adif>62</adif><name>NETHERLANDS ANTILLES</name><prefix>PJ</prefix><cqz>9</cqz><cont>SA</cont><end>2010-10-09T23:59:59+00:00</end></entity>
+</entities>
+<exceptions>
+<exception record="1"><call>KC6RJW</call><entity>PALAU</entity><adif>22</adif><cqz>27</cqz><cont>OC</cont><start>2003-03-09T00:00:00+00:00</start><end>2003-03-23T23:59:59+00:00</end></exception>
+</exceptions>
+<prefixes>
+<prefix record="1"><call>K</call><entity>UNITED STATES OF AMERICA</entity><adif>291</adif><cqz>5</cqz><cont>NA</cont></prefix>
+<prefix record="2"><call>T8</call><entity>PALAU</entity><adif>22</adif><cqz>27</cqz><cont>OC</cont></prefix>
+<prefix record="3"><call>PJ2</call><entity>NETHERLANDS ANTILLES</entity><adif>62</adif><cqz>9</cqz><cont>SA</cont><end>2010-10-09T23:59:59+00:00</end></prefix>
+<prefix record="4"><call>PJ2</call><entity>CURACAO</entity><adif>517</adif><cqz>9</cqz><cont>SA</cont><start>2010-10-10T00:00:00+00:00</start></prefix>
+<prefix record="5"><call>PJ4</call><entity>NETHERLANDS ANTILLES</entity><adif>62</adif><cqz>9</cqz><cont>SA</cont><end>2010-10-09T23:59:59+00:00</end></prefix>
+<prefix record="6"><call>PJ4</call><entity>BONAIRE</entity><adif>520</adif><cqz>9</cqz><cont>SA</cont><start>2010-10-10T00:00:00+00:00</start></prefix>
+</prefixes>
+<invalid_operations>
+<invalid record="1"><call>T88A</call><start>2005-01-01T00:00:00+00:00</start><end>2005-12-31T23:59:59+00:00</end></invalid>
+</invalid_operations>
+<zone_exceptions>
+<zone_exception record="1"><call>K0SWE/P</call><zone>1</zone><start>2019-01-01T00:00:00+00:00</start><end>2019-12-31T23:59:59+00:00</end></zone_exception>
+</zone_exceptions>
+</clublog>
+`
+
+func Test_parseCtyDat_resolve(t *testing.T) {
+	resolver, err := parseCtyDat(strings.NewReader(testCtyDat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	usa := dxccEntity{name: "United States", cqZone: 5, ituZone: 8, continent: "NA"}
+	tests := []struct {
+		call   string
+		want   dxccEntity
+		wantOk bool
+	}{
+		{call: "W1AW", want: usa, wantOk: true},
+		{call: "k0swe", want: dxccEntity{name: "United States", cqZone: 4, ituZone: 7, continent: "NA"}, wantOk: true},
+		{call: "K0SWE/4", want: usa, wantOk: true},
+		{call: "K0SWE/P", want: dxccEntity{name: "United States", cqZone: 4, ituZone: 7, continent: "NA"}, wantOk: true},
+		{call: "KH6/K0SWE", want: dxccEntity{name: "Hawaii", cqZone: 31, ituZone: 61, continent: "OC"}, wantOk: true},
+		{call: "K0SWE/KH6", want: dxccEntity{name: "Hawaii", cqZone: 32, ituZone: 61, continent: "OC"}, wantOk: true},
+		{call: "KL7XX", want: dxccEntity{name: "United States", cqZone: 5, ituZone: 8, continent: "OC"}, wantOk: true},
+		{call: "DL1ABC", want: dxccEntity{name: "Federal Republic of Germany", cqZone: 14, ituZone: 28, continent: "EU"}, wantOk: true},
+		{call: "IT9ABC", want: dxccEntity{name: "Italy", cqZone: 15, ituZone: 28, continent: "EU"}, wantOk: true},
+		{call: "K0SWE/MM", wantOk: false},
+		{call: "JA1XYZ", wantOk: false},
+		{call: "", wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.call, func(t *testing.T) {
+			got, ok := resolver.resolve(tt.call, now)
+			if ok != tt.wantOk || (ok && got != tt.want) {
+				t.Errorf("resolve() got = %+v, %v, want %+v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_parseClubLogXML_resolve(t *testing.T) {
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	_, _ = gz.Write([]byte(testClubLogXML))
+	_ = gz.Close()
+	resolver, err := readDxccPrefixes(&gzipped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 12, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name   string
+		call   string
+		at     time.Time
+		want   dxccEntity
+		wantOk bool
+	}{
+		{
+			name: "exception", call: "KC6RJW", at: date(2003, 3, 10), wantOk: true,
+			want: dxccEntity{dxcc: 22, name: "PALAU", cqZone: 27, continent: "OC"},
+		},
+		{
+			name: "after the exception", call: "KC6RJW", at: date(2003, 4, 1), wantOk: true,
+			want: dxccEntity{dxcc: 291, name: "UNITED STATES OF AMERICA", cqZone: 5, continent: "NA"},
+		},
+		{
+			name: "before a split", call: "PJ2T", at: date(2009, 1, 1), wantOk: true,
+			want: dxccEntity{dxcc: 62, name: "NETHERLANDS ANTILLES", cqZone: 9, continent: "SA"},
+		},
+		{
+			name: "after a split", call: "PJ2T", at: date(2011, 1, 1), wantOk: true,
+			want: dxccEntity{dxcc: 517, name: "CURACAO", cqZone: 9, continent: "SA"},
+		},
+		{
+			name: "invalid operation", call: "T88A", at: date(2005, 6, 1), wantOk: false,
+		},
+		{
+			name: "valid operation", call: "T88A", at: date(2006, 6, 1), wantOk: true,
+			want: dxccEntity{dxcc: 22, name: "PALAU", cqZone: 27, continent: "OC"},
+		},
+		{
+			name: "zone exception", call: "K0SWE/P", at: date(2019, 6, 1), wantOk: true,
+			want: dxccEntity{dxcc: 291, name: "UNITED STATES OF AMERICA", cqZone: 1, continent: "NA"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := resolver.resolve(tt.call, tt.at)
+			if ok != tt.wantOk || (ok && got != tt.want) {
+				t.Errorf("resolve() got = %+v, %v, want %+v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_dxccPrefixCall(t *testing.T) {
+	tests := []struct {
+		call   string
+		want   string
+		wantOk bool
+	}{
+		{call: "K0SWE", want: "K0SWE", wantOk: true},
+		{call: "KH6/K0SWE", want: "KH6", wantOk: true},
+		{call: "K0SWE/KH6", want: "KH6", wantOk: true},
+		{call: "KH6/K0SWE/P", want: "KH6", wantOk: true},
+		{call: "W1AW/4", want: "W4AW", wantOk: true},
+		{call: "2E0ABC/8", want: "2E8ABC", wantOk: true},
+		{call: "K0SWE/QRP", want: "K0SWE", wantOk: true},
+		{call: "K0SWE/MM", wantOk: false},
+		{call: "K0SWE/AM", wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.call, func(t *testing.T) {
+			got, ok := dxccPrefixCall(tt.call)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("dxccPrefixCall() got = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_parseCtyDat_errors(t *testing.T) {
+	tests := []string{
+		"Nowhere: 5: 8: NA;",
+		"Nowhere: x: 8: NA: 0: 0: 0: X:\n X;",
+		"Nowhere: 5: 8: NA: 0: 0: 0: X:\n X(5;",
+	}
+	for _, tt := range tests {
+		if _, err := parseCtyDat(strings.NewReader(tt)); err == nil {
+			t.Errorf("parseCtyDat(%q) got nil error", tt)
+		}
+	}
+}
+
+func Test_fillFromDxcc(t *testing.T) {
+	resolver, err := parseClubLogXML(strings.NewReader(testClubLogXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := loadDxccResolver
+	loadDxccResolver = func() *dxccResolver { return resolver }
+	defer func() { loadDxccResolver = previous }()
+
+	timeOn := timestamppb.New(time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC))
+	tests := []struct {
+		name        string
+		station     *adifpb.Station
+		want        *adifpb.Station
+		wantChanged bool
+	}{
+		{
+			name:        "empty",
+			station:     &adifpb.Station{StationCall: "PJ2T"},
+			want:        &adifpb.Station{StationCall: "PJ2T", Dxcc: 517, Country: "Curacao", CqZone: 9, Continent: "SA"},
+			wantChanged: true,
+		},
+		{
+			name:        "partly filled",
+			station:     &adifpb.Station{StationCall: "PJ2T", Dxcc: 517, CqZone: 10, Country: "Curaçao"},
+			want:        &adifpb.Station{StationCall: "PJ2T", Dxcc: 517, CqZone: 10, Country: "Curaçao", Continent: "SA"},
+			wantChanged: true,
+		},
+		{
+			name:    "different entity",
+			station: &adifpb.Station{StationCall: "PJ2T", Dxcc: 62},
+			want:    &adifpb.Station{StationCall: "PJ2T", Dxcc: 62},
+		},
+		{
+			name:    "maritime mobile",
+			station: &adifpb.Station{StationCall: "K0SWE/MM"},
+			want:    &adifpb.Station{StationCall: "K0SWE/MM"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qso := &adifpb.Qso{TimeOn: timeOn, ContactedStation: tt.station}
+			if got := fillFromDxcc(qso); got != tt.wantChanged {
+				t.Errorf("fillFromDxcc() got = %v, want %v", got, tt.wantChanged)
+			}
+			if !proto.Equal(qso.ContactedStation, tt.want) {
+				t.Errorf("fillFromDxcc() station got = %v, want %v", qso.ContactedStation, tt.want)
+			}
+		})
+	}
+}
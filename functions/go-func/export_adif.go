@@ -0,0 +1,190 @@
+package forester
+
+import (
+	"context"
+	"fmt"
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// exportFilter selects which contacts ExportAdif writes. Zero values don't filter.
+type exportFilter struct {
+	// from and to bound the contact's start time; to is exclusive.
+	from        time.Time
+	to          time.Time
+	band        string
+	mode        string
+	stationCall string
+	// confirmed is one of "", "any", "none", "card", "eqsl" or "lotw".
+	confirmed string
+}
+
+// parseExportFilter reads the filter from query params: from and to are inclusive dates
+// (YYYY-MM-DD), and band, mode, stationCallsign and confirmed are matched as described on
+// exportFilter.
+func parseExportFilter(query url.Values) (exportFilter, error) {
+	var f exportFilter
+	if from := query.Get("from"); from != "" {
+		t, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			return f, fmt.Errorf("bad from date %q, want YYYY-MM-DD", from)
+		}
+		f.from = t
+	}
+	if to := query.Get("to"); to != "" {
+		t, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			return f, fmt.Errorf("bad to date %q, want YYYY-MM-DD", to)
+		}
+		f.to = t.AddDate(0, 0, 1)
+	}
+	f.band = query.Get("band")
+	f.mode = query.Get("mode")
+	f.stationCall = query.Get("stationCallsign")
+	f.confirmed = strings.ToLower(query.Get("confirmed"))
+	switch f.confirmed {
+	case "", "any", "none", "card", "eqsl", "lotw":
+	default:
+		return f, fmt.Errorf("bad confirmed value %q, want any, none, card, eqsl or lotw", f.confirmed)
+	}
+	return f, nil
+}
+
+func (f exportFilter) matches(qso *adifpb.Qso) bool {
+	timeOn := qso.TimeOn.AsTime()
+	if !f.from.IsZero() && timeOn.Before(f.from) {
+		return false
+	}
+	if !f.to.IsZero() && !timeOn.Before(f.to) {
+		return false
+	}
+	if f.band != "" && !strings.EqualFold(qso.Band, f.band) {
+		return false
+	}
+	if f.mode != "" && !strings.EqualFold(qso.Mode, f.mode) && !strings.EqualFold(qso.Submode, f.mode) {
+		return false
+	}
+	if f.stationCall != "" && !strings.EqualFold(qso.LoggingStation.GetStationCall(), f.stationCall) {
+		return false
+	}
+	card, eqsl, lotw := isConfirmed(qso.Card), isConfirmed(qso.Eqsl), isConfirmed(qso.Lotw)
+	switch f.confirmed {
+	case "any":
+		return card || eqsl || lotw
+	case "none":
+		return !card && !eqsl && !lotw
+	case "card":
+		return card
+	case "eqsl":
+		return eqsl
+	case "lotw":
+		return lotw
+	}
+	return true
+}
+
+func isConfirmed(qsl *adifpb.Qsl) bool {
+	return strings.EqualFold(qsl.GetReceivedStatus(), "Y")
+}
+
+// ExportAdif streams the logbook's contacts as an ADIF file, oldest first. It's ADI unless the
+// format=adx param asks for ADX. The contacts can be filtered with query params; see
+// parseExportFilter. Contacts without a start time aren't valid ADIF and are left out. Called via
+// GCP Cloud Functions.
+func ExportAdif(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if handleCorsOptions(w, r) {
+		return
+	}
+	log.Print("Starting ExportAdif")
+	filter, err := parseExportFilter(r.URL.Query())
+	if err != nil {
+		writeError(400, "Bad filter", err, w)
+		return
+	}
+	format, err := parseAdifFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		writeError(400, "Bad format", err, w)
+		return
+	}
+	fb, err := MakeFirebaseManager(&ctx, r)
+	if err != nil {
+		writeError(500, "Error", err, w)
+		return
+	}
+	contentType := "application/octet-stream"
+	if format == formatAdx {
+		contentType = "application/xml"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fb.logbookID+"."+string(format)))
+	count, err := exportAdif(w, fb, filter, format, time.Now())
+	if err != nil {
+		// The response has already started, so all we can do is log it
+		log.Printf("Error writing ADIF export: %v", err)
+		return
+	}
+	log.Printf("Exported %d contacts", count)
+}
+
+// exportAdif writes a complete ADIF file in the format, with a header declaring the logbook's
+// user-defined fields, of the store's contacts which match the filter. They're written as they're
+// read, oldest first, so the log doesn't have to fit in memory.
+func exportAdif(w io.Writer, store QsoStore, filter exportFilter, format adifFormat, created time.Time) (int, error) {
+	userDefs, err := loadUserDefs(store)
+	if err != nil {
+		return 0, err
+	}
+	encoder := newQsoEncoder(w, format)
+	err = encoder.WriteHeader(&adifpb.Header{CreatedTimestamp: timestamppb.New(created)}, sortedUserDefs(userDefs))
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	err = store.EachContact(func(fsQso FirestoreQso) error {
+		if !filter.matches(fsQso.qsopb) {
+			return nil
+		}
+		count++
+		return encoder.Encode(withoutBookkeeping(fsQso.qsopb))
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, encoder.Close()
+}
+
+// withoutBookkeeping copies the contact without the app-defined fields Forester keeps for itself:
+// its own app_forester_ fields and the QRZ.com logid it syncs with.
+func withoutBookkeeping(qso *adifpb.Qso) *adifpb.Qso {
+	stripped := cloneQso(qso)
+	for k := range stripped.AppDefined {
+		if strings.HasPrefix(k, "app_forester_") || k == qrzLogIDKey {
+			delete(stripped.AppDefined, k)
+		}
+	}
+	return stripped
+}
+
+// withoutUploadBookkeeping copies the contact to be uploaded to a service, without its upload
+// statuses or Forester's own app_forester_ fields. The QRZ.com logid is kept, since QRZ.com uses it;
+// uploads elsewhere should drop it.
+func withoutUploadBookkeeping(qso *adifpb.Qso) *adifpb.Qso {
+	stripped := cloneQso(qso)
+	stripped.Qrzcom = nil
+	stripped.Clublog = nil
+	stripped.Hrdlog = nil
+	for k := range stripped.AppDefined {
+		if strings.HasPrefix(k, "app_forester_") {
+			delete(stripped.AppDefined, k)
+		}
+	}
+	return stripped
+}
@@ -0,0 +1,127 @@
+package forester
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func Test_exportFilter_matches(t *testing.T) {
+	qso := &adifpb.Qso{
+		TimeOn:           timestamppb.New(time.Date(2020, 10, 25, 23, 59, 0, 0, time.UTC)),
+		Band:             "20m",
+		Mode:             "SSB",
+		Submode:          "USB",
+		LoggingStation:   &adifpb.Station{StationCall: "K0SWE"},
+		ContactedStation: &adifpb.Station{StationCall: "N6DN"},
+		Lotw:             &adifpb.Qsl{ReceivedStatus: "Y"},
+	}
+	tests := []struct {
+		name  string
+		query string
+		want  bool
+	}{
+		{name: "no filter", query: "", want: true},
+		{name: "in date range", query: "from=2020-10-25&to=2020-10-25", want: true},
+		{name: "before range", query: "from=2020-10-26", want: false},
+		{name: "after range", query: "to=2020-10-24", want: false},
+		{name: "band", query: "band=20M", want: true},
+		{name: "other band", query: "band=40m", want: false},
+		{name: "mode", query: "mode=ssb", want: true},
+		{name: "submode", query: "mode=USB", want: true},
+		{name: "other mode", query: "mode=CW", want: false},
+		{name: "station call", query: "stationCallsign=k0swe", want: true},
+		{name: "other station call", query: "stationCallsign=K0SWE/P", want: false},
+		{name: "confirmed any", query: "confirmed=any", want: true},
+		{name: "confirmed lotw", query: "confirmed=lotw", want: true},
+		{name: "confirmed eqsl", query: "confirmed=eqsl", want: false},
+		{name: "unconfirmed", query: "confirmed=none", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt.query)
+			filter, err := parseExportFilter(query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := filter.matches(qso); got != tt.want {
+				t.Errorf("matches() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseExportFilter_errors(t *testing.T) {
+	tests := []string{"from=10/25/2020", "to=yesterday", "confirmed=maybe"}
+	for _, tt := range tests {
+		t.Run(tt, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt)
+			if _, err := parseExportFilter(query); err == nil {
+				t.Errorf("parseExportFilter() got nil error for %v", tt)
+			}
+		})
+	}
+}
+
+func Test_exportAdif(t *testing.T) {
+	store := NewMemoryQsoStore()
+	for _, qso := range []*adifpb.Qso{
+		{
+			TimeOn:           timestamppb.New(time.Date(2020, 10, 25, 21, 0, 0, 0, time.UTC)),
+			ContactedStation: &adifpb.Station{StationCall: "K9IJ"},
+			AppDefined: map[string]string{
+				"app_forester_qrzlog_synced_hash": "abc123",
+				qrzLogIDKey:                       "557243669",
+				"app_n1mm_exchange1":              "5NN",
+			},
+		},
+		{
+			TimeOn:           timestamppb.New(time.Date(2020, 10, 25, 20, 0, 0, 0, time.UTC)),
+			ContactedStation: &adifpb.Station{StationCall: "N6DN"},
+		},
+		{
+			TimeOn:           timestamppb.New(time.Date(2020, 10, 26, 20, 0, 0, 0, time.UTC)),
+			ContactedStation: &adifpb.Station{StationCall: "KE0RCW"},
+		},
+	} {
+		_ = store.Create(qso)
+	}
+	var b strings.Builder
+	filter, _ := parseExportFilter(url.Values{"to": {"2020-10-25"}})
+	count, err := exportAdif(&b, store, filter, formatAdi, time.Date(2020, 11, 1, 12, 30, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := b.String()
+	header, records, ok := strings.Cut(got, "<EOH>")
+	if !ok {
+		t.Fatalf("exportAdif() got = %v, want a header", got)
+	}
+	for _, want := range []string{
+		"<ADIF_VER:5>3.1.4",
+		"<CREATED_TIMESTAMP:15>20201101 123000",
+		"<PROGRAMID:13>forester-func",
+		"<PROGRAMVERSION:",
+	} {
+		if !strings.Contains(header, want) {
+			t.Errorf("exportAdif() header got = %v, want %v", header, want)
+		}
+	}
+	if strings.HasPrefix(header, "<") {
+		t.Errorf("exportAdif() header got = %v, mustn't start with a tag", header)
+	}
+	if strings.Index(records, "N6DN") > strings.Index(records, "K9IJ") {
+		t.Errorf("exportAdif() records got = %v, want oldest first", records)
+	}
+	if strings.Contains(records, "APP_FORESTER_") || strings.Contains(records, "APP_QRZLOG_LOGID") ||
+		!strings.Contains(records, "APP_N1MM_EXCHANGE1") {
+		t.Errorf("exportAdif() records got = %v, want only the other app's field", records)
+	}
+	if count != 2 || strings.Count(records, "<EOR>") != 2 || strings.Contains(records, "KE0RCW") {
+		t.Errorf("exportAdif() records got %d = %v, want 2", count, records)
+	}
+}
@@ -0,0 +1,216 @@
+package forester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cabrilloRequest says which contest to export, and what to say about the entry.
+type cabrilloRequest struct {
+	// contestID is the ADIF CONTEST_ID of the QSOs, which is also the Cabrillo CONTEST.
+	contestID string
+	// start and end bound the QSOs' start time; end is exclusive. Zero values don't filter.
+	start  time.Time
+	end    time.Time
+	header CabrilloHeader
+	sent   sentExchange
+}
+
+// cabrilloCategories are the values allowed in the Cabrillo category tags which have a fixed list.
+var cabrilloCategories = map[string][]string{
+	"categoryOperator":    {"SINGLE-OP", "MULTI-OP", "CHECKLOG"},
+	"categoryAssisted":    {"ASSISTED", "NON-ASSISTED"},
+	"categoryMode":        {"CW", "DIGI", "FM", "RTTY", "SSB", "MIXED"},
+	"categoryPower":       {"HIGH", "LOW", "QRP"},
+	"categoryTransmitter": {"ONE", "TWO", "LIMITED", "UNLIMITED", "SWL"},
+}
+
+// parseCabrilloRequest reads the request from query params. contest is required; start and end are
+// RFC 3339 times. The header params are named like the CabrilloHeader fields, like
+// categoryOperator; operators are separated by spaces or commas. The parts of the exchange we sent
+// which aren't on the QSOs are sentClass (Field Day), sentPrecedence and sentCheck (Sweepstakes),
+// sentName (NAQP) and sentZone (CQ WW); the section or state we sent is the location, which Field
+// Day and Sweepstakes need.
+func parseCabrilloRequest(query url.Values) (cabrilloRequest, error) {
+	var req cabrilloRequest
+	req.contestID = strings.ToUpper(query.Get("contest"))
+	if req.contestID == "" {
+		return req, errors.New("missing contest, want its ADIF CONTEST_ID like ARRL-FD")
+	}
+	for _, bound := range []struct {
+		param string
+		t     *time.Time
+	}{{"start", &req.start}, {"end", &req.end}} {
+		if value := query.Get(bound.param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return req, fmt.Errorf("bad %v time %q, want RFC 3339 like 2020-06-27T18:00:00Z", bound.param, value)
+			}
+			*bound.t = t
+		}
+	}
+	for param, allowed := range cabrilloCategories {
+		value := strings.ToUpper(query.Get(param))
+		if value != "" && !slices.Contains(allowed, value) {
+			return req, fmt.Errorf("bad %v %q, want one of %v", param, value, strings.Join(allowed, ", "))
+		}
+	}
+	if score := query.Get("claimedScore"); score != "" {
+		if _, err := strconv.ParseUint(score, 10, 64); err != nil {
+			return req, fmt.Errorf("bad claimedScore %q, want a whole number", score)
+		}
+	}
+	upper := func(param string) string {
+		return strings.ToUpper(strings.TrimSpace(query.Get(param)))
+	}
+	req.header = CabrilloHeader{
+		Contest:             req.contestID,
+		Callsign:            upper("callsign"),
+		Location:            upper("location"),
+		CategoryOperator:    upper("categoryOperator"),
+		CategoryAssisted:    upper("categoryAssisted"),
+		CategoryBand:        upper("categoryBand"),
+		CategoryMode:        upper("categoryMode"),
+		CategoryPower:       upper("categoryPower"),
+		CategoryStation:     upper("categoryStation"),
+		CategoryTransmitter: upper("categoryTransmitter"),
+		ClaimedScore:        query.Get("claimedScore"),
+		Club:                query.Get("club"),
+		Operators: strings.FieldsFunc(upper("operators"), func(r rune) bool {
+			return r == ' ' || r == ','
+		}),
+		Name:  query.Get("name"),
+		Email: query.Get("email"),
+	}
+	if soapbox := query.Get("soapbox"); soapbox != "" {
+		req.header.Soapbox = strings.Split(soapbox, "\n")
+	}
+	req.sent = sentExchange{
+		class:      upper("sentClass"),
+		precedence: upper("sentPrecedence"),
+		check:      upper("sentCheck"),
+		name:       upper("sentName"),
+		cqZone:     upper("sentZone"),
+	}
+	return req, nil
+}
+
+func (req cabrilloRequest) matches(qso *adifpb.Qso) bool {
+	if !strings.EqualFold(qso.Contest.GetContestId(), req.contestID) {
+		return false
+	}
+	timeOn := qso.TimeOn.AsTime()
+	if !req.start.IsZero() && timeOn.Before(req.start) {
+		return false
+	}
+	return req.end.IsZero() || timeOn.Before(req.end)
+}
+
+// cabrilloQsos reads the store's QSOs in the contest, oldest first.
+func cabrilloQsos(store QsoStore, req cabrilloRequest) ([]*adifpb.Qso, error) {
+	var qsos []*adifpb.Qso
+	err := store.EachContact(func(fsQso FirestoreQso) error {
+		if req.matches(fsQso.qsopb) {
+			qsos = append(qsos, fsQso.qsopb)
+		}
+		return nil
+	})
+	return qsos, err
+}
+
+// completeCabrillo fills in what the request didn't say about the entry from the logging station of
+// the QSOs, and from the logbook, whose ID is its callsign. The location is only filled in from the
+// station's state when the contest doesn't send an ARRL section. It's an error if the contest's
+// exchange needs something which is still missing.
+func completeCabrillo(req cabrilloRequest, qsos []*adifpb.Qso, logbookID string) (CabrilloHeader, sentExchange, error) {
+	header, sent := req.header, req.sent
+	layout := cabrilloLayoutFor(req.contestID)
+	var station *adifpb.Station
+	if len(qsos) > 0 {
+		station = qsos[0].LoggingStation
+	}
+	fill := func(value *string, fallback string) {
+		if *value == "" {
+			*value = fallback
+		}
+	}
+	fill(&header.Callsign, strings.ToUpper(station.GetStationCall()))
+	fill(&header.Callsign, strings.ToUpper(logbookID))
+	if !layout.section {
+		fill(&header.Location, strings.ToUpper(station.GetState()))
+	}
+	fill(&header.Name, station.GetOpName())
+	fill(&header.AddressCity, station.GetCity())
+	fill(&header.AddressStateProv, station.GetState())
+	fill(&header.AddressPostalcode, station.GetPostalCode())
+	fill(&header.AddressCountry, station.GetCountry())
+	fill(&header.GridLocator, strings.ToUpper(station.GetGridSquare()))
+	fill(&header.Email, station.GetEmail())
+	if len(header.Address) == 0 && station.GetStreet() != "" {
+		header.Address = []string{station.GetStreet()}
+	}
+	if len(header.Operators) == 0 {
+		header.Operators = cabrilloOperators(qsos)
+	}
+	sent.location = header.Location
+	fill(&sent.name, strings.ToUpper(firstName(header.Name)))
+
+	var missing []string
+	if layout.missing != nil {
+		missing = layout.missing(sent)
+	}
+	if len(missing) > 0 {
+		return header, sent, fmt.Errorf("%v needs %v", req.contestID, strings.Join(missing, ", "))
+	}
+	return header, sent, nil
+}
+
+// ExportCabrillo writes the logbook's QSOs in a contest as a Cabrillo log, to be submitted to the
+// contest sponsor. The contest, time window and header are given with query params; see
+// parseCabrilloRequest. Called via GCP Cloud Functions.
+func ExportCabrillo(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if handleCorsOptions(w, r) {
+		return
+	}
+	log.Print("Starting ExportCabrillo")
+	req, err := parseCabrilloRequest(r.URL.Query())
+	if err != nil {
+		writeError(400, "Bad Cabrillo request", err, w)
+		return
+	}
+	fb, err := MakeFirebaseManager(&ctx, r)
+	if err != nil {
+		writeError(500, "Error", err, w)
+		return
+	}
+	qsos, err := cabrilloQsos(fb, req)
+	if err != nil {
+		writeError(500, "Error fetching contacts from firestore", err, w)
+		return
+	}
+	header, sent, err := completeCabrillo(req, qsos, fb.logbookID)
+	if err != nil {
+		writeError(400, "Missing part of the exchange", err, w)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	filename := fmt.Sprintf("%v-%v.log", header.Callsign, strings.ToLower(req.contestID))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	err = writeCabrillo(w, header, sent, qsos)
+	if err != nil {
+		log.Printf("Error writing Cabrillo export: %v", err)
+		return
+	}
+	log.Printf("Exported %d contacts in %v", len(qsos), req.contestID)
+}
@@ -0,0 +1,98 @@
+package forester
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func Test_parseCabrilloRequest_errors(t *testing.T) {
+	tests := []string{
+		"",
+		"contest=ARRL-FD&start=2020-06-27",
+		"contest=ARRL-FD&categoryPower=MEDIUM",
+		"contest=ARRL-FD&claimedScore=lots",
+	}
+	for _, tt := range tests {
+		t.Run(tt, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt)
+			if _, err := parseCabrilloRequest(query); err == nil {
+				t.Errorf("parseCabrilloRequest() got nil error for %v", tt)
+			}
+		})
+	}
+}
+
+func Test_cabrilloQsos(t *testing.T) {
+	store := NewMemoryQsoStore()
+	station := &adifpb.Station{StationCall: "K0SWE", OpCall: "KE0RCW", State: "CO", OpName: "Chris Keller"}
+	for _, qso := range []*adifpb.Qso{
+		{
+			TimeOn:           timestamppb.New(time.Date(2020, 6, 27, 19, 0, 0, 0, time.UTC)),
+			LoggingStation:   station,
+			ContactedStation: &adifpb.Station{StationCall: "K9IJ"},
+			Contest:          &adifpb.ContestData{ContestId: "ARRL-FD"},
+		},
+		{
+			TimeOn:           timestamppb.New(time.Date(2020, 6, 27, 18, 0, 0, 0, time.UTC)),
+			LoggingStation:   station,
+			ContactedStation: &adifpb.Station{StationCall: "N6DN"},
+			Contest:          &adifpb.ContestData{ContestId: "arrl-fd"},
+		},
+		{
+			TimeOn:           timestamppb.New(time.Date(2020, 6, 27, 18, 30, 0, 0, time.UTC)),
+			LoggingStation:   station,
+			ContactedStation: &adifpb.Station{StationCall: "W1AW"},
+		},
+		{
+			TimeOn:           timestamppb.New(time.Date(2019, 6, 22, 18, 0, 0, 0, time.UTC)),
+			LoggingStation:   station,
+			ContactedStation: &adifpb.Station{StationCall: "KE0RCW"},
+			Contest:          &adifpb.ContestData{ContestId: "ARRL-FD"},
+		},
+	} {
+		_ = store.Create(qso)
+	}
+	query, _ := url.ParseQuery("contest=arrl-fd&start=2020-06-27T18:00:00Z&end=2020-06-28T21:00:00Z")
+	req, err := parseCabrilloRequest(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qsos, err := cabrilloQsos(store, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls []string
+	for _, qso := range qsos {
+		calls = append(calls, qso.ContactedStation.StationCall)
+	}
+	if want := []string{"N6DN", "K9IJ"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("cabrilloQsos() got = %v, want %v", calls, want)
+	}
+
+	_, _, err = completeCabrillo(req, qsos, "k0swe")
+	if err == nil {
+		t.Errorf("completeCabrillo() got nil error without sentClass")
+	}
+	req.sent.class = "1D"
+	_, _, err = completeCabrillo(req, qsos, "k0swe")
+	if err == nil {
+		t.Errorf("completeCabrillo() got nil error without location")
+	}
+	req.header.Location = "CO"
+	header, sent, err := completeCabrillo(req, qsos, "k0swe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Callsign != "K0SWE" || header.Location != "CO" || header.Name != "Chris Keller" ||
+		!reflect.DeepEqual(header.Operators, []string{"KE0RCW"}) {
+		t.Errorf("completeCabrillo() header got = %+v", header)
+	}
+	if sent.location != "CO" || sent.class != "1D" {
+		t.Errorf("completeCabrillo() sent got = %+v", sent)
+	}
+}
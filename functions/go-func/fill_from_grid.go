@@ -0,0 +1,46 @@
+package forester
+
+import (
+	"math"
+
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	"github.com/k0swe/forester-func/maidenhead"
+)
+
+// minGridForLatLon is the shortest grid square a station's position is worked out from; a 2
+// character field is hundreds of kilometers across, so it's too rough to be worth keeping.
+const minGridForLatLon = 4
+
+// fillFromGrid fills in each station's latitude and longitude from its grid square if they're
+// missing, and then the distance between the stations if that's missing. Returns whether anything
+// changed.
+func fillFromGrid(qso *adifpb.Qso) bool {
+	changed := false
+	for _, station := range []*adifpb.Station{qso.LoggingStation, qso.ContactedStation} {
+		if station == nil || hasLatLon(station) || len(station.GridSquare) < minGridForLatLon {
+			continue
+		}
+		center, err := maidenhead.Center(station.GridSquare)
+		if err != nil {
+			continue
+		}
+		station.Latitude, station.Longitude = center.Latitude, center.Longitude
+		changed = true
+	}
+	if qso.DistanceKm == 0 && hasLatLon(qso.LoggingStation) && hasLatLon(qso.ContactedStation) {
+		km := maidenhead.DistanceKm(
+			maidenhead.Point{Latitude: qso.LoggingStation.Latitude, Longitude: qso.LoggingStation.Longitude},
+			maidenhead.Point{Latitude: qso.ContactedStation.Latitude, Longitude: qso.ContactedStation.Longitude})
+		if distance := uint32(math.Round(km)); distance > 0 {
+			qso.DistanceKm = distance
+			changed = true
+		}
+	}
+	return changed
+}
+
+// hasLatLon says whether the station's position is known. Like ADIF export, 0 is taken to be
+// empty.
+func hasLatLon(station *adifpb.Station) bool {
+	return station != nil && (station.Latitude != 0 || station.Longitude != 0)
+}
@@ -0,0 +1,90 @@
+package forester
+
+import (
+	"testing"
+
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	"google.golang.org/protobuf/proto"
+)
+
+func Test_fillFromGrid(t *testing.T) {
+	tests := []struct {
+		name        string
+		qso         *adifpb.Qso
+		want        *adifpb.Qso
+		wantChanged bool
+	}{
+		{
+			name: "grids",
+			qso: &adifpb.Qso{
+				LoggingStation:   &adifpb.Station{GridSquare: "DM79"},
+				ContactedStation: &adifpb.Station{GridSquare: "FN31"},
+			},
+			want: &adifpb.Qso{
+				DistanceKm:       2699,
+				LoggingStation:   &adifpb.Station{GridSquare: "DM79", Latitude: 39.5, Longitude: -105},
+				ContactedStation: &adifpb.Station{GridSquare: "FN31", Latitude: 41.5, Longitude: -73},
+			},
+			wantChanged: true,
+		},
+		{
+			name: "lat/lon kept",
+			qso: &adifpb.Qso{
+				LoggingStation:   &adifpb.Station{GridSquare: "DM79", Latitude: 39.5, Longitude: -105},
+				ContactedStation: &adifpb.Station{GridSquare: "JN58", Latitude: 48.5, Longitude: 11},
+			},
+			want: &adifpb.Qso{
+				DistanceKm:       8383,
+				LoggingStation:   &adifpb.Station{GridSquare: "DM79", Latitude: 39.5, Longitude: -105},
+				ContactedStation: &adifpb.Station{GridSquare: "JN58", Latitude: 48.5, Longitude: 11},
+			},
+			wantChanged: true,
+		},
+		{
+			name: "distance kept",
+			qso: &adifpb.Qso{
+				DistanceKm:       2700,
+				LoggingStation:   &adifpb.Station{Latitude: 39.5, Longitude: -105},
+				ContactedStation: &adifpb.Station{Latitude: 41.5, Longitude: -73},
+			},
+			want: &adifpb.Qso{
+				DistanceKm:       2700,
+				LoggingStation:   &adifpb.Station{Latitude: 39.5, Longitude: -105},
+				ContactedStation: &adifpb.Station{Latitude: 41.5, Longitude: -73},
+			},
+		},
+		{
+			name: "field and bad grid",
+			qso: &adifpb.Qso{
+				LoggingStation:   &adifpb.Station{GridSquare: "DM"},
+				ContactedStation: &adifpb.Station{GridSquare: "ZZ99"},
+			},
+			want: &adifpb.Qso{
+				LoggingStation:   &adifpb.Station{GridSquare: "DM"},
+				ContactedStation: &adifpb.Station{GridSquare: "ZZ99"},
+			},
+		},
+		{
+			name: "one station",
+			qso: &adifpb.Qso{
+				LoggingStation:   &adifpb.Station{},
+				ContactedStation: &adifpb.Station{GridSquare: "FN31"},
+			},
+			want: &adifpb.Qso{
+				LoggingStation:   &adifpb.Station{},
+				ContactedStation: &adifpb.Station{GridSquare: "FN31", Latitude: 41.5, Longitude: -73},
+			},
+			wantChanged: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fillFromGrid(tt.qso); got != tt.wantChanged {
+				t.Errorf("fillFromGrid() got = %v, want %v", got, tt.wantChanged)
+			}
+			if !proto.Equal(tt.qso, tt.want) {
+				t.Errorf("fillFromGrid() qso got = %v, want %v", tt.qso, tt.want)
+			}
+		})
+	}
+}
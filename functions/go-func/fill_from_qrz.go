@@ -5,6 +5,7 @@ import (
 	"cloud.google.com/go/pubsub"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	adifpb "github.com/k0swe/adif-json-protobuf/go"
 	"github.com/k0swe/qrz-api"
@@ -14,7 +15,9 @@ import (
 )
 
 // FillNewQsoFromQrz listens to Pub/Sub for new contacts in Firestore, and fills
-// in missing QSO details for the contacted station from QRZ.com.
+// in missing QSO details for the contacted station from QRZ.com, and then the
+// details which can be worked out from those; see enrichQso. The worked out
+// details are filled in even if QRZ.com can't be asked or doesn't know the call.
 func FillNewQsoFromQrz(ctx context.Context, m pubsub.Message) error {
 	var psMap map[string]string
 	err := json.Unmarshal(m.Data, &psMap)
@@ -31,49 +34,57 @@ func FillNewQsoFromQrz(ctx context.Context, m pubsub.Message) error {
 		return err
 	}
 	defer client.Close()
-	doc := client.Doc(firebasePath)
-	snapshot, err := doc.Get(ctx)
+	store := NewFirestoreQsoStore(ctx, client, logbookID)
+	qso, err := store.GetContact(contactID)
 	if err != nil {
 		return err
 	}
-	qso, err := ParseFirestoreQso(snapshot)
+
+	changed := false
+	station, lookupErr := lookupQrzStation(ctx, logbookID, qso.qsopb.ContactedStation.GetStationCall())
+	if lookupErr != nil {
+		log.Printf("Couldn't look up the contacted station on QRZ.com: %v", lookupErr)
+	} else if station != nil {
+		q := adifpb.Qso{ContactedStation: station, LoggingStation: &adifpb.Station{}}
+		fixCase(&q)
+		changed = mergeQso(qso.qsopb, &q)
+	}
+	if enrichQso(qso.qsopb) {
+		changed = true
+	}
+	if !changed {
+		log.Printf("Nothing to fill in on the contact")
+		return lookupErr
+	}
+	err = store.Update(qso)
 	if err != nil {
-		return err
+		return errors.Join(lookupErr, err)
 	}
+	log.Printf("Updated contact with QRZ.com and worked out details")
+	return lookupErr
+}
 
-	contactedStationCall := qso.qsopb.ContactedStation.StationCall
-	if contactedStationCall == "T3ST" {
-		log.Printf("Contacted station is special value T3ST; aborting lookup")
-		return nil
+// lookupQrzStation looks up the contacted station on QRZ.com with the logbook's
+// credentials. It's nil without an error for the special value T3ST.
+func lookupQrzStation(ctx context.Context, logbookID string, call string) (*adifpb.Station, error) {
+	if call == "T3ST" {
+		log.Printf("Contacted station is special value T3ST; skipping lookup")
+		return nil, nil
 	}
-
 	qrzUser, qrzPass, err := getQrzCreds(ctx, logbookID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	log.Printf("Querying QRZ.com for %v", contactedStationCall)
-	lookupResp, err := qrz.Lookup(ctx, &qrzUser, &qrzPass, &contactedStationCall)
+	log.Printf("Querying QRZ.com for %v", call)
+	lookupResp, err := qrz.Lookup(ctx, &qrzUser, &qrzPass, &call)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	log.Printf("QRZ.com lookup: %v is %v %v",
 		lookupResp.Callsign.Call, lookupResp.Callsign.Fname, lookupResp.Callsign.Name)
-
 	station := qrzLookupToStation(lookupResp.Callsign)
-	q := adifpb.Qso{ContactedStation: &station, LoggingStation: &adifpb.Station{}}
-	fixCase(&q)
-	mergeQso(qso.qsopb, &q)
-	j, err := qsoToJSON(qso.qsopb)
-	if err != nil {
-		return err
-	}
-	_, err = doc.Set(ctx, j)
-	if err != nil {
-		return err
-	}
-	log.Printf("Updated contact with QRZ.com details")
-	return nil
+	return &station, nil
 }
 
 func getQrzCreds(ctx context.Context, logbookID string) (string, string, error) {
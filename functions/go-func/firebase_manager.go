@@ -3,40 +3,34 @@ package forester
 import (
 	"cloud.google.com/go/firestore"
 	"context"
-	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	firebase "firebase.google.com/go/v4"
 	"firebase.google.com/go/v4/auth"
 	"fmt"
-	"dario.cat/mergo"
-	"github.com/jinzhu/copier"
 	adifpb "github.com/k0swe/adif-json-protobuf/go"
 	"golang.org/x/oauth2"
-	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 	"google.golang.org/protobuf/encoding/protojson"
-	"google.golang.org/protobuf/proto"
 	"log"
 	"net/http"
-	"strconv"
 	"strings"
-	"time"
 )
 
 type FirestoreQso struct {
 	qsopb  *adifpb.Qso
 	docref *firestore.DocumentRef
+	id     string
 }
 
+// FirebaseManager is a QsoStore for the requested logbook, acting as the requesting user.
 type FirebaseManager struct {
+	QsoStore
 	ctx             *context.Context
 	userToken       *auth.Token
 	logbookID       string
 	firestoreClient *firestore.Client
 	userDoc         *firestore.DocumentRef
-	logbookDoc      *firestore.DocumentRef
-	contactsCol     *firestore.CollectionRef
 }
 
 // MakeFirebaseManager does a bunch of initialization. It verifies the JWT and exchanges it for a
@@ -80,16 +74,13 @@ func MakeFirebaseManager(ctx *context.Context, r *http.Request) (*FirebaseManage
 		return nil, fmt.Errorf("error creating firestore client: %w", err)
 	}
 	userDoc := firestoreClient.Collection("users").Doc(userToken.UID)
-	logbookDoc := firestoreClient.Collection("logbooks").Doc(logbookID)
-	contactsCol := logbookDoc.Collection("contacts")
 	return &FirebaseManager{
+		NewFirestoreQsoStore(*ctx, firestoreClient, logbookID),
 		ctx,
 		userToken,
 		logbookID,
 		firestoreClient,
 		userDoc,
-		logbookDoc,
-		contactsCol,
 	}, nil
 }
 
@@ -132,107 +123,140 @@ func (f *FirebaseManager) GetUID() string {
 }
 
 func (f *FirebaseManager) GetUserProperty(key string) (string, error) {
-	return f.getDocProperty(f.userDoc, key)
+	return getDocProperty(*f.ctx, f.userDoc, key)
 }
 
-func (f *FirebaseManager) GetLogbookProperty(key string) (string, error) {
-	return f.getDocProperty(f.logbookDoc, key)
+// MergeResult counts what MergeQsos did with the remote contacts. AmbiguousDropped and
+// FailedDropped count the entries an import job left out of Ambiguous and Failed; see
+// ImportJob.trimLists.
+type MergeResult struct {
+	Created          int              `firestore:"created"`
+	Modified         int              `firestore:"modified"`
+	NoDiff           int              `firestore:"noDiff"`
+	Ambiguous        []AmbiguousMatch `firestore:"ambiguous"`
+	Failed           []WriteFailure   `firestore:"failed"`
+	AmbiguousDropped int              `firestore:"ambiguousDropped"`
+	FailedDropped    int              `firestore:"failedDropped"`
 }
 
-func (f *FirebaseManager) getDocProperty(doc *firestore.DocumentRef, key string) (string, error) {
-	// This could be memoized, but I think the Firestore client does that anyway
-	docSnapshot, err := doc.Get(*f.ctx)
-	if err != nil {
-		return "", err
-	}
-	if !docSnapshot.Exists() {
-		return "", nil
-	}
-	return fmt.Sprint(docSnapshot.Data()[key]), nil
+// add combines the results of merging two batches.
+func (r *MergeResult) add(other MergeResult) {
+	r.Created += other.Created
+	r.Modified += other.Modified
+	r.NoDiff += other.NoDiff
+	r.Ambiguous = append(r.Ambiguous, other.Ambiguous...)
+	r.Failed = append(r.Failed, other.Failed...)
+	r.AmbiguousDropped += other.AmbiguousDropped
+	r.FailedDropped += other.FailedDropped
 }
 
-func (f *FirebaseManager) SetLogbookProperty(key string, value string) error {
-	_, err := f.logbookDoc.Update(*f.ctx, []firestore.Update{{Path: key, Value: value}})
-	return err
+// accounted is how many remote contacts were merged, created, or reported as ambiguous.
+func (r MergeResult) accounted() int {
+	return r.Created + r.Modified + r.NoDiff + len(r.Ambiguous) + r.AmbiguousDropped
 }
 
-func (f *FirebaseManager) GetContacts() ([]FirestoreQso, error) {
-	docItr := f.contactsCol.Documents(*f.ctx)
-	var retval = make([]FirestoreQso, 0, 100)
-	for i := 0; ; i++ {
-		qsoDoc, err := docItr.Next()
-		if errors.Is(err, iterator.Done) {
-			break
-		}
-		if err != nil {
-			return nil, err
-		}
+// failed is how many remote contacts couldn't be written.
+func (r MergeResult) failed() int {
+	return len(r.Failed) + r.FailedDropped
+}
 
-		firestoreQso, err := ParseFirestoreQso(qsoDoc)
-		if err != nil {
-			log.Printf("Skipping qso %d: unmarshaling error: %v", i, err)
-			continue
-		}
-		retval = append(retval, firestoreQso)
-	}
-	return retval, nil
+// addToReport adds the counts to an import report.
+func (r MergeResult) addToReport(report map[string]interface{}) {
+	report["created"] = r.Created
+	report["modified"] = r.Modified
+	report["noDiff"] = r.NoDiff
+	report["ambiguous"] = r.Ambiguous
+	report["failed"] = r.failed()
+	report["failures"] = r.Failed
 }
 
-// MergeQsos merges the remote ADIF contacts into the Firestore ones. It returns the counts of
-// QSOs created, modified, and with no difference.
-func (f *FirebaseManager) MergeQsos(
+// MergeQsos merges the remote ADIF contacts into the stored ones. Remote contacts which match
+// several stored contacts equally well are left alone and reported. The merged contacts' empty
+// details are filled in where they can be worked out; see enrichQso. Contacts merged from QRZ.com
+// are marked as synced with it, so the update isn't sent back. The writes are made together at
+// the end, and the ones which failed are reported.
+func MergeQsos(
+	store QsoStore,
+	source ImportSource,
 	firebaseQsos []FirestoreQso,
-	remoteAdi *adifpb.Adif) (int, int, int) {
-	var created = 0
-	var modified = 0
-	var noDiff = 0
-	m := map[string]FirestoreQso{}
-	for _, fsQso := range firebaseQsos {
-		hash := hashQso(fsQso.qsopb)
-		m[hash] = fsQso
+	remoteAdi *adifpb.Adif) MergeResult {
+	return newQsoMerger(store, source, firebaseQsos).merge(remoteAdi.Qsos)
+}
+
+// qsoMerger merges batches of remote contacts into the stored ones. Each stored contact is only
+// matched once, across all the batches.
+type qsoMerger struct {
+	store   QsoStore
+	source  ImportSource
+	policy  MergePolicy
+	matcher *qsoMatcher
+}
+
+func newQsoMerger(store QsoStore, source ImportSource, firebaseQsos []FirestoreQso) *qsoMerger {
+	policy, err := loadMergePolicy(store, source)
+	if err != nil {
+		log.Printf("Couldn't load merge policy, using the default: %v", err)
+		policy = defaultMergePolicy(source)
 	}
+	return &qsoMerger{store, source, policy, newQsoMatcher(firebaseQsos, loadMatchWindow(store))}
+}
+
+func (m *qsoMerger) merge(remoteQsos []*adifpb.Qso) MergeResult {
+	var result MergeResult
+	var creates []*adifpb.Qso
+	var updates []FirestoreQso
 
-	for _, remoteQso := range remoteAdi.Qsos {
-		hash := hashQso(remoteQso)
-		if _, ok := m[hash]; ok {
-			diff := mergeQso(m[hash].qsopb, remoteQso)
+	for _, remoteQso := range remoteQsos {
+		match, ambiguous, ok := m.matcher.match(remoteQso)
+		if ok {
+			diff := mergeQsoWithPolicy(match.qsopb, remoteQso, m.policy)
+			if enrichQso(match.qsopb) {
+				diff = true
+			}
 			if diff {
+				if m.source == SourceQrz {
+					seedQrzSyncedHash(match.qsopb)
+				}
 				log.Printf("Updating QSO with %v on %v",
 					remoteQso.ContactedStation.StationCall,
 					remoteQso.TimeOn.String())
-				err := f.Update(m[hash])
-				if err != nil {
-					continue
-				}
-				modified++
+				updates = append(updates, match)
 			} else {
 				log.Printf("No difference for QSO with %v on %v",
 					remoteQso.ContactedStation.StationCall,
 					remoteQso.TimeOn.String())
-				noDiff++
+				result.NoDiff++
 			}
+		} else if len(ambiguous) > 0 {
+			log.Printf("QSO with %v on %v matches %d contacts; not merging",
+				remoteQso.ContactedStation.StationCall,
+				remoteQso.TimeOn.String(),
+				len(ambiguous))
+			result.Ambiguous = append(result.Ambiguous, describeAmbiguous(remoteQso, ambiguous))
 		} else {
 			log.Printf("Creating QSO with %v on %v",
 				remoteQso.ContactedStation.StationCall,
 				remoteQso.TimeOn.String())
-			err := f.Create(remoteQso)
-			if err != nil {
-				continue
-			}
-			created++
+			enrichQso(remoteQso)
+			creates = append(creates, remoteQso)
 		}
 	}
-	return created, modified, noDiff
-}
 
-func hashQso(qsopb *adifpb.Qso) string {
-	timeOn := qsopb.TimeOn.AsTime()
-	// Some providers (QRZ.com) only have minute precision
-	timeOn = timeOn.Truncate(time.Minute)
-	payload := []byte(normalizeCall(qsopb.LoggingStation.StationCall) +
-		normalizeCall(qsopb.ContactedStation.StationCall) +
-		strconv.FormatInt(timeOn.Unix(), 10))
-	return fmt.Sprintf("%x", sha256.Sum256(payload))
+	log.Printf("Writing %d new and %d updated QSOs", len(creates), len(updates))
+	result.Failed = m.store.WriteAll(creates, updates)
+	updateIDs := make(map[string]bool, len(updates))
+	for _, qso := range updates {
+		updateIDs[qso.id] = true
+	}
+	failedUpdates := 0
+	for _, failure := range result.Failed {
+		if updateIDs[failure.ContactID] {
+			failedUpdates++
+		}
+	}
+	result.Created = len(creates) - (len(result.Failed) - failedUpdates)
+	result.Modified = len(updates) - failedUpdates
+	return result
 }
 
 func normalizeCall(call string) string {
@@ -243,42 +267,19 @@ func normalizeCall(call string) string {
 }
 
 // Given two QSO objects, replace missing values in `base` with those from `backfill`. Values
-// already present in `base` should be preserved.
+// already present in `base` should be preserved; this is the FillOnly merge policy for every group.
 func mergeQso(base *adifpb.Qso, backfill *adifpb.Qso) bool {
-	original := &adifpb.Qso{}
-	_ = copier.Copy(original, base)
-	cleanQsl(base)
-	cleanQsl(backfill)
-	_ = mergo.Merge(base, backfill)
-	return !proto.Equal(original, base)
+	return mergeQsoWithPolicy(base, backfill, MergePolicy{})
 }
 
-func (f *FirebaseManager) Create(qso *adifpb.Qso) error {
-	buf, err := qsoToJSON(qso)
-	if err != nil {
-		log.Printf("Problem unmarshaling for create: %v", err)
-		return err
-	}
-	_, err = f.contactsCol.NewDoc().Create(*f.ctx, buf)
-	if err != nil {
-		log.Printf("Problem creating: %v", err)
-		return err
-	}
-	return nil
-}
-
-func (f *FirebaseManager) Update(qso FirestoreQso) error {
-	buf, err := qsoToJSON(qso.qsopb)
-	if err != nil {
-		log.Printf("Problem unmarshaling for update: %v", err)
-		return err
-	}
-	_, err = qso.docref.Set(*f.ctx, buf)
-	if err != nil {
-		log.Printf("Problem updating: %v", err)
-		return err
-	}
-	return nil
+// enrichQso fills in details which can be worked out from the rest of the QSO: the contacted
+// station's DXCC entity from its callsign, and the stations' positions and the distance between
+// them from their grid squares. Values which are already there are kept. Returns whether anything
+// changed.
+func enrichQso(qso *adifpb.Qso) bool {
+	dxcc := fillFromDxcc(qso)
+	grid := fillFromGrid(qso)
+	return dxcc || grid
 }
 
 func qsoToJSON(qso *adifpb.Qso) (map[string]interface{}, error) {
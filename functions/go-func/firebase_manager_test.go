@@ -1,6 +1,7 @@
 package forester
 
 import (
+	"errors"
 	adifpb "github.com/k0swe/adif-json-protobuf/go"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/timestamppb"
@@ -295,3 +296,103 @@ func Test_mergeQso(t *testing.T) {
 		})
 	}
 }
+
+func Test_MergeQsos(t *testing.T) {
+	store := NewMemoryQsoStore()
+	_ = store.Create(&adifpb.Qso{
+		Band:             "20m",
+		TimeOn:           timestamppb.New(time.Date(2020, 10, 25, 20, 15, 0, 0, time.UTC)),
+		LoggingStation:   &adifpb.Station{StationCall: "K0SWE"},
+		ContactedStation: &adifpb.Station{StationCall: "N6DN"},
+	})
+	_ = store.Create(&adifpb.Qso{
+		Band:             "40m",
+		Mode:             "FT8",
+		TimeOn:           timestamppb.New(time.Date(2020, 4, 3, 3, 38, 0, 0, time.UTC)),
+		LoggingStation:   &adifpb.Station{StationCall: "K0SWE"},
+		ContactedStation: &adifpb.Station{StationCall: "K9IJ"},
+	})
+	remote := &adifpb.Adif{Qsos: []*adifpb.Qso{
+		{
+			Mode:             "FT8",
+			TimeOn:           timestamppb.New(time.Date(2020, 10, 25, 20, 15, 0, 0, time.UTC)),
+			LoggingStation:   &adifpb.Station{StationCall: "K0SWE"},
+			ContactedStation: &adifpb.Station{StationCall: "N6DN"},
+		},
+		{
+			Mode:             "FT8",
+			TimeOn:           timestamppb.New(time.Date(2020, 4, 3, 3, 38, 0, 0, time.UTC)),
+			LoggingStation:   &adifpb.Station{StationCall: "K0SWE"},
+			ContactedStation: &adifpb.Station{StationCall: "K9IJ"},
+		},
+		{
+			TimeOn:           timestamppb.New(time.Date(2019, 5, 20, 23, 30, 0, 0, time.UTC)),
+			LoggingStation:   &adifpb.Station{StationCall: "K0SWE"},
+			ContactedStation: &adifpb.Station{StationCall: "KE0RCW"},
+		},
+	}}
+
+	existing, err := store.GetContacts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := MergeQsos(store, SourceQrz, existing, remote)
+	if result.Created != 1 || result.Modified != 1 || result.NoDiff != 1 {
+		t.Errorf("MergeQsos() got = %d, %d, %d, want 1, 1, 1",
+			result.Created, result.Modified, result.NoDiff)
+	}
+
+	contacts, _ := store.GetContacts()
+	if len(contacts) != 3 {
+		t.Fatalf("store has %d contacts, want 3", len(contacts))
+	}
+	updated, _ := store.GetContact(contacts[0].id)
+	if updated.qsopb.Mode != "FT8" || updated.qsopb.Band != "20m" {
+		t.Errorf("merged QSO got = %v, want 20m FT8", updated.qsopb)
+	}
+}
+
+// failingQsoStore fails to create contacts with the given call.
+type failingQsoStore struct {
+	*MemoryQsoStore
+	failCall string
+}
+
+func (s failingQsoStore) Create(qso *adifpb.Qso) error {
+	if qso.ContactedStation.StationCall == s.failCall {
+		return errors.New("quota exceeded")
+	}
+	return s.MemoryQsoStore.Create(qso)
+}
+
+func (s failingQsoStore) WriteAll(creates []*adifpb.Qso, updates []FirestoreQso) []WriteFailure {
+	return writeEach(s, creates, updates)
+}
+
+func Test_MergeQsos_failures(t *testing.T) {
+	store := failingQsoStore{NewMemoryQsoStore(), "KE0RCW"}
+	timeOn := timestamppb.New(time.Date(2019, 5, 20, 23, 30, 0, 0, time.UTC))
+	remote := &adifpb.Adif{Qsos: []*adifpb.Qso{
+		{
+			TimeOn:           timeOn,
+			LoggingStation:   &adifpb.Station{StationCall: "K0SWE"},
+			ContactedStation: &adifpb.Station{StationCall: "KE0RCW"},
+		},
+		{
+			TimeOn:           timeOn,
+			LoggingStation:   &adifpb.Station{StationCall: "K0SWE"},
+			ContactedStation: &adifpb.Station{StationCall: "N6DN"},
+		},
+	}}
+
+	result := MergeQsos(store, SourceQrz, nil, remote)
+	if result.Created != 1 || len(result.Failed) != 1 {
+		t.Fatalf("MergeQsos() got = %d created, %d failed, want 1, 1", result.Created, len(result.Failed))
+	}
+	if result.Failed[0].Call != "KE0RCW" || result.Failed[0].Error != "quota exceeded" {
+		t.Errorf("MergeQsos() failure got = %v", result.Failed[0])
+	}
+	if result.accounted() == len(remote.Qsos) {
+		t.Errorf("MergeQsos() accounted got = %d, want less than %d", result.accounted(), len(remote.Qsos))
+	}
+}
@@ -0,0 +1,168 @@
+package forester
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+)
+
+// maxAdifUploadBytes is the most ADIF which ImportAdif accepts; Cloud Functions requests are
+// limited to 32 MB anyway.
+const maxAdifUploadBytes = 32 << 20
+
+// ImportAdif merges an uploaded ADIF file into Firestore, the same way contacts from QRZ.com and
+// LotW are merged. The file is the "file" part of a multipart form, and can be ADI, ADX or a
+// Cabrillo contest log. With the dryRun=true param,
+// nothing is written and the report lists what would change. Records which can't be parsed are
+// skipped and listed in the report; with the strict=true param, any such problem fails the whole
+// import instead, so a file can be validated. Called via GCP Cloud Functions.
+func ImportAdif(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if handleCorsOptions(w, r) {
+		return
+	}
+	log.Print("Starting ImportAdif")
+	fb, err := MakeFirebaseManager(&ctx, r)
+	if err != nil {
+		writeError(500, "Error", err, w)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxAdifUploadBytes)
+	file, err := adifUpload(r)
+	if err != nil {
+		writeError(400, "Expected an ADIF file upload", err, w)
+		return
+	}
+	log.Printf("Reading ADIF file %v", file.FileName())
+
+	fsContacts, err := fb.GetContacts()
+	if err != nil {
+		writeError(500, "Error fetching contacts from firestore", err, w)
+		return
+	}
+	var store QsoStore = fb
+	var dryRun *dryRunStore
+	if isDryRun(r) {
+		log.Print("Dry run; nothing will be written")
+		dryRun = newDryRunStore(fb, fsContacts)
+		store = dryRun
+	}
+	mode := parseLenient
+	if r.URL.Query().Get("strict") == "true" {
+		mode = parseStrict
+	}
+	report, err := importAdif(store, fsContacts, file, mode)
+	var parseErrs ParseErrors
+	if errors.As(err, &parseErrs) {
+		w.WriteHeader(400)
+		marshal, _ := json.Marshal(map[string]interface{}{"parseErrors": parseErrs})
+		_, _ = fmt.Fprint(w, string(marshal))
+		return
+	}
+	if err != nil {
+		writeError(400, "Failed parsing the ADIF file", err, w)
+		return
+	}
+	if dryRun != nil {
+		dryRun.addToReport(report)
+	}
+	log.Printf("report: %v", report)
+	marshal, _ := json.Marshal(report)
+	_, _ = fmt.Fprint(w, string(marshal))
+}
+
+// adifUpload finds the "file" part of the multipart form. It's read straight from the request,
+// rather than being buffered first like with FormFile.
+func adifUpload(r *http.Request) (*multipart.Part, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "file" {
+			return part, nil
+		}
+	}
+}
+
+// importAdif reads the ADIF, or Cabrillo, and merges it into the store; see importQsos. The
+// user-defined fields declared in an ADIF header are saved on the logbook, so they can be exported.
+func importAdif(store QsoStore, fsContacts []FirestoreQso, adif io.Reader, mode parseMode) (map[string]interface{}, error) {
+	decoder := newQsoDecoder(adif)
+	report, err := importQsos(store, fsContacts, decoder, mode)
+	if err != nil {
+		return nil, err
+	}
+	if adifDecoder, ok := decoder.(*adifDecoder); ok {
+		err = saveUserDefs(store, adifDecoder.UserDefs())
+		if err != nil {
+			return nil, fmt.Errorf("error saving user-defined fields: %w", err)
+		}
+	}
+	return report, nil
+}
+
+// importQsos merges the decoded QSOs into the store a chunk at a time, returning the import report.
+// A strict import holds the QSOs until the whole file is known to be good, so nothing is written if
+// it isn't.
+func importQsos(store QsoStore, fsContacts []FirestoreQso, decoder qsoDecoder, mode parseMode) (map[string]interface{}, error) {
+	count, result, err := mergeDecodedQsos(store, SourceAdif, fsContacts, decoder, mode)
+	if err != nil {
+		return nil, err
+	}
+	var report = map[string]interface{}{}
+	report["adif"] = count
+	report["firestore"] = len(fsContacts)
+	report["parseErrors"] = decoder.Errors()
+	result.addToReport(report)
+	return report, nil
+}
+
+// mergeDecodedQsos merges the decoded QSOs from the source into the store a chunk at a time, as
+// described on importQsos, returning how many were read and what was done with them.
+func mergeDecodedQsos(
+	store QsoStore,
+	source ImportSource,
+	fsContacts []FirestoreQso,
+	decoder qsoDecoder,
+	mode parseMode) (int, MergeResult, error) {
+	const isFixCase = true
+	merger := newQsoMerger(store, source, fsContacts)
+	var result MergeResult
+	var chunk []*adifpb.Qso
+	count := 0
+	for {
+		qso, err := decoder.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return count, result, err
+		}
+		if isFixCase {
+			fixCase(qso)
+		}
+		chunk = append(chunk, qso)
+		count++
+		if mode == parseLenient && len(chunk) == importChunkSize {
+			result.add(merger.merge(chunk))
+			chunk = nil
+		}
+	}
+	if mode == parseStrict && len(decoder.Errors()) > 0 {
+		return count, result, ParseErrors(decoder.Errors())
+	}
+	result.add(merger.merge(chunk))
+	return count, result, nil
+}
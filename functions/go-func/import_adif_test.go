@@ -0,0 +1,129 @@
+package forester
+
+import (
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_importAdif(t *testing.T) {
+	store := NewMemoryQsoStore()
+	_ = store.Create(&adifpb.Qso{
+		Band:             "20m",
+		Mode:             "FT8",
+		TimeOn:           timestamppb.New(time.Date(2020, 10, 25, 20, 15, 10, 0, time.UTC)),
+		LoggingStation:   &adifpb.Station{StationCall: "K0SWE"},
+		ContactedStation: &adifpb.Station{StationCall: "N6DN"},
+	})
+	adif := `Exported from WSJT-X<eoh>
+<call:4>n6dn<station_callsign:5>K0SWE<qso_date:8>20201025<time_on:4>2015<band:3>20m<mode:3>FT8<name:14>Paul M St John<eor>
+<call:4>K9IJ<station_callsign:5>K0SWE<qso_date:8>20200403<time_on:6>033800<band:3>40m<mode:3>FT8<eor>
+`
+	existing, _ := store.GetContacts()
+
+	report, err := importAdif(store, existing, strings.NewReader(adif), parseLenient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report["adif"] != 2 || report["created"] != 1 || report["modified"] != 1 || report["failed"] != 0 {
+		t.Errorf("importAdif() report got = %v", report)
+	}
+	contacts, _ := store.GetContacts()
+	merged, _ := store.GetContact(contacts[0].id)
+	if merged.qsopb.ContactedStation.OpName != "Paul M St John" {
+		t.Errorf("importAdif() merged QSO got = %v", merged.qsopb)
+	}
+}
+
+func Test_importAdif_badRecord(t *testing.T) {
+	adif := `<call:4>N6DN<qso_date:8>20201025<time_on:4>2015<eor>
+<call:4>K9IJ<qso_date:8>20200403<time_on:4>9999<eor>
+`
+	store := NewMemoryQsoStore()
+	_, err := importAdif(store, nil, strings.NewReader(adif), parseStrict)
+	if err == nil {
+		t.Errorf("importAdif() strict got nil error")
+	}
+	if contacts, _ := store.GetContacts(); len(contacts) != 0 {
+		t.Errorf("importAdif() strict wrote %d contacts, want 0", len(contacts))
+	}
+
+	report, err := importAdif(store, nil, strings.NewReader(adif), parseLenient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parseErrs := report["parseErrors"].([]RecordError)
+	if report["created"] != 1 || len(parseErrs) != 1 || parseErrs[0].Line != 2 || !parseErrs[0].Skipped {
+		t.Errorf("importAdif() lenient report got = %v", report)
+	}
+}
+
+func Test_importAdif_cabrillo(t *testing.T) {
+	store := NewMemoryQsoStore()
+	_ = store.Create(&adifpb.Qso{
+		Band:             "20m",
+		Mode:             "CW",
+		TimeOn:           timestamppb.New(time.Date(2020, 6, 27, 18, 5, 0, 0, time.UTC)),
+		LoggingStation:   &adifpb.Station{StationCall: "K0SWE"},
+		ContactedStation: &adifpb.Station{StationCall: "N6DN"},
+	})
+	cabrillo := `START-OF-LOG: 3.0
+CONTEST: ARRL-FD
+CALLSIGN: K0SWE
+QSO: 14025 CW 2020-06-27 1805 K0SWE         2A  CO  N6DN          1D  SV
+QSO:  7200 PH 2020-06-27 1900 K0SWE         2A  CO  K9IJ          3A  IL
+END-OF-LOG:
+`
+	existing, _ := store.GetContacts()
+
+	report, err := importAdif(store, existing, strings.NewReader(cabrillo), parseLenient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report["adif"] != 2 || report["created"] != 1 || report["modified"] != 1 {
+		t.Errorf("importAdif() report got = %v", report)
+	}
+	contacts, _ := store.GetContacts()
+	merged, _ := store.GetContact(contacts[0].id)
+	if merged.qsopb.Contest.GetArrlSection() != "SV" {
+		t.Errorf("importAdif() merged QSO got = %v", merged.qsopb)
+	}
+}
+
+func Test_importAdif_userDefs(t *testing.T) {
+	store := NewMemoryQsoStore()
+	for _, adif := range []string{
+		`<USERDEF1:19:E>SweaterSize,{S,M,L}<eoh>
+<call:4>N6DN<qso_date:8>20201025<time_on:4>2015<sweatersize:1>M<eor>
+`,
+		`<USERDEF1:8:N>ShoeSize<USERDEF2:19:E>SweaterSize,{S,M,L}<eoh>
+<call:4>K9IJ<qso_date:8>20201026<time_on:4>2015<shoesize:2>11<eor>
+`,
+	} {
+		_, err := importAdif(store, nil, strings.NewReader(adif), parseLenient)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	userDefs, err := loadUserDefs(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// SweaterSize keeps the number it was first saved with
+	if userDefs["SWEATERSIZE"].ID != 1 || userDefs["SHOESIZE"].ID != 2 || userDefs["SHOESIZE"].DataType != "N" {
+		t.Errorf("importAdif() user defs got = %+v", userDefs)
+	}
+
+	var b strings.Builder
+	_, err = exportAdif(&b, store, exportFilter{}, formatAdi, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"<USERDEF1:19:E>SweaterSize,{S,M,L}", "<USERDEF2:8:N>ShoeSize", "<SWEATERSIZE:1>M"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("exportAdif() got = %v, want %v", b.String(), want)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package forester
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+)
+
+// csvProfilesProperty is the logbook property holding the logbook's saved CSV profiles, as a JSON
+// object of profile name to CsvProfile.
+const csvProfilesProperty = "csvProfiles"
+
+// defaultCsvPreviewRows is how many QSOs a preview shows, unless it asks for a different number.
+const defaultCsvPreviewRows = 20
+
+// loadCsvProfiles reads the logbook's saved CSV profiles.
+func loadCsvProfiles(store QsoStore) (map[string]CsvProfile, error) {
+	profiles := map[string]CsvProfile{}
+	prop, err := store.GetLogbookProperty(csvProfilesProperty)
+	if err != nil {
+		return nil, err
+	}
+	if prop == "" || prop == "<nil>" {
+		return profiles, nil
+	}
+	err = json.Unmarshal([]byte(prop), &profiles)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse %v: %w", csvProfilesProperty, err)
+	}
+	return profiles, nil
+}
+
+// saveCsvProfile adds the profile to the logbook's saved CSV profiles, replacing any of the same
+// name.
+func saveCsvProfile(store QsoStore, name string, profile CsvProfile) error {
+	profiles, err := loadCsvProfiles(store)
+	if err != nil {
+		return err
+	}
+	profiles[name] = profile
+	marshal, err := json.Marshal(profiles)
+	if err != nil {
+		return err
+	}
+	return store.SetLogbookProperty(csvProfilesProperty, string(marshal))
+}
+
+// ImportCsv merges an uploaded CSV log into Firestore, the same way ADIF files are merged. The form
+// has a "file" part, which is the CSV, and it may have a "profile" part before it, which is a JSON
+// CsvProfile saying how to read it. With the profile=name param, the profile is saved under that
+// name if it's given, or the saved one is used if it isn't. With the preview=true param, nothing is
+// merged, and the first QSOs (20, or the previewRows param) are returned with any row errors, so
+// the profile can be checked. dryRun=true and strict=true work like they do for ImportAdif. A
+// preview or a dry run doesn't save the profile. Called via GCP Cloud Functions.
+func ImportCsv(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if handleCorsOptions(w, r) {
+		return
+	}
+	log.Print("Starting ImportCsv")
+	fb, err := MakeFirebaseManager(&ctx, r)
+	if err != nil {
+		writeError(500, "Error", err, w)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxAdifUploadBytes)
+	profile, file, err := csvUpload(r, fb)
+	if err != nil {
+		writeError(400, "Expected a CSV file upload with a profile", err, w)
+		return
+	}
+	decoder, err := newCsvDecoder(file, profile)
+	if err != nil {
+		writeError(400, "Bad CSV profile", err, w)
+		return
+	}
+	if r.URL.Query().Get("preview") == "true" {
+		rows := defaultCsvPreviewRows
+		if n, err := strconv.Atoi(r.URL.Query().Get("previewRows")); err == nil && n > 0 {
+			rows = n
+		}
+		preview, err := previewCsv(decoder, rows)
+		if err != nil {
+			writeError(400, "Failed parsing the CSV file", err, w)
+			return
+		}
+		marshal, _ := json.Marshal(preview)
+		_, _ = fmt.Fprint(w, string(marshal))
+		return
+	}
+
+	fsContacts, err := fb.GetContacts()
+	if err != nil {
+		writeError(500, "Error fetching contacts from firestore", err, w)
+		return
+	}
+	var store QsoStore = fb
+	var dryRun *dryRunStore
+	if isDryRun(r) {
+		log.Print("Dry run; nothing will be written")
+		dryRun = newDryRunStore(fb, fsContacts)
+		store = dryRun
+	}
+	mode := parseLenient
+	if r.URL.Query().Get("strict") == "true" {
+		mode = parseStrict
+	}
+	report, err := importQsos(store, fsContacts, decoder, mode)
+	var parseErrs ParseErrors
+	if errors.As(err, &parseErrs) {
+		w.WriteHeader(400)
+		marshal, _ := json.Marshal(map[string]interface{}{"parseErrors": parseErrs})
+		_, _ = fmt.Fprint(w, string(marshal))
+		return
+	}
+	if err != nil {
+		writeError(400, "Failed parsing the CSV file", err, w)
+		return
+	}
+	if dryRun != nil {
+		dryRun.addToReport(report)
+	}
+	log.Printf("report: %v", report)
+	marshal, _ := json.Marshal(report)
+	_, _ = fmt.Fprint(w, string(marshal))
+}
+
+// csvUpload finds the profile and the "file" part of the multipart form. Like adifUpload, the file
+// is read straight from the request.
+func csvUpload(r *http.Request, store QsoStore) (CsvProfile, *multipart.Part, error) {
+	var profile CsvProfile
+	hasProfile := false
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return profile, nil, err
+	}
+	save := r.URL.Query().Get("preview") != "true" && !isDryRun(r)
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return profile, nil, err
+		}
+		switch part.FormName() {
+		case "profile":
+			err = json.NewDecoder(part).Decode(&profile)
+			if err != nil {
+				return profile, nil, fmt.Errorf("couldn't parse the profile: %w", err)
+			}
+			hasProfile = true
+		case "file":
+			profile, err = resolveCsvProfile(store, r.URL.Query().Get("profile"), profile, hasProfile, save)
+			return profile, part, err
+		}
+	}
+}
+
+// resolveCsvProfile saves the uploaded profile under the name if save is true, or loads the saved
+// one if there wasn't one uploaded.
+func resolveCsvProfile(store QsoStore, name string, profile CsvProfile, hasProfile bool, save bool) (CsvProfile, error) {
+	if hasProfile {
+		if name == "" {
+			return profile, nil
+		}
+		err := profile.validate()
+		if err != nil || !save {
+			return profile, err
+		}
+		log.Printf("Saving CSV profile %v", name)
+		return profile, saveCsvProfile(store, name, profile)
+	}
+	if name == "" {
+		return profile, errors.New("need a profile part, or the name of a saved profile")
+	}
+	profiles, err := loadCsvProfiles(store)
+	if err != nil {
+		return profile, err
+	}
+	profile, ok := profiles[name]
+	if !ok {
+		return profile, fmt.Errorf("no saved CSV profile named %q", name)
+	}
+	return profile, nil
+}
+
+// previewCsv parses up to rows QSOs without merging them, returning them with the row errors found
+// along the way.
+func previewCsv(decoder *csvDecoder, rows int) (map[string]interface{}, error) {
+	var qsos []map[string]interface{}
+	for len(qsos) < rows {
+		qso, err := decoder.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		fixCase(qso)
+		qsoJSON, err := qsoToJSON(qso)
+		if err != nil {
+			return nil, err
+		}
+		qsos = append(qsos, qsoJSON)
+	}
+	var preview = map[string]interface{}{}
+	preview["rows"] = decoder.records
+	preview["qsos"] = qsos
+	preview["parseErrors"] = decoder.Errors()
+	return preview, nil
+}
@@ -0,0 +1,69 @@
+package forester
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_resolveCsvProfile(t *testing.T) {
+	store := NewMemoryQsoStore()
+	profile := CsvProfile{Columns: map[string]string{"Call": "call", "Date": "qso_date"}, FreqUnit: "kHz"}
+	if _, err := resolveCsvProfile(store, "paper", CsvProfile{}, false, true); err == nil {
+		t.Errorf("resolveCsvProfile() got nil error for a profile which isn't saved")
+	}
+	if _, err := resolveCsvProfile(store, "paper", profile, true, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resolveCsvProfile(store, "paper", CsvProfile{}, false, true); err == nil {
+		t.Errorf("resolveCsvProfile() saved a profile when it shouldn't")
+	}
+	if _, err := resolveCsvProfile(store, "paper", profile, true, true); err != nil {
+		t.Fatal(err)
+	}
+	got, err := resolveCsvProfile(store, "paper", CsvProfile{}, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := CsvProfile{
+		Columns:    map[string]string{"Call": "CALL", "Date": "QSO_DATE"},
+		DateFormat: "YYYY-MM-DD",
+		TimeFormat: "HH:mm",
+		Timezone:   "UTC",
+		FreqUnit:   "kHz",
+		Delimiter:  ",",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("resolveCsvProfile() got = %+v, want %+v", got, want)
+	}
+}
+
+func Test_previewCsv(t *testing.T) {
+	profile := CsvProfile{Columns: map[string]string{"Call": "CALL", "Date": "QSO_DATE", "Time": "TIME_ON"}}
+	csv := "Call,Date,Time\nN6DN,2020-10-25,20:15\nK9IJ,2020-10-25,25:00\nKE0RCW,2020-10-26,01:00\nW1AW,2020-10-26,02:00\n"
+	decoder, err := newCsvDecoder(strings.NewReader(csv), profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	preview, err := previewCsv(decoder, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qsos := preview["qsos"].([]map[string]interface{})
+	if len(qsos) != 2 || preview["rows"] != 3 || len(preview["parseErrors"].([]RecordError)) != 1 {
+		t.Errorf("previewCsv() got = %v", preview)
+	}
+	if got := qsos[1]["timeOn"]; got != "2020-10-26T01:00:00Z" {
+		t.Errorf("previewCsv() timeOn got = %v, want 2020-10-26T01:00:00Z", got)
+	}
+
+	store := NewMemoryQsoStore()
+	decoder, _ = newCsvDecoder(strings.NewReader(csv), profile)
+	report, err := importQsos(store, nil, decoder, parseLenient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report["created"] != 3 {
+		t.Errorf("importQsos() report got = %v", report)
+	}
+}
@@ -0,0 +1,174 @@
+package forester
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const eqslLastFetchedDate = "eqslLastFetchedDate"
+
+// eqslBaseURL is a var so tests can point it at a fake eQSL.cc server.
+var eqslBaseURL = "https://www.eqsl.cc/qslcard/"
+
+var eqslAdiLink = regexp.MustCompile(`(?i)href="([^"]+\.adi)"`)
+var eqslError = regexp.MustCompile(`(?i)error:\s*([^<]+)`)
+
+// ImportEqsl imports QSLs from the eQSL.cc inbox and merges them into Firestore. Called via GCP
+// Cloud Functions.
+func ImportEqsl(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if handleCorsOptions(w, r) {
+		return
+	}
+	log.Print("Starting ImportEqsl")
+	fb, err := MakeFirebaseManager(&ctx, r)
+	if err != nil {
+		writeError(500, "Error", err, w)
+		return
+	}
+	lastFetchedTime, err := fb.GetLogbookProperty(eqslLastFetchedDate)
+	if err != nil {
+		writeError(500, "Error fetching logbook properties from firestore", err, w)
+		return
+	}
+	if lastFetchedTime == "<nil>" {
+		lastFetchedTime = ""
+	}
+	log.Printf("Last fetched time was %v", lastFetchedTime)
+	eqslUser, eqslPass, err := getEqslCreds(ctx, fb.logbookID)
+	if err != nil {
+		writeError(500, "Error fetching eQSL creds", err, w)
+		return
+	}
+	eqslResponse, err := fetchEqslInbox(ctx, eqslUser, eqslPass, lastFetchedTime)
+	if err != nil {
+		writeError(500, "Error fetching eQSL data", err, w)
+		return
+	}
+	defer eqslResponse.Close()
+
+	fsContacts, err := fb.GetContacts()
+	if err != nil {
+		writeError(500, "Error fetching contacts from firestore", err, w)
+		return
+	}
+	decoder := newAdifDecoder(eqslResponse)
+	count, result, err := mergeDecodedQsos(fb, SourceEqsl, fsContacts, fixedDecoder{decoder, fixEqslQsl}, parseLenient)
+	if err != nil {
+		writeError(500, "Failed reading eQSL data", err, w)
+		return
+	}
+
+	if result.accounted() == count {
+		err = storeLastFetched(fb, eqslLastFetchedDate)
+		if err != nil {
+			writeError(500, "Failed storing last fetched date", err, w)
+			return
+		}
+	} else {
+		log.Printf("Some QSOs failed to merge; not advancing %v", eqslLastFetchedDate)
+	}
+	var report = map[string]interface{}{}
+	report["eqsl"] = count
+	report["firestore"] = len(fsContacts)
+	report["parseErrors"] = decoder.Errors()
+	result.addToReport(report)
+	log.Printf("report: %v", report)
+	marshal, _ := json.Marshal(report)
+	_, _ = fmt.Fprint(w, string(marshal))
+}
+
+// fetchEqslInbox opens the eQSL.cc inbox as ADIF. eQSL.cc responds with an HTML page linking to a
+// generated .adi file, which is then downloaded as it's read. receivedSince (YYYY-MM-DD) is
+// optional.
+func fetchEqslInbox(ctx context.Context, user string, pass string, receivedSince string) (io.ReadCloser, error) {
+	inboxURL, err := url.Parse(eqslBaseURL + "DownloadInBox.cfm")
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{}
+	query.Set("UserName", user)
+	query.Set("Password", pass)
+	if receivedSince != "" {
+		query.Set("RcvdSince", strings.ReplaceAll(receivedSince, "-", "")+"0000")
+	}
+	inboxURL.RawQuery = query.Encode()
+	pageBody, err := eqslGet(ctx, inboxURL.String())
+	if err != nil {
+		return nil, err
+	}
+	defer pageBody.Close()
+	pageBytes, err := io.ReadAll(pageBody)
+	if err != nil {
+		return nil, err
+	}
+	page := string(pageBytes)
+	if strings.Contains(page, "You have no log entries") {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	link := eqslAdiLink.FindStringSubmatch(page)
+	if link == nil {
+		if e := eqslError.FindStringSubmatch(page); e != nil {
+			return nil, errors.New(strings.TrimSpace(e[1]))
+		}
+		return nil, errors.New("couldn't find ADIF link in eQSL.cc response")
+	}
+	adiURL, err := inboxURL.Parse(link[1])
+	if err != nil {
+		return nil, err
+	}
+	return eqslGet(ctx, adiURL.String())
+}
+
+func eqslGet(ctx context.Context, getURL string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, getURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("eQSL.cc responded %v", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+func fixEqslQsl(qso *adifpb.Qso) {
+	// The eQSL inbox describes the card the other station sent us, in the ADIF fields where our
+	// own card status would go
+	eqsl := &adifpb.Qsl{
+		ReceivedStatus: "Y",
+	}
+	if qso.Card != nil {
+		eqsl.ReceivedDate = qso.Card.ReceivedDate
+		eqsl.ReceivedMessage = qso.Card.ReceivedMessage
+	}
+	qso.Eqsl = eqsl
+	qso.Card = nil
+}
+
+func getEqslCreds(ctx context.Context, logbookID string) (string, string, error) {
+	secretStore := NewSecretStore(ctx)
+	username, err := secretStore.FetchSecret(logbookID, eqslUsername)
+	if err != nil {
+		return "", "", err
+	}
+	password, err := secretStore.FetchSecret(logbookID, eqslPassword)
+	if err != nil {
+		return "", "", err
+	}
+	return username, password, nil
+}
@@ -0,0 +1,89 @@
+package forester
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_fetchEqslInbox(t *testing.T) {
+	const adi = `<PROGRAMID:21>eQSL.cc DownloadInBox<EOH>
+<CALL:4>KK9A<QSO_DATE:8>20200329<TIME_ON:4>0034<BAND:3>20M<MODE:3>SSB
+<QSL_SENT:1>Y<QSL_SENT_VIA:1>E<QSLMSG:6>Thanks<APP_EQSL_AG:1>Y<EOR>
+`
+	mux := http.NewServeMux()
+	mux.HandleFunc("/qslcard/DownloadInBox.cfm", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case q.Get("UserName") != "K0SWE" || q.Get("Password") != "hunter2":
+			_, _ = fmt.Fprint(w, "<HTML>Error: No such Username/Password found</HTML>")
+		case q.Get("RcvdSince") == "202101010000":
+			_, _ = fmt.Fprint(w, "<HTML>You have no log entries</HTML>")
+		default:
+			_, _ = fmt.Fprint(w, `<HTML><LI><A HREF="../downloadedfiles/abc.adi">.ADI file</A></HTML>`)
+		}
+	})
+	mux.HandleFunc("/downloadedfiles/abc.adi", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, adi)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	originalURL := eqslBaseURL
+	eqslBaseURL = server.URL + "/qslcard/"
+	defer func() { eqslBaseURL = originalURL }()
+
+	tests := []struct {
+		name    string
+		user    string
+		since   string
+		want    string
+		wantErr bool
+	}{
+		{name: "inbox", user: "K0SWE", want: adi},
+		{name: "since", user: "K0SWE", since: "2020-01-01", want: adi},
+		{name: "empty", user: "K0SWE", since: "2021-01-01", want: ""},
+		{name: "bad creds", user: "N0CALL", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := fetchEqslInbox(context.Background(), tt.user, "hunter2", tt.since)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("fetchEqslInbox() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			defer body.Close()
+			got, _ := io.ReadAll(body)
+			if string(got) != tt.want {
+				t.Errorf("fetchEqslInbox() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_fixEqslQsl(t *testing.T) {
+	const adi = `<EOH>
+<CALL:4>KK9A<QSO_DATE:8>20200329<TIME_ON:4>0034<QSL_SENT:1>Y<QSL_SENT_VIA:1>E<QSLMSG:6>Thanks<APP_EQSL_AG:1>Y<EOR>
+`
+	got, _, err := adifToProto(adi, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	qso := got.Qsos[0]
+	fixEqslQsl(qso)
+	if qso.Card != nil {
+		t.Errorf("fixEqslQsl() card got = %v, want nil", qso.Card)
+	}
+	if qso.Eqsl == nil || qso.Eqsl.ReceivedStatus != "Y" || qso.Eqsl.ReceivedMessage != "Thanks" {
+		t.Errorf("fixEqslQsl() eqsl got = %v", qso.Eqsl)
+	}
+	if qso.AppDefined["app_eqsl_ag"] != "Y" {
+		t.Errorf("fixEqslQsl() AG got = %v, want Y", qso.AppDefined["app_eqsl_ag"])
+	}
+}
@@ -0,0 +1,389 @@
+package forester
+
+import (
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/pubsub"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// importJobTopic is the Pub/Sub topic which queued import jobs are published to.
+const importJobTopic = "import-requested"
+
+// importChunkSize is how many remote contacts are merged between progress updates.
+const importChunkSize = 500
+
+// maxImportAttempts is how many times a job is run before it's given up on, so a job which keeps
+// timing out isn't retried by Pub/Sub for days.
+const maxImportAttempts = 5
+
+// jobListLimit is how many parse errors, dry run diffs, ambiguous matches and write failures an
+// import job keeps, so its document stays under Firestore's 1 MiB limit. The rest are only counted.
+const jobListLimit = 100
+
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+
+	phaseQueued   = "queued"
+	phaseFetching = "fetching"
+	phaseMerging  = "merging"
+	phaseDone     = "done"
+)
+
+// ImportJob tracks an import from a remote logbook. It's stored under the logbook in the
+// importJobs collection, so clients can watch its progress. Errors holds problems which stopped the
+// job; ParseErrors holds problems with the fetched records, which were left out or imported without
+// their bad fields; contacts which couldn't be written are in Result. Long lists are cut at jobListLimit, and
+// the Dropped fields count what was left out.
+type ImportJob struct {
+	Source    ImportSource `firestore:"source"`
+	Status    string       `firestore:"status"`
+	Phase     string       `firestore:"phase"`
+	Processed int          `firestore:"processed"`
+	Total     int          `firestore:"total"`
+	Errors    []string     `firestore:"errors"`
+	// ParseErrorsDropped counts the parse errors left out of ParseErrors
+	ParseErrors        []string `firestore:"parseErrors"`
+	ParseErrorsDropped int      `firestore:"parseErrorsDropped"`
+	FullResync         bool     `firestore:"fullResync"`
+	DryRun             bool     `firestore:"dryRun"`
+	Scheduled          bool     `firestore:"scheduled"`
+	Attempts           int      `firestore:"attempts"`
+	// FetchStart is when the first attempt fetched from the remote, and ModifiedSince is the date
+	// it fetched changes since. Later attempts use the same ones.
+	FetchStart    time.Time   `firestore:"fetchStart"`
+	ModifiedSince string      `firestore:"modifiedSince"`
+	Result        MergeResult `firestore:"result"`
+	WouldCreate   []QsoDiff   `firestore:"wouldCreate"`
+	WouldModify   []QsoDiff   `firestore:"wouldModify"`
+	// WouldCreateDropped and WouldModifyDropped count the diffs left out of the lists
+	WouldCreateDropped int       `firestore:"wouldCreateDropped"`
+	WouldModifyDropped int       `firestore:"wouldModifyDropped"`
+	CreatedAt          time.Time `firestore:"createdAt"`
+	UpdatedAt          time.Time `firestore:"updatedAt"`
+}
+
+// trimLists cuts the job's lists to jobListLimit, counting what's dropped.
+func (j *ImportJob) trimLists() {
+	j.ParseErrors = trimList(j.ParseErrors, &j.ParseErrorsDropped)
+	j.WouldCreate = trimList(j.WouldCreate, &j.WouldCreateDropped)
+	j.WouldModify = trimList(j.WouldModify, &j.WouldModifyDropped)
+	j.Result.Ambiguous = trimList(j.Result.Ambiguous, &j.Result.AmbiguousDropped)
+	j.Result.Failed = trimList(j.Result.Failed, &j.Result.FailedDropped)
+}
+
+func trimList[T any](list []T, dropped *int) []T {
+	if len(list) <= jobListLimit {
+		return list
+	}
+	*dropped += len(list) - jobListLimit
+	return list[:jobListLimit]
+}
+
+// importSpec describes how to fetch from a remote logbook. credentialKey is a secret which must
+// be set to import. fetch opens the ADIF of only the records changed since the given date
+// (YYYY-MM-DD), or all of them if it's "", to be read as it arrives, along with how many records
+// the remote says there are, or 0 if it doesn't say. fix, if there is one, corrects the remote's
+// quirks in each record.
+type importSpec struct {
+	lastFetchedKey string
+	credentialKey  string
+	fetch          func(ctx context.Context, logbookID string, since string) (io.ReadCloser, int, error)
+	fix            func(qso *adifpb.Qso)
+}
+
+// importSpecs is a var so tests can fake the remote logbooks.
+var importSpecs = map[ImportSource]importSpec{
+	SourceQrz:  {qrzLastFetchedDate, qrzLogbookAPIKey, fetchQrzAdif, nil},
+	SourceLotw: {lotwLastFetchedDate, lotwPassword, fetchLotwAdif, fixLotwQsl},
+}
+
+// publishImportJob queues a job for RunImportJob. It's a var so tests can fake Pub/Sub.
+var publishImportJob = func(ctx context.Context, logbookID string, jobID string) error {
+	client, err := pubsub.NewClient(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	data, err := json.Marshal(map[string]string{"logbookId": logbookID, "jobId": jobID})
+	if err != nil {
+		return err
+	}
+	_, err = client.Topic(importJobTopic).Publish(ctx, &pubsub.Message{Data: data}).Get(ctx)
+	return err
+}
+
+// startImportJob handles an HTTP request to import from the given source. It queues an import job
+// and responds with its ID right away; the job is run by RunImportJob.
+func startImportJob(w http.ResponseWriter, r *http.Request, source ImportSource) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if handleCorsOptions(w, r) {
+		return
+	}
+	log.Printf("Starting %v import", source)
+	fb, err := MakeFirebaseManager(&ctx, r)
+	if err != nil {
+		writeError(500, "Error", err, w)
+		return
+	}
+	jobID, err := queueImportJob(ctx, fb, fb.logbookID, &ImportJob{
+		Source:     source,
+		FullResync: r.URL.Query().Get("fullResync") == "true",
+		DryRun:     isDryRun(r),
+	})
+	if err != nil {
+		writeError(500, "Error queueing import job", err, w)
+		return
+	}
+	log.Printf("Queued import job %v", jobID)
+	marshal, _ := json.Marshal(map[string]string{"jobId": jobID})
+	_, _ = fmt.Fprint(w, string(marshal))
+}
+
+// queueImportJob stores the new job and publishes it for RunImportJob.
+func queueImportJob(ctx context.Context, store QsoStore, logbookID string, job *ImportJob) (string, error) {
+	now := time.Now()
+	job.Status = jobQueued
+	job.Phase = phaseQueued
+	job.CreatedAt = now
+	job.UpdatedAt = now
+	jobID, err := store.CreateImportJob(job)
+	if err != nil {
+		return "", err
+	}
+	return jobID, publishImportJob(ctx, logbookID, jobID)
+}
+
+// RunImportJob listens to Pub/Sub for queued import jobs and runs them. It's deployed with retries,
+// so if it's interrupted or returns an error, Pub/Sub redelivers the message and the job carries on
+// after the contacts it already processed, up to maxImportAttempts.
+func RunImportJob(ctx context.Context, m pubsub.Message) error {
+	var psMap map[string]string
+	err := json.Unmarshal(m.Data, &psMap)
+	if err != nil {
+		return err
+	}
+	logbookID := psMap["logbookId"]
+	jobID := psMap["jobId"]
+	log.Printf("Got import job %v for logbook %v", jobID, logbookID)
+
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	store := NewFirestoreQsoStore(ctx, client, logbookID)
+	return runImportJob(ctx, store, logbookID, jobID)
+}
+
+// runImportJob runs the job to completion. Problems with the remote logbook or saving the job fail
+// it without returning an error, since retrying won't help; problems reading the logbook are
+// returned so it's retried.
+func runImportJob(ctx context.Context, store QsoStore, logbookID string, jobID string) error {
+	job, err := store.GetImportJob(jobID)
+	if err != nil {
+		return err
+	}
+	if job.Status == jobDone || job.Status == jobFailed {
+		log.Printf("Import job %v is already %v", jobID, job.Status)
+		return nil
+	}
+	spec, ok := importSpecs[job.Source]
+	if !ok {
+		return failImportJob(store, jobID, job, fmt.Errorf("unknown import source %q", job.Source))
+	}
+	if job.Attempts >= maxImportAttempts {
+		return failImportJob(store, jobID, job, fmt.Errorf("gave up after %d attempts", job.Attempts))
+	}
+	save := func() error {
+		job.UpdatedAt = time.Now()
+		job.trimLists()
+		err := store.SaveImportJob(jobID, job)
+		if err != nil {
+			return fmt.Errorf("error saving import job: %w", err)
+		}
+		return nil
+	}
+
+	job.Status = jobRunning
+	job.Phase = phaseFetching
+	job.Attempts++
+	if job.FetchStart.IsZero() {
+		since, err := store.GetLogbookProperty(spec.lastFetchedKey)
+		if err != nil {
+			return err
+		}
+		if since == "<nil>" || job.FullResync {
+			since = ""
+		}
+		job.ModifiedSince = since
+		job.FetchStart = time.Now()
+	}
+	err = save()
+	if err != nil {
+		return failImportJob(store, jobID, job, err)
+	}
+
+	merged, err := store.GetImportJobKeys(jobID)
+	if err != nil {
+		return err
+	}
+	fsContacts, err := store.GetContacts()
+	if err != nil {
+		return err
+	}
+	var target QsoStore = store
+	var dryRun *dryRunStore
+	if job.DryRun {
+		dryRun = newDryRunStore(store, fsContacts)
+		target = dryRun
+	}
+
+	log.Printf("Fetching %v changes since %q", job.Source, job.ModifiedSince)
+	body, count, err := spec.fetch(ctx, logbookID, job.ModifiedSince)
+	if err != nil {
+		return failImportJob(store, jobID, job, err)
+	}
+	defer body.Close()
+	var decoder qsoDecoder = newAdifDecoder(body)
+	if spec.fix != nil {
+		decoder = fixedDecoder{decoder, spec.fix}
+	}
+
+	// The records are merged as they're read. Total is the remote's count if it gave one, or else
+	// grows with Processed; either way it ends as how many were read. Each attempt reads them
+	// again, and finds the same problems again.
+	job.Phase = phaseMerging
+	job.Total, job.Processed = count, 0
+	job.ParseErrors, job.ParseErrorsDropped = nil, 0
+	err = save()
+	if err != nil {
+		return failImportJob(store, jobID, job, err)
+	}
+	merger := newQsoMerger(target, job.Source, fsContacts)
+	read := 0
+	var chunk []*adifpb.Qso
+	var chunkKeys []string
+	mergeChunk := func() error {
+		job.Result.add(merger.merge(chunk))
+		if dryRun != nil {
+			job.WouldCreate = append(job.WouldCreate, dryRun.wouldCreate...)
+			job.WouldModify = append(job.WouldModify, dryRun.wouldModify...)
+			dryRun.wouldCreate, dryRun.wouldModify = nil, nil
+		}
+		if len(chunkKeys) > 0 {
+			err := store.AddImportJobKeys(jobID, chunkKeys)
+			if err != nil {
+				return fmt.Errorf("error saving import job: %w", err)
+			}
+		}
+		job.Processed += len(chunk)
+		chunk, chunkKeys = nil, nil
+		job.ParseErrors, job.ParseErrorsDropped = nil, 0
+		for _, recordErr := range decoder.Errors() {
+			job.ParseErrors = append(job.ParseErrors, recordErr.String())
+		}
+		err := save()
+		if err != nil {
+			return err
+		}
+		log.Printf("Import job %v processed %d of %d so far", jobID, job.Processed, job.Total)
+		return nil
+	}
+	for {
+		qso, err := decoder.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return failImportJob(store, jobID, job, fmt.Errorf("error reading %v data: %w", job.Source, err))
+		}
+		fixCase(qso)
+		read++
+		job.Total = max(job.Total, read)
+		// Skip what an earlier attempt already merged. The remote may have changed since then, so
+		// they're found by key rather than by position.
+		key := importKey(qso)
+		if merged[key] {
+			job.Processed++
+			continue
+		}
+		chunk = append(chunk, qso)
+		chunkKeys = append(chunkKeys, key)
+		if len(chunk) == importChunkSize {
+			err = mergeChunk()
+			if err != nil {
+				return failImportJob(store, jobID, job, err)
+			}
+		}
+	}
+	job.Total = read
+	err = mergeChunk()
+	if err != nil {
+		return failImportJob(store, jobID, job, err)
+	}
+
+	if job.DryRun {
+		log.Print("Dry run; not advancing the last fetched date")
+	} else if job.Result.failed() == 0 {
+		err = store.SetLogbookProperty(spec.lastFetchedKey, job.FetchStart.UTC().Format("2006-01-02"))
+		if err != nil {
+			return err
+		}
+	} else {
+		log.Printf("Some QSOs failed to merge; not advancing %v", spec.lastFetchedKey)
+	}
+	job.Status = jobDone
+	job.Phase = phaseDone
+	// Record the outcome first; once the job is saved as done, a redelivery won't get to it
+	err = recordAutoSyncOutcome(store, jobID, job)
+	if err != nil {
+		return err
+	}
+	err = save()
+	if err != nil {
+		return failImportJob(store, jobID, job, err)
+	}
+	return nil
+}
+
+// importKey identifies a remote contact across fetches: by its QRZ.com logid if it has one, or
+// else by its stations, start time, band and mode.
+func importKey(qso *adifpb.Qso) string {
+	if logID := qso.GetAppDefined()[qrzLogIDKey]; logID != "" {
+		return "qrz:" + logID
+	}
+	return strings.Join([]string{
+		qso.GetLoggingStation().GetStationCall(),
+		qso.GetContactedStation().GetStationCall(),
+		strconv.FormatInt(qso.GetTimeOn().GetSeconds(), 10),
+		qso.GetBand(),
+		qso.GetMode(),
+	}, "|")
+}
+
+func failImportJob(store QsoStore, jobID string, job *ImportJob, err error) error {
+	log.Printf("Import job %v failed: %v", jobID, err)
+	job.Status = jobFailed
+	job.Errors = append(job.Errors, err.Error())
+	job.UpdatedAt = time.Now()
+	job.trimLists()
+	err = recordAutoSyncOutcome(store, jobID, job)
+	if err != nil {
+		return err
+	}
+	return store.SaveImportJob(jobID, job)
+}
@@ -0,0 +1,256 @@
+package forester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func fakeImportSource(t *testing.T, remote []*adifpb.Qso, fetchErr error) *string {
+	adi, err := protoToAdif(&adifpb.Adif{Qsos: remote})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fakeImportAdif(t, adi, 0, fetchErr)
+}
+
+func fakeImportAdif(t *testing.T, adi string, count int, fetchErr error) *string {
+	var gotSince string
+	oldSpecs, oldPublish := importSpecs, publishImportJob
+	importSpecs = map[ImportSource]importSpec{
+		SourceQrz: {qrzLastFetchedDate, qrzLogbookAPIKey, func(_ context.Context, _ string, since string) (io.ReadCloser, int, error) {
+			gotSince = since
+			if fetchErr != nil {
+				return nil, 0, fetchErr
+			}
+			return io.NopCloser(strings.NewReader(adi)), count, nil
+		}, nil},
+	}
+	publishImportJob = func(_ context.Context, _ string, _ string) error { return nil }
+	t.Cleanup(func() { importSpecs, publishImportJob = oldSpecs, oldPublish })
+	return &gotSince
+}
+
+func remoteContacts(calls ...string) []*adifpb.Qso {
+	var qsos []*adifpb.Qso
+	for i, call := range calls {
+		qsos = append(qsos, &adifpb.Qso{
+			Band:             "20m",
+			TimeOn:           timestamppb.New(time.Date(2020, 10, 25, 20, i, 0, 0, time.UTC)),
+			LoggingStation:   &adifpb.Station{StationCall: "K0SWE"},
+			ContactedStation: &adifpb.Station{StationCall: call},
+		})
+	}
+	return qsos
+}
+
+func Test_runImportJob(t *testing.T) {
+	gotSince := fakeImportSource(t, remoteContacts("N6DN", "K9IJ"), nil)
+	store := NewMemoryQsoStore()
+	_ = store.SetLogbookProperty(qrzLastFetchedDate, "2020-10-01")
+	jobID, err := queueImportJob(context.Background(), store, "K0SWE", &ImportJob{Source: SourceQrz})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = runImportJob(context.Background(), store, "K0SWE", jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, _ := store.GetImportJob(jobID)
+	if job.Status != jobDone || job.Processed != 2 || job.Total != 2 || job.Result.Created != 2 {
+		t.Errorf("runImportJob() job got = %+v", job)
+	}
+	if *gotSince != "2020-10-01" {
+		t.Errorf("runImportJob() fetched since %q, want 2020-10-01", *gotSince)
+	}
+	lastFetched, _ := store.GetLogbookProperty(qrzLastFetchedDate)
+	if lastFetched != job.FetchStart.UTC().Format("2006-01-02") {
+		t.Errorf("runImportJob() last fetched got = %v", lastFetched)
+	}
+
+	// A redelivered message doesn't run the job again
+	err = runImportJob(context.Background(), store, "K0SWE", jobID)
+	contacts, _ := store.GetContacts()
+	if err != nil || len(contacts) != 2 {
+		t.Errorf("runImportJob() again got = %v, %d contacts", err, len(contacts))
+	}
+}
+
+func Test_runImportJob_resume(t *testing.T) {
+	remote := remoteContacts("N6DN", "K9IJ", "KE0RCW")
+	fakeImportSource(t, remote, nil)
+	store := NewMemoryQsoStore()
+	// The first attempt merged one contact, then was interrupted. Since then, an earlier contact
+	// was added to the remote, so it's no longer first.
+	_ = store.Create(remote[1])
+	fetchStart := time.Date(2020, 11, 1, 0, 0, 0, 0, time.UTC)
+	jobID, _ := store.CreateImportJob(&ImportJob{
+		Source:     SourceQrz,
+		Status:     jobRunning,
+		Phase:      phaseMerging,
+		Attempts:   1,
+		Processed:  1,
+		Total:      3,
+		FetchStart: fetchStart,
+		Result:     MergeResult{Created: 1},
+	})
+	_ = store.AddImportJobKeys(jobID, []string{importKey(remote[1])})
+
+	err := runImportJob(context.Background(), store, "K0SWE", jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, _ := store.GetImportJob(jobID)
+	if job.Status != jobDone || job.Attempts != 2 || job.Result.Created != 3 || job.Result.NoDiff != 0 {
+		t.Errorf("runImportJob() job got = %+v", job)
+	}
+	if !job.FetchStart.Equal(fetchStart) {
+		t.Errorf("runImportJob() fetch start got = %v, want %v", job.FetchStart, fetchStart)
+	}
+	contacts, _ := store.GetContacts()
+	if len(contacts) != 3 {
+		t.Errorf("store has %d contacts, want 3", len(contacts))
+	}
+}
+
+func Test_runImportJob_fetchFails(t *testing.T) {
+	fakeImportSource(t, nil, errors.New("bad API key"))
+	store := NewMemoryQsoStore()
+	jobID, _ := queueImportJob(context.Background(), store, "K0SWE",
+		&ImportJob{Source: SourceQrz, FullResync: true})
+
+	err := runImportJob(context.Background(), store, "K0SWE", jobID)
+	if err != nil {
+		t.Fatalf("runImportJob() got = %v, want nil so it isn't retried", err)
+	}
+	job, _ := store.GetImportJob(jobID)
+	if job.Status != jobFailed || len(job.Errors) != 1 || job.Errors[0] != "bad API key" {
+		t.Errorf("runImportJob() job got = %+v", job)
+	}
+	if lastFetched, _ := store.GetLogbookProperty(qrzLastFetchedDate); lastFetched != "<nil>" {
+		t.Errorf("runImportJob() last fetched got = %v, want <nil>", lastFetched)
+	}
+}
+
+func Test_runImportJob_trimsLists(t *testing.T) {
+	calls := make([]string, jobListLimit+5)
+	for i := range calls {
+		calls[i] = fmt.Sprintf("K%dABC", i)
+	}
+	fakeImportSource(t, remoteContacts(calls...), nil)
+	store := NewMemoryQsoStore()
+	jobID, _ := queueImportJob(context.Background(), store, "K0SWE",
+		&ImportJob{Source: SourceQrz, DryRun: true})
+
+	err := runImportJob(context.Background(), store, "K0SWE", jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, _ := store.GetImportJob(jobID)
+	if len(job.WouldCreate) != jobListLimit || job.WouldCreateDropped != 5 {
+		t.Errorf("runImportJob() would create %d, dropped %d, want %d, 5",
+			len(job.WouldCreate), job.WouldCreateDropped, jobListLimit)
+	}
+}
+
+// unsaveableJobStore can't save a running import job, like when its document is too big.
+type unsaveableJobStore struct {
+	QsoStore
+}
+
+func (s unsaveableJobStore) SaveImportJob(id string, job *ImportJob) error {
+	if job.Status == jobRunning {
+		return errors.New("document too large")
+	}
+	return s.QsoStore.SaveImportJob(id, job)
+}
+
+func Test_runImportJob_saveFails(t *testing.T) {
+	fakeImportSource(t, remoteContacts("N6DN"), nil)
+	store := unsaveableJobStore{NewMemoryQsoStore()}
+	jobID, _ := queueImportJob(context.Background(), store, "K0SWE", &ImportJob{Source: SourceQrz})
+
+	err := runImportJob(context.Background(), store, "K0SWE", jobID)
+	if err != nil {
+		t.Fatalf("runImportJob() got = %v, want nil so it isn't retried", err)
+	}
+	job, _ := store.GetImportJob(jobID)
+	if job.Status != jobFailed || len(job.Errors) != 1 {
+		t.Errorf("runImportJob() job got = %+v", job)
+	}
+}
+
+func Test_runImportJob_parseErrors(t *testing.T) {
+	fakeImportAdif(t, `<EOH>
+<CALL:4>N6DN<QSO_DATE:8>20201025<TIME_ON:4>2000<EOR>
+<CALL:4>K9IJ<QSO_DATE:8>2020102<TIME_ON:4>2001<EOR>
+`, 0, nil)
+	store := NewMemoryQsoStore()
+	jobID, _ := queueImportJob(context.Background(), store, "K0SWE", &ImportJob{Source: SourceQrz})
+
+	err := runImportJob(context.Background(), store, "K0SWE", jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, _ := store.GetImportJob(jobID)
+	if job.Status != jobDone || job.Total != 1 || len(job.ParseErrors) != 1 {
+		t.Errorf("runImportJob() job got = %+v", job)
+	}
+}
+
+// progressStore records the progress of each save of a running import job.
+type progressStore struct {
+	QsoStore
+	progress []string
+}
+
+func (s *progressStore) SaveImportJob(id string, job *ImportJob) error {
+	if job.Phase == phaseMerging {
+		s.progress = append(s.progress, fmt.Sprintf("%d/%d", job.Processed, job.Total))
+	}
+	return s.QsoStore.SaveImportJob(id, job)
+}
+
+func Test_runImportJob_total(t *testing.T) {
+	remote := remoteContacts("N6DN", "K9IJ", "KE0RCW")
+	adi, _ := protoToAdif(&adifpb.Adif{Qsos: remote})
+	fakeImportAdif(t, adi, 3, nil)
+	store := &progressStore{QsoStore: NewMemoryQsoStore()}
+	jobID, _ := queueImportJob(context.Background(), store, "K0SWE", &ImportJob{Source: SourceQrz})
+
+	err := runImportJob(context.Background(), store, "K0SWE", jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"0/3", "3/3"}; !reflect.DeepEqual(store.progress, want) {
+		t.Errorf("runImportJob() progress got = %v, want %v", store.progress, want)
+	}
+}
+
+func Test_runImportJob_givesUp(t *testing.T) {
+	fakeImportSource(t, remoteContacts("N6DN"), nil)
+	store := NewMemoryQsoStore()
+	jobID, _ := store.CreateImportJob(&ImportJob{
+		Source:   SourceQrz,
+		Status:   jobRunning,
+		Phase:    phaseMerging,
+		Attempts: maxImportAttempts,
+	})
+
+	err := runImportJob(context.Background(), store, "K0SWE", jobID)
+	if err != nil {
+		t.Fatalf("runImportJob() got = %v, want nil so it isn't retried", err)
+	}
+	job, _ := store.GetImportJob(jobID)
+	if job.Status != jobFailed || len(job.Errors) != 1 {
+		t.Errorf("runImportJob() job got = %+v", job)
+	}
+}
@@ -1,106 +1,95 @@
 package forester
 
 import (
+	"bufio"
+	"bytes"
 	"context"
-	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/antihax/optional"
 	adifpb "github.com/k0swe/adif-json-protobuf/go"
-	"github.com/k0swe/lotw-qsl"
-	"log"
+	"io"
 	"net/http"
+	"net/url"
 	"time"
 )
 
 const lotwLastFetchedDate = "lotwLastFetchedDate"
 
-// ImportLotw imports QSLs from Logbook of the World and merges them into Firestore. Called via GCP
-// Cloud Functions.
+// ImportLotw queues an import of QSLs from Logbook of the World, and responds with the ID of the
+// import job tracking it. With the dryRun=true param, nothing is written and the job lists what
+// would change. Called via GCP Cloud Functions.
 func ImportLotw(w http.ResponseWriter, r *http.Request) {
-	const isFixCase = true
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	if handleCorsOptions(w, r) {
-		return
+	startImportJob(w, r, SourceLotw)
+}
+
+// lotwReportURL is a var so tests can point it at a fake LotW server.
+var lotwReportURL = "https://lotw.arrl.org/lotwuser/lotwreport.adi"
+
+// fetchLotwAdif opens the ADIF of the LotW QSLs received since the given date. LotW doesn't say
+// how many there are up front, so the count is 0.
+func fetchLotwAdif(ctx context.Context, logbookID string, qslSince string) (io.ReadCloser, int, error) {
+	if qslSince == "" {
+		qslSince = "1970-01-01"
 	}
-	log.Print("Starting ImportLotw")
-	fb, err := MakeFirebaseManager(&ctx, r)
+	lotwUser, lotwPass, err := getLOTWCreds(ctx, logbookID)
 	if err != nil {
-		writeError(500, "Error", err, w)
-		return
+		return nil, 0, fmt.Errorf("error fetching LotW creds: %w", err)
 	}
-	lastFetchedTime, err := fb.GetLogbookProperty(lotwLastFetchedDate)
+	body, err := openLotwReport(ctx, lotwUser, lotwPass, qslSince)
 	if err != nil {
-		writeError(500, "Error fetching logbook properties from firestore", err, w)
-		return
+		return nil, 0, fmt.Errorf("error fetching LotW data: %w", err)
 	}
-	if lastFetchedTime == "<nil>" {
-		lastFetchedTime = "1970-01-01"
-	}
-	log.Printf("Last fetched time was %v", lastFetchedTime)
-	lotwUser, lotwPass, err := getLOTWCreds(ctx, fb.logbookID)
+	return body, 0, nil
+}
+
+// openLotwReport starts a query for the QSLs received since the given date, with the logging
+// station's details, returning the ADIF to be read as it arrives.
+func openLotwReport(ctx context.Context, user string, pass string, qslSince string) (io.ReadCloser, error) {
+	reportURL, err := url.Parse(lotwReportURL)
 	if err != nil {
-		writeError(500, "Error fetching LotW creds", err, w)
-		return
+		return nil, err
 	}
-	lotwResponse, err := lotw.Query(lotwUser, lotwPass, &lotw.QueryOpts{
-		QsoQsl:      optional.NewInterface(lotw.YES),
-		QsoQslsince: optional.NewString(lastFetchedTime),
-		QsoMydetail: optional.NewInterface(lotw.YES),
-	})
+	reportURL.RawQuery = url.Values{
+		"login":        {user},
+		"password":     {pass},
+		"qso_query":    {"1"},
+		"qso_qsl":      {"yes"},
+		"qso_qslsince": {qslSince},
+		"qso_mydetail": {"yes"},
+	}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reportURL.String(), nil)
 	if err != nil {
-		writeError(500, "Error fetching LotW data", err, w)
-		return
+		return nil, err
 	}
-	log.Printf("Fetched LotW data, %d bytes", len(lotwResponse))
-	lotwAdi, err := adifToProto(lotwResponse, time.Now())
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		writeError(500, "Failed parsing LotW data", err, w)
-		log.Printf("LotW payload: %v", base64.StdEncoding.EncodeToString([]byte(lotwResponse)))
-		return
+		return nil, err
 	}
-	fixLOTWQsls(lotwAdi)
-	if isFixCase {
-		for _, qso := range lotwAdi.Qsos {
-			fixCase(qso)
-		}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("LotW responded %v", resp.Status)
 	}
-
-	fsContacts, err := fb.GetContacts()
-	if err != nil {
-		writeError(500, "Error fetching contacts from firestore", err, w)
-		return
-	}
-	created, modified, noDiff := fb.MergeQsos(fsContacts, lotwAdi)
-
-	err = storeLastFetched(fb)
-	if err != nil {
-		writeError(500, "Failed storing last fetched date", err, w)
-		return
+	br := bufio.NewReader(resp.Body)
+	// LotW answers a bad login with a web page instead of ADIF
+	if start, _ := br.Peek(512); bytes.Contains(bytes.ToLower(start), []byte("<html")) {
+		resp.Body.Close()
+		return nil, errors.New("LotW didn't accept the username and password")
 	}
-	var report = map[string]int{}
-	report["lotw"] = len(lotwAdi.Qsos)
-	report["firestore"] = len(fsContacts)
-	report["created"] = created
-	report["modified"] = modified
-	report["noDiff"] = noDiff
-	log.Printf("report: %v", report)
-	marshal, _ := json.Marshal(report)
-	_, _ = fmt.Fprint(w, string(marshal))
+	return struct {
+		io.Reader
+		io.Closer
+	}{br, resp.Body}, nil
 }
 
-func storeLastFetched(fb *FirebaseManager) error {
+func storeLastFetched(store QsoStore, key string) error {
 	today := time.Now().UTC().Format("2006-01-02")
-	return fb.SetLogbookProperty(lotwLastFetchedDate, today)
+	return store.SetLogbookProperty(key, today)
 }
 
-func fixLOTWQsls(lotwAdi *adifpb.Adif) {
+func fixLotwQsl(qso *adifpb.Qso) {
 	// LotW puts their QSL in the ADIF fields where cards should go
-	for _, qso := range lotwAdi.Qsos {
-		qso.Lotw = qso.Card
-		qso.Card = nil
-	}
+	qso.Lotw = qso.Card
+	qso.Card = nil
 }
 
 func getLOTWCreds(ctx context.Context, logbookID string) (string, string, error) {
@@ -0,0 +1,48 @@
+package forester
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_openLotwReport(t *testing.T) {
+	const adi = `ARRL Logbook of the World Status Report
+<PROGRAMID:4>LoTW
+<EOH>
+<CALL:4>KK9A<BAND:3>20M<QSL_RCVD:1>Y<EOR>
+`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("login") != "K0SWE" || q.Get("password") != "hunter2" {
+			_, _ = fmt.Fprint(w, "<!DOCTYPE html><html><body>Username/password incorrect</body></html>")
+			return
+		}
+		if q.Get("qso_qslsince") != "2020-01-01" || q.Get("qso_mydetail") != "yes" {
+			t.Errorf("openLotwReport() query got = %v", q)
+		}
+		_, _ = fmt.Fprint(w, adi)
+	}))
+	defer server.Close()
+	originalURL := lotwReportURL
+	lotwReportURL = server.URL
+	defer func() { lotwReportURL = originalURL }()
+
+	body, err := openLotwReport(context.Background(), "K0SWE", "hunter2", "2020-01-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(body)
+	_ = body.Close()
+	if string(got) != adi {
+		t.Errorf("openLotwReport() got = %v, want %v", string(got), adi)
+	}
+
+	_, err = openLotwReport(context.Background(), "K0SWE", "wrong", "2020-01-01")
+	if err == nil {
+		t.Errorf("openLotwReport() with a bad password got no error")
+	}
+}
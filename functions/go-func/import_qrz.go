@@ -1,76 +1,227 @@
 package forester
 
 import (
+	"bufio"
 	"context"
-	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
-	ql "github.com/k0swe/qrz-logbook"
+	"io"
 	"log"
 	"net/http"
-	"time"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
 )
 
 const qrzLastFetchedDate = "qrzLastFetchedDate"
 
-// ImportQrz imports QSOs from QRZ logbook and merges them into Firestore. Called via GCP Cloud
-// Functions.
+// ImportQrz queues an import of QSOs from the QRZ logbook, and responds with the ID of the import
+// job tracking it. Only records modified since the last successful import are fetched, unless the
+// fullResync=true param is given. With the dryRun=true param, nothing is written and the job lists
+// what would change. Called via GCP Cloud Functions.
 func ImportQrz(w http.ResponseWriter, r *http.Request) {
-	const isFixCase = true
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	if handleCorsOptions(w, r) {
-		return
-	}
-	log.Print("Starting ImportQrz")
-	fb, err := MakeFirebaseManager(&ctx, r)
+	startImportJob(w, r, SourceQrz)
+}
+
+// qrzAPIURL is a var so tests can point it at a fake QRZ.com server.
+var qrzAPIURL = "https://logbook.qrz.com/api"
+
+// fetchQrzAdif opens the ADIF of the QRZ logbook records modified since the given date, or of the
+// whole logbook if it's "", along with how many records QRZ.com says there are.
+func fetchQrzAdif(ctx context.Context, logbookID string, modifiedSince string) (io.ReadCloser, int, error) {
+	secretStore := NewSecretStore(ctx)
+	qrzAPIKey, err := secretStore.FetchSecret(logbookID, qrzLogbookAPIKey)
 	if err != nil {
-		writeError(500, "Error", err, w)
-		return
+		return nil, 0, fmt.Errorf("error fetching QRZ API key from secret manager: %w", err)
 	}
-	_, err = fb.GetLogbookProperty(qrzLastFetchedDate)
+	option := ""
+	if modifiedSince != "" {
+		option = "MODSINCE:" + modifiedSince
+	}
+	body, err := openQrzFetch(ctx, qrzAPIKey, option)
 	if err != nil {
-		writeError(500, "Error fetching logbook properties from firestore", err, w)
-		return
+		return nil, 0, fmt.Errorf("error fetching QRZ.com data: %w", err)
 	}
+	return body, body.resp.count, nil
+}
 
-	secretStore := NewSecretStore(ctx)
-	qrzAPIKey, err := secretStore.FetchSecret(fb.logbookID, qrzLogbookAPIKey)
+// qrzResponse is the fields of a QRZ.com API response other than its ADIF.
+type qrzResponse struct {
+	result string
+	reason string
+	count  int
+}
+
+// set records a field of the response, ignoring ones which aren't needed.
+func (r *qrzResponse) set(key string, value string) {
+	switch key {
+	case "RESULT":
+		r.result = value
+	case "REASON":
+		r.reason = value
+	case "COUNT":
+		r.count, _ = strconv.Atoi(value)
+	}
+}
+
+// openQrzFetch starts a FETCH from the QRZ logbook, returning its ADIF to be read as it arrives.
+// QRZ.com responds with fields like RESULT=OK&COUNT=2&ADIF=..., where the ADIF has its angle brackets
+// escaped. A RESULT other than OK is an error, whether it comes before the ADIF or after it; see
+// qrzFetchError.
+func openQrzFetch(ctx context.Context, qrzAPIKey string, option string) (*qrzAdifReader, error) {
+	form := url.Values{"KEY": {qrzAPIKey}, "ACTION": {"FETCH"}}
+	if option != "" {
+		form.Set("OPTION", option)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, qrzAPIURL, strings.NewReader(form.Encode()))
 	if err != nil {
-		writeError(500, "Error fetching QRZ API key from secret manager", err, w)
-		return
+		return nil, err
 	}
-	qrzResponse, err := ql.Fetch(ctx, &qrzAPIKey)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "forester-func")
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		writeError(500, "Error fetching QRZ.com data", err, w)
-		return
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("QRZ.com responded %v", resp.Status)
+	}
+	r := &qrzAdifReader{br: bufio.NewReader(resp.Body), body: resp.Body, option: option}
+	for {
+		key, err := r.br.ReadString('=')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		key = strings.TrimSpace(strings.TrimPrefix(strings.TrimSuffix(key, "="), "&"))
+		if key == "ADIF" {
+			if r.resp.result != "" && r.resp.result != "OK" {
+				break
+			}
+			log.Printf("Fetching %v QRZ.com records", r.resp.count)
+			return r, nil
+		}
+		value, err := r.br.ReadString('&')
+		if err != nil && !errors.Is(err, io.EOF) {
+			resp.Body.Close()
+			return nil, err
+		}
+		r.resp.set(key, strings.TrimSpace(strings.TrimSuffix(value, "&")))
+		if errors.Is(err, io.EOF) {
+			break
+		}
 	}
-	log.Printf("Fetched QRZ.com data, %d records", qrzResponse.Count)
-	qrzAdi, err := adifToProto(qrzResponse.Adif, time.Now())
+	resp.Body.Close()
+	// There's no ADIF to read, so it's either an empty result or a failure
+	err = qrzFetchError(r.resp, option)
 	if err != nil {
-		writeError(500, "Failed parsing QRZ.com data", err, w)
-		log.Printf("QRZ.com payload: %v", base64.StdEncoding.EncodeToString([]byte(qrzResponse.Adif)))
-		return
+		return nil, err
+	}
+	r.br = bufio.NewReader(strings.NewReader(""))
+	return r, nil
+}
+
+// qrzAdifReader reads the ADIF of a QRZ.com response, unescaping its angle brackets. The ADIF ends
+// at the end of the response or at the next field, like &RESULT=OK; fields after it are read then,
+// and a RESULT other than OK is returned as an error instead of io.EOF.
+type qrzAdifReader struct {
+	br     *bufio.Reader
+	body   io.Closer
+	option string
+	resp   qrzResponse
+	err    error
+}
+
+// qrzFieldStart matches the start of a response field after the ADIF.
+var qrzFieldStart = regexp.MustCompile(`^[A-Z_]+=`)
+
+func (r *qrzAdifReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
 	}
-	if isFixCase {
-		for _, qso := range qrzAdi.Qsos {
-			fixCase(qso)
+	n := 0
+	for n < len(p) {
+		c, err := r.br.ReadByte()
+		if err != nil {
+			r.err = err
+			if errors.Is(err, io.EOF) {
+				r.err = qrzFetchError(r.resp, r.option)
+				if r.err == nil {
+					r.err = io.EOF
+				}
+			}
+			return n, r.err
+		}
+		if c == '&' {
+			next, _ := r.br.Peek(32)
+			switch {
+			case strings.HasPrefix(string(next), "lt;"):
+				c = '<'
+				_, _ = r.br.Discard(3)
+			case strings.HasPrefix(string(next), "gt;"):
+				c = '>'
+				_, _ = r.br.Discard(3)
+			case qrzFieldStart.Match(next):
+				r.readTrailingFields()
+				continue
+			}
 		}
+		p[n] = c
+		n++
+		if r.br.Buffered() == 0 {
+			// Hand over what's here rather than waiting on the network for more
+			break
+		}
+	}
+	return n, nil
+}
+
+// readTrailingFields reads the fields after the ADIF, leaving the reader at the end.
+func (r *qrzAdifReader) readTrailingFields() {
+	rest, _ := io.ReadAll(r.br)
+	for _, field := range strings.Split(string(rest), "&") {
+		key, value, _ := strings.Cut(field, "=")
+		r.resp.set(strings.TrimSpace(key), strings.TrimSpace(value))
 	}
-	fsContacts, err := fb.GetContacts()
+}
+
+func (r *qrzAdifReader) Close() error {
+	return r.body.Close()
+}
+
+// qrzFetchAll fetches the QRZ logbook records matching a FETCH option such as "CALL:K0SWE", which
+// are few enough to read at once. It's a var so tests can fake QRZ.com.
+var qrzFetchAll = func(ctx context.Context, qrzAPIKey string, option string) (string, error) {
+	r, err := openQrzFetch(ctx, qrzAPIKey, option)
 	if err != nil {
-		writeError(500, "Error fetching contacts from firestore", err, w)
-		return
+		return "", err
+	}
+	defer r.Close()
+	adif, err := io.ReadAll(r)
+	return string(adif), err
+}
+
+// qrzNoRecordsReason is the REASON QRZ.com FAILs a FETCH with when no records match, which is
+// normal for a filtered fetch.
+const qrzNoRecordsReason = "no log entries found"
+
+// qrzFetchError says whether a FETCH failed. Only QRZ.com's no-records FAIL is an empty result; any
+// other FAIL or result, like a bad API key, is an error so nothing is lost.
+func qrzFetchError(resp qrzResponse, option string) error {
+	switch {
+	case resp.result == "OK":
+		return nil
+	case resp.result == "FAIL" && resp.count == 0 && strings.EqualFold(resp.reason, qrzNoRecordsReason):
+		log.Printf("No QRZ.com records match %v: %v", option, resp.reason)
+		return nil
+	case resp.result == "FAIL" && resp.reason != "":
+		return errors.New(resp.reason)
+	default:
+		return fmt.Errorf("QRZ.com responded %q to FETCH %v: %v", resp.result, option, resp.reason)
 	}
-	created, modified, noDiff := fb.MergeQsos(fsContacts, qrzAdi)
-
-	var report = map[string]int{}
-	report["qrz"] = len(qrzAdi.Qsos)
-	report["firestore"] = len(fsContacts)
-	report["created"] = created
-	report["modified"] = modified
-	report["noDiff"] = noDiff
-	log.Printf("report: %v", report)
-	marshal, _ := json.Marshal(report)
-	_, _ = fmt.Fprint(w, string(marshal))
 }
@@ -0,0 +1,92 @@
+package forester
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/iotest"
+)
+
+func Test_qrzFetchError(t *testing.T) {
+	tests := []struct {
+		name    string
+		resp    qrzResponse
+		wantErr bool
+	}{
+		{name: "records", resp: qrzResponse{result: "OK", count: 1}},
+		{name: "no records", resp: qrzResponse{result: "FAIL", reason: "no log entries found"}},
+		{name: "bad key", resp: qrzResponse{result: "FAIL", reason: "invalid api key"}, wantErr: true},
+		{name: "no privileges", resp: qrzResponse{result: "AUTH"}, wantErr: true},
+		{name: "no result", resp: qrzResponse{}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := qrzFetchError(tt.resp, "MODSINCE:2020-01-01")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("qrzFetchError() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_openQrzFetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.FormValue("KEY") != "ABCD-1234":
+			_, _ = fmt.Fprint(w, "RESULT=FAIL&REASON=invalid api key ABCD-0000&EXTENDED=")
+		case r.FormValue("OPTION") == "MODSINCE:2021-01-01":
+			_, _ = fmt.Fprint(w, "RESULT=FAIL&REASON=no log entries found&COUNT=0")
+		case r.FormValue("OPTION") == "CALL:W1AW":
+			_, _ = fmt.Fprint(w, "COUNT=1&ADIF=&lt;call:4&gt;W1AW&lt;eor&gt;&RESULT=OK")
+		case r.FormValue("OPTION") == "CALL:N6DN":
+			_, _ = fmt.Fprint(w, "ADIF=&lt;call:4&gt;N6DN&lt;eor&gt;&RESULT=FAIL&REASON=internal error")
+		case r.FormValue("OPTION") == "CALL:K9IJ":
+			_, _ = fmt.Fprint(w, "RESULT=AUTH&ADIF=&lt;call:4&gt;K9IJ&lt;eor&gt;")
+		default:
+			_, _ = fmt.Fprint(w, "RESULT=OK&COUNT=1&ADIF=&lt;call:5&gt;K0SWE&lt;comment:5&gt;A &amp; B&lt;eor&gt;\n")
+		}
+	}))
+	defer server.Close()
+	originalURL := qrzAPIURL
+	qrzAPIURL = server.URL
+	defer func() { qrzAPIURL = originalURL }()
+
+	tests := []struct {
+		name        string
+		key         string
+		option      string
+		want        string
+		wantErr     bool
+		wantReadErr bool
+	}{
+		{name: "records", key: "ABCD-1234", want: "<call:5>K0SWE<comment:5>A &amp; B<eor>\n"},
+		{name: "no records", key: "ABCD-1234", option: "MODSINCE:2021-01-01", want: ""},
+		{name: "bad key", key: "ABCD-0000", wantErr: true},
+		{name: "result after the ADIF", key: "ABCD-1234", option: "CALL:W1AW", want: "<call:4>W1AW<eor>"},
+		{name: "failure after the ADIF", key: "ABCD-1234", option: "CALL:N6DN", want: "<call:4>N6DN<eor>", wantReadErr: true},
+		{name: "failure before the ADIF", key: "ABCD-1234", option: "CALL:K9IJ", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := openQrzFetch(context.Background(), tt.key, tt.option)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("openQrzFetch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer body.Close()
+			// A tiny buffer splits the escapes across reads
+			got, err := io.ReadAll(iotest.OneByteReader(body))
+			if (err != nil) != tt.wantReadErr {
+				t.Errorf("openQrzFetch() read error = %v, wantReadErr %v", err, tt.wantReadErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("openQrzFetch() got = %v, want %v", string(got), tt.want)
+			}
+		})
+	}
+}
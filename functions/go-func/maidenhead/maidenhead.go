@@ -0,0 +1,137 @@
+// Package maidenhead converts between Maidenhead locators (grid squares) and latitude and
+// longitude, and finds the great-circle distance and bearing between two points.
+package maidenhead
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// EarthRadiusKm is the mean radius of the Earth.
+const EarthRadiusKm = 6371.0
+
+// Point is a position in decimal degrees, north and east positive.
+type Point struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// pairs are the character pairs of a locator: field, square, subsquare, extended square and
+// extended subsquare. Each splits the one before it into divisions, longitude and latitude alike.
+var pairs = []struct {
+	first     byte
+	divisions int
+}{
+	{'A', 18},
+	{'0', 10},
+	{'A', 24},
+	{'0', 10},
+	{'A', 24},
+}
+
+// Validate checks that the locator is 2 to 10 characters, in pairs, like DM79 or DM79lv. Letters
+// can be either case.
+func Validate(locator string) error {
+	_, _, err := decode(locator)
+	return err
+}
+
+// decode finds the southwest corner of the locator and the size of its square in degrees of
+// latitude.
+func decode(locator string) (Point, float64, error) {
+	if len(locator) < 2 || len(locator) > 2*len(pairs) || len(locator)%2 != 0 {
+		return Point{}, 0, fmt.Errorf("locator %q isn't 2, 4, 6, 8 or 10 characters", locator)
+	}
+	locator = strings.ToUpper(locator)
+	corner := Point{Latitude: -90, Longitude: -180}
+	size := 180.0
+	for i := 0; i < len(locator); i += 2 {
+		pair := pairs[i/2]
+		size /= float64(pair.divisions)
+		lon := int(locator[i]) - int(pair.first)
+		lat := int(locator[i+1]) - int(pair.first)
+		if lon < 0 || lon >= pair.divisions || lat < 0 || lat >= pair.divisions {
+			return Point{}, 0, fmt.Errorf("locator %q has a bad pair %q", locator, locator[i:i+2])
+		}
+		corner.Longitude += float64(lon) * size * 2
+		corner.Latitude += float64(lat) * size
+	}
+	return corner, size, nil
+}
+
+// Center returns the middle of the locator's square.
+func Center(locator string) (Point, error) {
+	corner, size, err := decode(locator)
+	if err != nil {
+		return Point{}, err
+	}
+	return Point{Latitude: corner.Latitude + size/2, Longitude: corner.Longitude + size}, nil
+}
+
+// Locator returns the locator of the given length (2 to 10 characters) which the point is in.
+func Locator(p Point, length int) (string, error) {
+	if length < 2 || length > 2*len(pairs) || length%2 != 0 {
+		return "", fmt.Errorf("can't make a locator of %d characters", length)
+	}
+	if p.Latitude < -90 || p.Latitude > 90 || p.Longitude < -180 || p.Longitude > 180 {
+		return "", errors.New("the point isn't on the Earth")
+	}
+	// The north pole and the antimeridian are in the last squares, not past them
+	lat := math.Min(p.Latitude+90, math.Nextafter(180, 0))
+	lon := math.Min(p.Longitude+180, math.Nextafter(360, 0))
+	var b strings.Builder
+	size := 180.0
+	for i := 0; i < length/2; i++ {
+		pair := pairs[i]
+		size /= float64(pair.divisions)
+		lonIndex := int(lon / (size * 2))
+		latIndex := int(lat / size)
+		lon -= float64(lonIndex) * size * 2
+		lat -= float64(latIndex) * size
+		first := pair.first
+		if i > 1 && first == 'A' {
+			// Subsquares are written in lowercase, like DM79lv
+			first = 'a'
+		}
+		b.WriteByte(first + byte(lonIndex))
+		b.WriteByte(first + byte(latIndex))
+	}
+	return b.String(), nil
+}
+
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+// DistanceKm returns the great-circle distance between the points along the short path.
+func DistanceKm(a, b Point) float64 {
+	lat1, lat2 := radians(a.Latitude), radians(b.Latitude)
+	dLat := lat2 - lat1
+	dLon := radians(b.Longitude - a.Longitude)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * EarthRadiusKm * math.Asin(math.Sqrt(math.Min(1, h)))
+}
+
+// LongPathDistanceKm returns the distance between the points the other way around the Earth.
+func LongPathDistanceKm(a, b Point) float64 {
+	return 2*math.Pi*EarthRadiusKm - DistanceKm(a, b)
+}
+
+// Bearing returns the initial bearing from a to b along the short path, in degrees clockwise from
+// true north.
+func Bearing(a, b Point) float64 {
+	lat1, lat2 := radians(a.Latitude), radians(b.Latitude)
+	dLon := radians(b.Longitude - a.Longitude)
+	y := math.Sin(dLon) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(dLon)
+	degrees := math.Atan2(y, x) * 180 / math.Pi
+	return math.Mod(degrees+360, 360)
+}
+
+// LongPathBearing returns the initial bearing from a to b along the long path, which is opposite
+// the short path.
+func LongPathBearing(a, b Point) float64 {
+	return math.Mod(Bearing(a, b)+180, 360)
+}
@@ -0,0 +1,123 @@
+package maidenhead
+
+import (
+	"math"
+	"testing"
+)
+
+func near(a, b, tolerance float64) bool {
+	return math.Abs(a-b) <= tolerance
+}
+
+func TestCenter(t *testing.T) {
+	tests := []struct {
+		locator string
+		want    Point
+		wantErr bool
+	}{
+		{locator: "DM", want: Point{35, -110}},
+		{locator: "DM79", want: Point{39.5, -105}},
+		{locator: "dm79lv", want: Point{39.0 + 21.5/24, -106 + 23.0/24}},
+		{locator: "DM79LV28", want: Point{39 + 21.0/24 + 8.5/240, -106 + 11.0/12 + 2.5/120}},
+		{locator: "RR99XX99XX", want: Point{90 - 0.5/5760, 180 - 1.0/5760}},
+		{locator: "AA00AA00AA", want: Point{-90 + 0.5/5760, -180 + 1.0/5760}},
+		{locator: "", wantErr: true},
+		{locator: "D", wantErr: true},
+		{locator: "DM7", wantErr: true},
+		{locator: "SM79", wantErr: true},
+		{locator: "DM7A", wantErr: true},
+		{locator: "DM79ZZ", wantErr: true},
+		{locator: "DM79LV28AAXX", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.locator, func(t *testing.T) {
+			got, err := Center(tt.locator)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Center() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (!near(got.Latitude, tt.want.Latitude, 1e-9) || !near(got.Longitude, tt.want.Longitude, 1e-9)) {
+				t.Errorf("Center() got = %v, want %v", got, tt.want)
+			}
+			if (Validate(tt.locator) != nil) != tt.wantErr {
+				t.Errorf("Validate() got = %v, wantErr %v", Validate(tt.locator), tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLocator(t *testing.T) {
+	tests := []struct {
+		name    string
+		point   Point
+		length  int
+		want    string
+		wantErr bool
+	}{
+		{name: "field", point: Point{39.91, -105.07}, length: 2, want: "DM"},
+		{name: "square", point: Point{39.91, -105.07}, length: 4, want: "DM79"},
+		{name: "subsquare", point: Point{39.91, -105.07}, length: 6, want: "DM79lv"},
+		{name: "extended", point: Point{39.91, -105.07}, length: 10, want: "DM79lv18oj"},
+		{name: "north pole", point: Point{90, 180}, length: 6, want: "RR99xx"},
+		{name: "south pole", point: Point{-90, -180}, length: 4, want: "AA00"},
+		{name: "southern hemisphere", point: Point{-33.87, 151.21}, length: 6, want: "QF56od"},
+		{name: "odd length", point: Point{0, 0}, length: 5, wantErr: true},
+		{name: "too long", point: Point{0, 0}, length: 12, wantErr: true},
+		{name: "off the Earth", point: Point{91, 0}, length: 4, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Locator(tt.point, tt.length)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Locator() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Locator() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocator_roundTrip(t *testing.T) {
+	for _, locator := range []string{"DM79lv", "JN58td", "FN31pr", "QF56od", "AA00aa", "RR99xx", "DM79lv47ae"} {
+		center, err := Center(locator)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := Locator(center, len(locator)); got != locator {
+			t.Errorf("Locator(Center(%v)) got = %v", locator, got)
+		}
+	}
+}
+
+func TestDistanceKmAndBearing(t *testing.T) {
+	dm79, _ := Center("DM79")
+	tests := []struct {
+		name            string
+		a, b            Point
+		wantKm          float64
+		wantBearing     float64
+		wantLongBearing float64
+	}{
+		{name: "along the equator", a: Point{0, 0}, b: Point{0, 90}, wantKm: math.Pi / 2 * EarthRadiusKm, wantBearing: 90, wantLongBearing: 270},
+		{name: "due north", a: Point{0, 0}, b: Point{10, 0}, wantKm: math.Pi / 18 * EarthRadiusKm, wantBearing: 0, wantLongBearing: 180},
+		{name: "DM79 to JN58", a: dm79, b: Point{48.5, 11}, wantKm: 8382.856, wantBearing: 37.986, wantLongBearing: 217.986},
+		{name: "DM79 to FN31", a: dm79, b: Point{41.5, -73}, wantKm: 2699.363, wantBearing: 74.873, wantLongBearing: 254.873},
+		{name: "same place", a: dm79, b: dm79, wantKm: 0, wantBearing: 0, wantLongBearing: 180},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DistanceKm(tt.a, tt.b); !near(got, tt.wantKm, 0.001) {
+				t.Errorf("DistanceKm() got = %v, want %v", got, tt.wantKm)
+			}
+			if got := LongPathDistanceKm(tt.a, tt.b); !near(got, 2*math.Pi*EarthRadiusKm-tt.wantKm, 0.001) {
+				t.Errorf("LongPathDistanceKm() got = %v, want %v", got, 2*math.Pi*EarthRadiusKm-tt.wantKm)
+			}
+			if got := Bearing(tt.a, tt.b); !near(got, tt.wantBearing, 0.001) {
+				t.Errorf("Bearing() got = %v, want %v", got, tt.wantBearing)
+			}
+			if got := LongPathBearing(tt.a, tt.b); !near(got, tt.wantLongBearing, 0.001) {
+				t.Errorf("LongPathBearing() got = %v, want %v", got, tt.wantLongBearing)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package forester
+
+import (
+	"dario.cat/mergo"
+	"encoding/json"
+	"fmt"
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"log"
+)
+
+// mergePolicyProperty is the logbook property holding the logbook's merge policies, as a JSON
+// object of import source to MergePolicy, e.g. {"qrz": {"contactedStation": "remoteWins"}}. It's
+// set on the logbook document in Firestore; see the README.
+const mergePolicyProperty = "mergePolicy"
+
+// ImportSource identifies where remote contacts being merged came from.
+type ImportSource string
+
+const (
+	SourceQrz  ImportSource = "qrz"
+	SourceLotw ImportSource = "lotw"
+	SourceEqsl ImportSource = "eqsl"
+	SourceAdif ImportSource = "adif"
+)
+
+// MergeStrategy decides how a remote value is merged into a local one.
+type MergeStrategy string
+
+const (
+	// FillOnly keeps local values, and only fills in blank ones from the remote.
+	FillOnly MergeStrategy = "fillOnly"
+	// RemoteWins replaces local values with any non-blank remote ones.
+	RemoteWins MergeStrategy = "remoteWins"
+	// LocalWins ignores the remote entirely.
+	LocalWins MergeStrategy = "localWins"
+	// NewestWins acts like RemoteWins if the remote has newer timestamps, or FillOnly otherwise.
+	// Only the QSL and upload groups have timestamps, so it can't be used for the others.
+	NewestWins MergeStrategy = "newestWins"
+)
+
+// FieldGroup is a group of QSO fields which are merged together.
+type FieldGroup string
+
+const (
+	GroupQso              FieldGroup = "qso"
+	GroupContactedStation FieldGroup = "contactedStation"
+	GroupLoggingStation   FieldGroup = "loggingStation"
+	GroupContest          FieldGroup = "contest"
+	GroupPropagation      FieldGroup = "propagation"
+	GroupAppDefined       FieldGroup = "appDefined"
+	GroupCard             FieldGroup = "card"
+	GroupEqsl             FieldGroup = "eqsl"
+	GroupLotw             FieldGroup = "lotw"
+	GroupQrzcom           FieldGroup = "qrzcom"
+	GroupClublog          FieldGroup = "clublog"
+	GroupHrdlog           FieldGroup = "hrdlog"
+)
+
+// mergeGroups are the groups a MergePolicy can name, and whether each has dates to compare for
+// NewestWins.
+var mergeGroups = map[FieldGroup]bool{
+	GroupQso:              false,
+	GroupContactedStation: false,
+	GroupLoggingStation:   false,
+	GroupContest:          false,
+	GroupPropagation:      false,
+	GroupAppDefined:       false,
+	GroupCard:             true,
+	GroupEqsl:             true,
+	GroupLotw:             true,
+	GroupQrzcom:           true,
+	GroupClublog:          true,
+	GroupHrdlog:           true,
+}
+
+// MergePolicy picks the MergeStrategy for each FieldGroup. Groups which aren't listed are FillOnly.
+type MergePolicy map[FieldGroup]MergeStrategy
+
+func (p MergePolicy) strategy(group FieldGroup) MergeStrategy {
+	if s, ok := p[group]; ok {
+		return s
+	}
+	return FillOnly
+}
+
+// defaultMergePolicy lets each provider's own QSL or upload status win when importing from it.
+func defaultMergePolicy(source ImportSource) MergePolicy {
+	switch source {
+	case SourceLotw:
+		return MergePolicy{GroupLotw: RemoteWins}
+	case SourceEqsl:
+		return MergePolicy{GroupEqsl: RemoteWins}
+	case SourceQrz:
+		return MergePolicy{GroupQrzcom: RemoteWins}
+	default:
+		return MergePolicy{}
+	}
+}
+
+// loadMergePolicy reads the logbook's merge policy for the given import source, on top of the
+// defaults for that source. It's an error if the policy names an unknown group or strategy, or uses
+// NewestWins for a group without timestamps.
+func loadMergePolicy(store QsoStore, source ImportSource) (MergePolicy, error) {
+	policy := defaultMergePolicy(source)
+	prop, err := store.GetLogbookProperty(mergePolicyProperty)
+	if err != nil {
+		return nil, err
+	}
+	if prop == "" || prop == "<nil>" {
+		return policy, nil
+	}
+	var policies map[ImportSource]MergePolicy
+	err = json.Unmarshal([]byte(prop), &policies)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse %v: %w", mergePolicyProperty, err)
+	}
+	for group, strategy := range policies[source] {
+		timestamped, ok := mergeGroups[group]
+		if !ok {
+			return nil, fmt.Errorf("unknown merge group %q", group)
+		}
+		switch strategy {
+		case FillOnly, RemoteWins, LocalWins:
+		case NewestWins:
+			if !timestamped {
+				return nil, fmt.Errorf("%v can't be %v, it has no dates to compare", group, strategy)
+			}
+		default:
+			return nil, fmt.Errorf("unknown merge strategy %q for %v", strategy, group)
+		}
+		policy[group] = strategy
+	}
+	return policy, nil
+}
+
+// mergeQsoWithPolicy merges the remote QSO into `base`, group by group according to the policy.
+// It returns true if `base` changed.
+func mergeQsoWithPolicy(base *adifpb.Qso, remote *adifpb.Qso, policy MergePolicy) bool {
+	original := cloneQso(base)
+	cleanQsl(base)
+	cleanQsl(remote)
+
+	merged := mergeTopLevel(base, remote, policy.strategy(GroupQso))
+	merged.ContactedStation = mergeMessage(base.ContactedStation, remote.ContactedStation,
+		policy.strategy(GroupContactedStation), nil, nil)
+	merged.LoggingStation = mergeMessage(base.LoggingStation, remote.LoggingStation,
+		policy.strategy(GroupLoggingStation), nil, nil)
+	merged.Contest = mergeMessage(base.Contest, remote.Contest,
+		policy.strategy(GroupContest), nil, nil)
+	merged.Propagation = mergeMessage(base.Propagation, remote.Propagation,
+		policy.strategy(GroupPropagation), nil, nil)
+	merged.Card = mergeMessage(base.Card, remote.Card,
+		policy.strategy(GroupCard), qslTime(base.Card), qslTime(remote.Card))
+	merged.Eqsl = mergeMessage(base.Eqsl, remote.Eqsl,
+		policy.strategy(GroupEqsl), qslTime(base.Eqsl), qslTime(remote.Eqsl))
+	merged.Lotw = mergeMessage(base.Lotw, remote.Lotw,
+		policy.strategy(GroupLotw), qslTime(base.Lotw), qslTime(remote.Lotw))
+	merged.Qrzcom = mergeMessage(base.Qrzcom, remote.Qrzcom,
+		policy.strategy(GroupQrzcom), base.Qrzcom.GetUploadDate(), remote.Qrzcom.GetUploadDate())
+	merged.Clublog = mergeMessage(base.Clublog, remote.Clublog,
+		policy.strategy(GroupClublog), base.Clublog.GetUploadDate(), remote.Clublog.GetUploadDate())
+	merged.Hrdlog = mergeMessage(base.Hrdlog, remote.Hrdlog,
+		policy.strategy(GroupHrdlog), base.Hrdlog.GetUploadDate(), remote.Hrdlog.GetUploadDate())
+	merged.AppDefined = mergeStringMap(base.AppDefined, remote.AppDefined,
+		policy.strategy(GroupAppDefined))
+
+	proto.Reset(base)
+	proto.Merge(base, merged)
+	return !proto.Equal(original, base)
+}
+
+// mergeTopLevel merges the QSO's own fields, leaving out all of its sub-messages and maps.
+func mergeTopLevel(base *adifpb.Qso, remote *adifpb.Qso, strategy MergeStrategy) *adifpb.Qso {
+	local := topLevelOnly(base)
+	if strategy == LocalWins {
+		return local
+	}
+	return mergeMessage(local, topLevelOnly(remote), strategy, nil, nil)
+}
+
+func topLevelOnly(qso *adifpb.Qso) *adifpb.Qso {
+	top := cloneQso(qso)
+	top.ContactedStation = nil
+	top.LoggingStation = nil
+	top.Contest = nil
+	top.Propagation = nil
+	top.Card = nil
+	top.Eqsl = nil
+	top.Lotw = nil
+	top.Qrzcom = nil
+	top.Clublog = nil
+	top.Hrdlog = nil
+	top.AppDefined = nil
+	return top
+}
+
+// mergeMessage merges one sub-message with the given strategy. The timestamps are only used by
+// NewestWins; nil means there is no timestamp.
+func mergeMessage[T any](local *T, remote *T, strategy MergeStrategy,
+	localTime *timestamppb.Timestamp, remoteTime *timestamppb.Timestamp) *T {
+	if strategy == NewestWins {
+		if remoteTime != nil && (localTime == nil || remoteTime.AsTime().After(localTime.AsTime())) {
+			strategy = RemoteWins
+		} else {
+			strategy = FillOnly
+		}
+	}
+	if remote == nil || strategy == LocalWins {
+		return local
+	}
+	if local == nil {
+		return remote
+	}
+	var err error
+	switch strategy {
+	case RemoteWins:
+		err = mergo.Merge(local, remote, mergo.WithOverride)
+	default:
+		err = mergo.Merge(local, remote)
+	}
+	if err != nil {
+		log.Printf("Problem merging: %v", err)
+	}
+	return local
+}
+
+func mergeStringMap(local map[string]string, remote map[string]string,
+	strategy MergeStrategy) map[string]string {
+	if len(remote) == 0 || strategy == LocalWins {
+		return local
+	}
+	merged := make(map[string]string, len(local)+len(remote))
+	for k, v := range local {
+		merged[k] = v
+	}
+	for k, v := range remote {
+		if _, ok := merged[k]; !ok || (strategy == RemoteWins && v != "") {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// qslTime is the most recent date on the QSL, or nil if it has none.
+func qslTime(qsl *adifpb.Qsl) *timestamppb.Timestamp {
+	sent := qsl.GetSentDate()
+	received := qsl.GetReceivedDate()
+	if received == nil || (sent != nil && sent.AsTime().After(received.AsTime())) {
+		return sent
+	}
+	return received
+}
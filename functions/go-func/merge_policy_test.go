@@ -0,0 +1,131 @@
+package forester
+
+import (
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"testing"
+	"time"
+)
+
+func Test_mergeQsoWithPolicy(t *testing.T) {
+	older := timestamppb.New(time.Date(2020, 10, 25, 0, 0, 0, 0, time.UTC))
+	newer := timestamppb.New(time.Date(2020, 10, 31, 0, 0, 0, 0, time.UTC))
+	tests := []struct {
+		name     string
+		base     *adifpb.Qso
+		remote   *adifpb.Qso
+		policy   MergePolicy
+		wantDiff bool
+		wantQso  *adifpb.Qso
+	}{
+		{
+			name:     "FillOnly keeps local call",
+			base:     &adifpb.Qso{ContactedStation: &adifpb.Station{StationCall: "K9IJ"}},
+			remote:   &adifpb.Qso{ContactedStation: &adifpb.Station{StationCall: "K9IJ/M", State: "IL"}},
+			policy:   MergePolicy{},
+			wantDiff: true,
+			wantQso:  &adifpb.Qso{ContactedStation: &adifpb.Station{StationCall: "K9IJ", State: "IL"}},
+		},
+		{
+			name:     "RemoteWins takes corrected call",
+			base:     &adifpb.Qso{ContactedStation: &adifpb.Station{StationCall: "K9IJ", OpName: "Johnny"}},
+			remote:   &adifpb.Qso{ContactedStation: &adifpb.Station{StationCall: "K9IJ/M"}},
+			policy:   MergePolicy{GroupContactedStation: RemoteWins},
+			wantDiff: true,
+			wantQso:  &adifpb.Qso{ContactedStation: &adifpb.Station{StationCall: "K9IJ/M", OpName: "Johnny"}},
+		},
+		{
+			name:     "LocalWins ignores remote",
+			base:     &adifpb.Qso{Band: "20m"},
+			remote:   &adifpb.Qso{Band: "20m", ContactedStation: &adifpb.Station{State: "IL"}},
+			policy:   MergePolicy{GroupContactedStation: LocalWins},
+			wantDiff: false,
+			wantQso:  &adifpb.Qso{Band: "20m"},
+		},
+		{
+			name:     "Groups are independent",
+			base:     &adifpb.Qso{Mode: "FT8", ContactedStation: &adifpb.Station{StationCall: "K9IJ"}},
+			remote:   &adifpb.Qso{Mode: "FT4", ContactedStation: &adifpb.Station{StationCall: "K9IJ/M"}},
+			policy:   MergePolicy{GroupQso: RemoteWins},
+			wantDiff: true,
+			wantQso:  &adifpb.Qso{Mode: "FT4", ContactedStation: &adifpb.Station{StationCall: "K9IJ"}},
+		},
+		{
+			name:     "NewestWins with newer remote",
+			base:     &adifpb.Qso{Eqsl: &adifpb.Qsl{ReceivedStatus: "R", ReceivedDate: older}},
+			remote:   &adifpb.Qso{Eqsl: &adifpb.Qsl{ReceivedStatus: "Y", ReceivedDate: newer}},
+			policy:   MergePolicy{GroupEqsl: NewestWins},
+			wantDiff: true,
+			wantQso:  &adifpb.Qso{Eqsl: &adifpb.Qsl{ReceivedStatus: "Y", ReceivedDate: newer}},
+		},
+		{
+			name:     "NewestWins with older remote",
+			base:     &adifpb.Qso{Eqsl: &adifpb.Qsl{ReceivedStatus: "Y", ReceivedDate: newer}},
+			remote:   &adifpb.Qso{Eqsl: &adifpb.Qsl{ReceivedStatus: "R", ReceivedDate: older, SentStatus: "Y"}},
+			policy:   MergePolicy{GroupEqsl: NewestWins},
+			wantDiff: true,
+			wantQso:  &adifpb.Qso{Eqsl: &adifpb.Qsl{ReceivedStatus: "Y", ReceivedDate: newer, SentStatus: "Y"}},
+		},
+		{
+			name:     "AppDefined RemoteWins",
+			base:     &adifpb.Qso{AppDefined: map[string]string{"app_qrzlog_status": "N", "app_x": "1"}},
+			remote:   &adifpb.Qso{AppDefined: map[string]string{"app_qrzlog_status": "C"}},
+			policy:   MergePolicy{GroupAppDefined: RemoteWins},
+			wantDiff: true,
+			wantQso:  &adifpb.Qso{AppDefined: map[string]string{"app_qrzlog_status": "C", "app_x": "1"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := mergeQsoWithPolicy(tt.base, tt.remote, tt.policy)
+			if diff != tt.wantDiff {
+				t.Errorf("mergeQsoWithPolicy() diff got = %v, want %v", diff, tt.wantDiff)
+			}
+			if !proto.Equal(tt.base, tt.wantQso) {
+				t.Errorf("mergeQsoWithPolicy() qso got = %v, want %v", tt.base, tt.wantQso)
+			}
+		})
+	}
+}
+
+func Test_loadMergePolicy(t *testing.T) {
+	store := NewMemoryQsoStore()
+	policy, err := loadMergePolicy(store, SourceLotw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.strategy(GroupLotw) != RemoteWins || policy.strategy(GroupCard) != FillOnly {
+		t.Errorf("loadMergePolicy() default got = %v", policy)
+	}
+
+	_ = store.SetLogbookProperty(mergePolicyProperty,
+		`{"qrz": {"contactedStation": "remoteWins"}, "lotw": {"lotw": "newestWins"}}`)
+	policy, err = loadMergePolicy(store, SourceLotw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.strategy(GroupLotw) != NewestWins || policy.strategy(GroupContactedStation) != FillOnly {
+		t.Errorf("loadMergePolicy() lotw got = %v", policy)
+	}
+	policy, err = loadMergePolicy(store, SourceQrz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.strategy(GroupContactedStation) != RemoteWins || policy.strategy(GroupQrzcom) != RemoteWins {
+		t.Errorf("loadMergePolicy() qrz got = %v", policy)
+	}
+
+	for _, prop := range []string{
+		`{"qrz": {"card": "sometimes"}}`,
+		`{"qrz": {"contactedstation": "remoteWins"}}`,
+		`{"qrz": {"contactedStation": "newestWins"}}`,
+		`{"qrz": {"qso": "newestWins"}}`,
+	} {
+		_ = store.SetLogbookProperty(mergePolicyProperty, prop)
+		_, err = loadMergePolicy(store, SourceQrz)
+		if err == nil {
+			t.Errorf("loadMergePolicy() want error for %v", prop)
+		}
+	}
+}
@@ -13,17 +13,33 @@ import (
 	adifpb "github.com/k0swe/adif-json-protobuf/go"
 )
 
+// protoToAdif writes the QSOs as ADI. If pb has a header, a header is written too; see
+// writeAdifHeader.
 func protoToAdif(pb *adifpb.Adif) (string, error) {
+	return encodeAdif(pb, formatAdi)
+}
+
+// protoToAdx writes the QSOs as ADX, like protoToAdif.
+func protoToAdx(pb *adifpb.Adif) (string, error) {
+	return encodeAdif(pb, formatAdx)
+}
+
+func encodeAdif(pb *adifpb.Adif, format adifFormat) (string, error) {
 	buf := new(bytes.Buffer)
-	writer := adif.NewADIDocumentWriter(buf)
+	encoder := newQsoEncoder(buf, format)
+	if pb.Header != nil {
+		err := encoder.WriteHeader(pb.Header, nil)
+		if err != nil {
+			return "", err
+		}
+	}
 	for _, qso := range pb.Qsos {
-		record := writeQso(qso)
-		err := writer.WriteRecord(record)
+		err := encoder.Encode(qso)
 		if err != nil {
 			return "", err
 		}
 	}
-	err := writer.Flush()
+	err := encoder.Close()
 	return buf.String(), err
 }
 
@@ -237,7 +253,11 @@ func writeUploadStatus(status adifpb.UploadStatus) string {
 func writeQsls(qso *adifpb.Qso, rec adif.Record) {
 	writeCardQsl(qso.Card, rec)
 	writeQsl(qso.Eqsl, rec, adifield.EQSL_QSL_SENT, adifield.EQSL_QSLSDATE, adifield.EQSL_QSL_RCVD, adifield.EQSL_QSLRDATE)
-	writeQsl(qso.Eqsl, rec, adifield.LOTW_QSL_SENT, adifield.LOTW_QSLSDATE, adifield.LOTW_QSL_RCVD, adifield.LOTW_QSLRDATE)
+	if qso.Card == nil && qso.Eqsl != nil {
+		// ADIF only has the one QSL message field; see parseQsls
+		writeString(rec, adifield.QSLMSG, qso.Eqsl.ReceivedMessage)
+	}
+	writeQsl(qso.Lotw, rec, adifield.LOTW_QSL_SENT, adifield.LOTW_QSLSDATE, adifield.LOTW_QSL_RCVD, adifield.LOTW_QSLRDATE)
 }
 
 func writeCardQsl(qsl *adifpb.Qsl, rec adif.Record) {
@@ -258,7 +278,6 @@ func writeQsl(qsl *adifpb.Qsl, rec adif.Record, sent, sentDate, rcvd, rcvdDate a
 	writeDate(rec, sentDate, qsl.SentDate.AsTime())
 	writeString(rec, rcvd, qsl.ReceivedStatus)
 	writeDate(rec, rcvdDate, qsl.ReceivedDate.AsTime())
-
 }
 
 func writeString(rec adif.Record, adifField adifield.Field, value string) {
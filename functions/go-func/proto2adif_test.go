@@ -1,11 +1,14 @@
 package forester
 
 import (
+	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
@@ -114,3 +117,157 @@ func Test_dateTimeToString(t *testing.T) {
 		})
 	}
 }
+
+func Test_adifRoundTrip_unknownFields(t *testing.T) {
+	fields := []string{
+		"<POTA_REF:6>K-0001",
+		"<MY_POTA_REF:13>K-4566,K-4576",
+		"<WWFF_REF:8>KFF-0001",
+		"<MY_WWFF_REF:8>KFF-4566",
+		"<ALTITUDE:4>1609",
+		"<NAME_INTL:7>Jürgen",
+		"<APP_N1MM_ID:3>123",
+	}
+	adi := "<CALL:4>N6DN<QSO_DATE:8>20201025<TIME_ON:4>2015" + strings.Join(fields, "") + "<EOR>\n"
+	pb, _, err := adifToProto(adi, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pb.Qsos[0].AppDefined["pota_ref"]; got != "K-0001" {
+		t.Errorf("adifToProto() pota_ref got = %v, want K-0001", got)
+	}
+	if _, ok := pb.Qsos[0].AppDefined["call"]; ok {
+		t.Errorf("adifToProto() kept a parsed field in AppDefined: %v", pb.Qsos[0].AppDefined)
+	}
+	got, err := protoToAdif(pb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range fields {
+		if !strings.Contains(got, field) {
+			t.Errorf("protoToAdif() got = %v, want %v", got, field)
+		}
+	}
+}
+
+func Test_qslRoundTrip(t *testing.T) {
+	date := func(y int, m time.Month, d int) *timestamppb.Timestamp {
+		return timestamppb.New(time.Date(y, m, d, 0, 0, 0, 0, time.UTC))
+	}
+	tests := []struct {
+		name       string
+		card       *adifpb.Qsl
+		eqsl       *adifpb.Qsl
+		lotw       *adifpb.Qsl
+		appDefined map[string]string
+		wantAdif   []string
+	}{
+		{
+			name: "card",
+			card: &adifpb.Qsl{SentStatus: "Y", SentDate: date(2020, 11, 2), SentVia: "B",
+				ReceivedStatus: "Y", ReceivedDate: date(2020, 12, 1), ReceivedVia: "D", ReceivedMessage: "TNX QSO"},
+			wantAdif: []string{"<QSL_SENT:1>Y", "<QSLSDATE:8>20201102", "<QSL_SENT_VIA:1>B",
+				"<QSL_RCVD:1>Y", "<QSLRDATE:8>20201201", "<QSL_RCVD_VIA:1>D", "<QSLMSG:7>TNX QSO"},
+		},
+		{
+			name:       "eQSL",
+			eqsl:       &adifpb.Qsl{ReceivedStatus: "Y", ReceivedDate: date(2020, 10, 30), ReceivedMessage: "73"},
+			appDefined: map[string]string{"app_eqsl_ag": "Y"},
+			wantAdif: []string{"<EQSL_QSL_RCVD:1>Y", "<EQSL_QSLRDATE:8>20201030", "<QSLMSG:2>73",
+				"<APP_EQSL_AG:1>Y"},
+		},
+		{
+			name: "LoTW",
+			lotw: &adifpb.Qsl{SentStatus: "Y", SentDate: date(2020, 10, 26), ReceivedStatus: "Y",
+				ReceivedDate: date(2020, 10, 31)},
+			wantAdif: []string{"<LOTW_QSL_SENT:1>Y", "<LOTW_QSLSDATE:8>20201026", "<LOTW_QSL_RCVD:1>Y",
+				"<LOTW_QSLRDATE:8>20201031"},
+		},
+		{
+			name: "all three",
+			card: &adifpb.Qsl{SentStatus: "R", SentVia: "B", ReceivedMessage: "PSE QSL"},
+			eqsl: &adifpb.Qsl{SentStatus: "Y", SentDate: date(2020, 10, 27)},
+			lotw: &adifpb.Qsl{ReceivedStatus: "V", ReceivedDate: date(2020, 10, 31)},
+			wantAdif: []string{"<QSL_SENT:1>R", "<QSL_SENT_VIA:1>B", "<QSLMSG:7>PSE QSL",
+				"<EQSL_QSL_SENT:1>Y", "<EQSL_QSLSDATE:8>20201027", "<LOTW_QSL_RCVD:1>V",
+				"<LOTW_QSLRDATE:8>20201031"},
+		},
+		{
+			name:     "not sent",
+			card:     &adifpb.Qsl{SentStatus: "N", ReceivedStatus: "N"},
+			lotw:     &adifpb.Qsl{SentStatus: "N"},
+			wantAdif: []string{"<QSL_SENT:1>N", "<QSL_RCVD:1>N", "<LOTW_QSL_SENT:1>N"},
+		},
+		{
+			name:     "card via only",
+			card:     &adifpb.Qsl{SentVia: "B"},
+			wantAdif: []string{"<QSL_SENT_VIA:1>B"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qso := &adifpb.Qso{
+				TimeOn:           timestamppb.New(time.Date(2020, 10, 25, 20, 15, 0, 0, time.UTC)),
+				ContactedStation: &adifpb.Station{StationCall: "N6DN"},
+				Card:             tt.card,
+				Eqsl:             tt.eqsl,
+				Lotw:             tt.lotw,
+				AppDefined:       tt.appDefined,
+			}
+			adi, err := protoToAdif(&adifpb.Adif{Qsos: []*adifpb.Qso{qso}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.wantAdif {
+				if !strings.Contains(adi, want) {
+					t.Errorf("protoToAdif() got = %v, want %v", adi, want)
+				}
+			}
+			if strings.Count(adi, "QSL_") != strings.Count(strings.Join(tt.wantAdif, ""), "QSL_") {
+				t.Errorf("protoToAdif() got = %v, want only %v", adi, tt.wantAdif)
+			}
+
+			got, _, err := adifToProto(adi, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotQso := got.Qsos[0]
+			if !proto.Equal(gotQso.Card, tt.card) {
+				t.Errorf("adifToProto() card got = %v, want %v", gotQso.Card, tt.card)
+			}
+			if !proto.Equal(gotQso.Eqsl, tt.eqsl) {
+				t.Errorf("adifToProto() eQSL got = %v, want %v", gotQso.Eqsl, tt.eqsl)
+			}
+			if !proto.Equal(gotQso.Lotw, tt.lotw) {
+				t.Errorf("adifToProto() LoTW got = %v, want %v", gotQso.Lotw, tt.lotw)
+			}
+			if !reflect.DeepEqual(gotQso.AppDefined, tt.appDefined) {
+				t.Errorf("adifToProto() app defined got = %v, want %v", gotQso.AppDefined, tt.appDefined)
+			}
+		})
+	}
+}
+
+func Test_protoToAdif_header(t *testing.T) {
+	pb := &adifpb.Adif{
+		Header: &adifpb.Header{
+			AdifVersion:      "3.1.1",
+			CreatedTimestamp: timestamppb.New(time.Date(2020, 11, 1, 12, 30, 0, 0, time.UTC)),
+			ProgramId:        "WSJT-X",
+		},
+		Qsos: []*adifpb.Qso{{ContactedStation: &adifpb.Station{StationCall: "N6DN"}}},
+	}
+	got, err := protoToAdif(pb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, records, _ := strings.Cut(got, "<EOH>")
+	want := "<PROGRAMVERSION:" + strconv.Itoa(len(programVersion)) + ">" + programVersion
+	if !strings.Contains(header, "<PROGRAMID:13>forester-func") || !strings.Contains(header, want) ||
+		!strings.Contains(header, "<CREATED_TIMESTAMP:15>20201101 123000") {
+		t.Errorf("protoToAdif() header got = %v", header)
+	}
+	if strings.TrimSpace(records) != "<CALL:4>N6DN<EOR>" {
+		t.Errorf("protoToAdif() records got = %v", records)
+	}
+}
@@ -0,0 +1,157 @@
+package forester
+
+import (
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// matchWindowProperty is the logbook property holding how many minutes apart two records of the
+// same contact may be.
+const matchWindowProperty = "matchWindowMinutes"
+
+const defaultMatchWindow = 2 * time.Minute
+
+// AmbiguousMatch is a remote contact which matched several stored contacts equally well. It's
+// reported instead of being merged into any of them.
+type AmbiguousMatch struct {
+	Call       string   `json:"call" firestore:"call"`
+	TimeOn     string   `json:"timeOn" firestore:"timeOn"`
+	ContactIDs []string `json:"contactIds" firestore:"contactIds"`
+}
+
+// qsoMatcher finds the stored contact which a remote record describes. Records match if they have
+// the same callsigns, are within the time window of each other, and don't disagree on band or mode
+// family. Each stored contact can only be matched once.
+type qsoMatcher struct {
+	window time.Duration
+	byCall map[string][]FirestoreQso
+}
+
+func newQsoMatcher(qsos []FirestoreQso, window time.Duration) *qsoMatcher {
+	m := &qsoMatcher{window: window, byCall: map[string][]FirestoreQso{}}
+	for _, qso := range qsos {
+		key := callsKey(qso.qsopb)
+		m.byCall[key] = append(m.byCall[key], qso)
+	}
+	return m
+}
+
+// loadMatchWindow reads the logbook's match window, or the default if it isn't set.
+func loadMatchWindow(store QsoStore) time.Duration {
+	prop, err := store.GetLogbookProperty(matchWindowProperty)
+	if err != nil || prop == "" || prop == "<nil>" {
+		return defaultMatchWindow
+	}
+	minutes, err := strconv.ParseFloat(prop, 64)
+	if err != nil || minutes < 0 {
+		return defaultMatchWindow
+	}
+	return time.Duration(minutes * float64(time.Minute))
+}
+
+type matchCandidate struct {
+	qso       FirestoreQso
+	sameBand  bool
+	sameMode  bool
+	timeDelta time.Duration
+}
+
+// match finds the stored contact matching the remote one; ok is false if there isn't one. If
+// several contacts match equally well, they're returned as ambiguous and none is matched.
+func (m *qsoMatcher) match(remote *adifpb.Qso) (match FirestoreQso, ambiguous []FirestoreQso, ok bool) {
+	key := callsKey(remote)
+	var candidates []matchCandidate
+	for _, qso := range m.byCall[key] {
+		delta := qso.qsopb.TimeOn.AsTime().Sub(remote.TimeOn.AsTime()).Abs()
+		if delta > m.window || !bandsCompatible(qso.qsopb, remote) || !modesCompatible(qso.qsopb, remote) {
+			continue
+		}
+		candidates = append(candidates, matchCandidate{
+			qso:       qso,
+			sameBand:  remote.Band != "" && strings.EqualFold(qso.qsopb.Band, remote.Band),
+			sameMode:  remote.Mode != "" && strings.EqualFold(qso.qsopb.Mode, remote.Mode),
+			timeDelta: delta,
+		})
+	}
+	if len(candidates) == 0 {
+		return FirestoreQso{}, nil, false
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.sameBand != b.sameBand {
+			return a.sameBand
+		}
+		if a.sameMode != b.sameMode {
+			return a.sameMode
+		}
+		return a.timeDelta < b.timeDelta
+	})
+
+	// Some providers (QRZ.com) only have minute precision, so times within that are a tie
+	precision := time.Second
+	if remote.TimeOn.AsTime().Truncate(time.Minute).Equal(remote.TimeOn.AsTime()) {
+		precision = time.Minute
+	}
+	best := candidates[0]
+	for _, c := range candidates {
+		if c.sameBand == best.sameBand && c.sameMode == best.sameMode &&
+			c.timeDelta-best.timeDelta < precision {
+			ambiguous = append(ambiguous, c.qso)
+		}
+	}
+	if len(ambiguous) > 1 {
+		return FirestoreQso{}, ambiguous, false
+	}
+	m.claim(key, best.qso)
+	return best.qso, nil, true
+}
+
+func (m *qsoMatcher) claim(key string, qso FirestoreQso) {
+	qsos := m.byCall[key]
+	for i := range qsos {
+		if qsos[i].qsopb == qso.qsopb {
+			m.byCall[key] = append(qsos[:i:i], qsos[i+1:]...)
+			return
+		}
+	}
+}
+
+func callsKey(qso *adifpb.Qso) string {
+	return normalizeCall(qso.LoggingStation.GetStationCall()) + " " +
+		normalizeCall(qso.ContactedStation.GetStationCall())
+}
+
+func bandsCompatible(a *adifpb.Qso, b *adifpb.Qso) bool {
+	return a.Band == "" || b.Band == "" || strings.EqualFold(a.Band, b.Band)
+}
+
+func modesCompatible(a *adifpb.Qso, b *adifpb.Qso) bool {
+	familyA, familyB := modeFamily(a.Mode), modeFamily(b.Mode)
+	return familyA == "" || familyB == "" || familyA == familyB
+}
+
+// modeFamily groups modes the way LoTW does: CW, PHONE, or DATA.
+func modeFamily(mode string) string {
+	switch strings.ToUpper(mode) {
+	case "":
+		return ""
+	case "CW":
+		return "CW"
+	case "SSB", "USB", "LSB", "AM", "FM", "DIGITALVOICE", "PHONE":
+		return "PHONE"
+	default:
+		return "DATA"
+	}
+}
+
+func describeAmbiguous(remote *adifpb.Qso, matches []FirestoreQso) AmbiguousMatch {
+	diff := describeQso(remote)
+	ambiguous := AmbiguousMatch{Call: diff.Call, TimeOn: diff.TimeOn}
+	for _, qso := range matches {
+		ambiguous.ContactIDs = append(ambiguous.ContactIDs, qso.id)
+	}
+	return ambiguous
+}
@@ -0,0 +1,120 @@
+package forester
+
+import (
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_qsoMatcher_match(t *testing.T) {
+	at := func(h, m, s int) *timestamppb.Timestamp {
+		return timestamppb.New(time.Date(2020, 10, 25, h, m, s, 0, time.UTC))
+	}
+	qso := func(id string, band string, mode string, timeOn *timestamppb.Timestamp) FirestoreQso {
+		return FirestoreQso{id: id, qsopb: &adifpb.Qso{
+			Band:             band,
+			Mode:             mode,
+			TimeOn:           timeOn,
+			LoggingStation:   &adifpb.Station{StationCall: "K0SWE"},
+			ContactedStation: &adifpb.Station{StationCall: "N6DN"},
+		}}
+	}
+	tests := []struct {
+		name          string
+		stored        []FirestoreQso
+		remote        FirestoreQso
+		wantID        string
+		wantAmbiguous []string
+	}{
+		{
+			name:   "Across a minute boundary",
+			stored: []FirestoreQso{qso("a", "20m", "FT8", at(12, 0, 59))},
+			remote: qso("", "20m", "FT8", at(12, 1, 0)),
+			wantID: "a",
+		},
+		{
+			name:   "Outside the window",
+			stored: []FirestoreQso{qso("a", "20m", "FT8", at(12, 0, 0))},
+			remote: qso("", "20m", "FT8", at(12, 5, 0)),
+		},
+		{
+			name: "Same minute on different bands",
+			stored: []FirestoreQso{
+				qso("a", "20m", "FT8", at(12, 0, 10)),
+				qso("b", "40m", "FT8", at(12, 0, 40)),
+			},
+			remote: qso("", "40m", "FT8", at(12, 0, 0)),
+			wantID: "b",
+		},
+		{
+			name:   "Different mode family",
+			stored: []FirestoreQso{qso("a", "20m", "CW", at(12, 0, 0))},
+			remote: qso("", "20m", "SSB", at(12, 0, 0)),
+		},
+		{
+			name:   "Compatible mode family",
+			stored: []FirestoreQso{qso("a", "20m", "USB", at(12, 0, 0))},
+			remote: qso("", "20m", "SSB", at(12, 0, 0)),
+			wantID: "a",
+		},
+		{
+			name: "Exact mode breaks a tie",
+			stored: []FirestoreQso{
+				qso("a", "20m", "FT4", at(12, 0, 10)),
+				qso("b", "20m", "FT8", at(12, 0, 40)),
+			},
+			remote: qso("", "20m", "FT8", at(12, 0, 0)),
+			wantID: "b",
+		},
+		{
+			name: "Closest time breaks a tie",
+			stored: []FirestoreQso{
+				qso("a", "20m", "FT8", at(12, 0, 10)),
+				qso("b", "20m", "FT8", at(12, 1, 30)),
+			},
+			remote: qso("", "20m", "FT8", at(12, 0, 15)),
+			wantID: "a",
+		},
+		{
+			name: "Ambiguous within the remote's precision",
+			stored: []FirestoreQso{
+				qso("a", "20m", "FT8", at(12, 0, 10)),
+				qso("b", "20m", "FT8", at(12, 0, 40)),
+			},
+			remote:        qso("", "20m", "FT8", at(12, 0, 0)),
+			wantAmbiguous: []string{"a", "b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matcher := newQsoMatcher(tt.stored, defaultMatchWindow)
+			match, ambiguous, ok := matcher.match(tt.remote.qsopb)
+			if ok != (tt.wantID != "") || match.id != tt.wantID {
+				t.Errorf("match() got = %v, %v, want %v", match.id, ok, tt.wantID)
+			}
+			ambiguousIDs := describeAmbiguous(tt.remote.qsopb, ambiguous).ContactIDs
+			if !reflect.DeepEqual(ambiguousIDs, tt.wantAmbiguous) {
+				t.Errorf("match() ambiguous got = %v, want %v", ambiguousIDs, tt.wantAmbiguous)
+			}
+		})
+	}
+}
+
+func Test_qsoMatcher_claims(t *testing.T) {
+	timeOn := timestamppb.New(time.Date(2020, 10, 25, 12, 0, 0, 0, time.UTC))
+	stored := FirestoreQso{id: "a", qsopb: &adifpb.Qso{
+		Band:             "2m",
+		TimeOn:           timeOn,
+		LoggingStation:   &adifpb.Station{StationCall: "K0SWE"},
+		ContactedStation: &adifpb.Station{StationCall: "N6DN"},
+	}}
+	matcher := newQsoMatcher([]FirestoreQso{stored}, defaultMatchWindow)
+	if _, _, ok := matcher.match(stored.qsopb); !ok {
+		t.Fatalf("match() first got = false, want true")
+	}
+	if _, _, ok := matcher.match(stored.qsopb); ok {
+		t.Errorf("match() second got = true, want false")
+	}
+}
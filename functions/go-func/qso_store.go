@@ -0,0 +1,325 @@
+package forester
+
+import (
+	"cloud.google.com/go/firestore"
+	"context"
+	"errors"
+	"fmt"
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	"google.golang.org/api/iterator"
+	"log"
+)
+
+// QsoStore persists the contacts and properties of a single logbook. The Firestore implementation
+// is the default; MemoryQsoStore is useful for tests and local runs.
+type QsoStore interface {
+	// GetContacts lists every contact in the logbook.
+	GetContacts() ([]FirestoreQso, error)
+	// EachContact calls fn with each contact in the logbook, in order of start time, without
+	// holding them all in memory. Contacts without a start time are left out. It stops at the first
+	// error from fn and returns it.
+	EachContact(fn func(FirestoreQso) error) error
+	// GetContact fetches a single contact by its document ID.
+	GetContact(id string) (FirestoreQso, error)
+	// Create adds a new contact to the logbook.
+	Create(qso *adifpb.Qso) error
+	// Update overwrites an existing contact.
+	Update(qso FirestoreQso) error
+	// Delete removes an existing contact.
+	Delete(qso FirestoreQso) error
+	// WriteAll creates and updates many contacts at once. The writes are independent of each
+	// other; the ones which failed are returned.
+	WriteAll(creates []*adifpb.Qso, updates []FirestoreQso) []WriteFailure
+	// GetLogbookProperty reads a property of the logbook document. Like Firestore, a property which
+	// was never set reads as "<nil>".
+	GetLogbookProperty(key string) (string, error)
+	// SetLogbookProperty writes a property of the logbook document.
+	SetLogbookProperty(key string, value string) error
+	// CreateImportJob adds an import job to the logbook, returning its ID.
+	CreateImportJob(job *ImportJob) (string, error)
+	// GetImportJob fetches an import job by its ID.
+	GetImportJob(id string) (*ImportJob, error)
+	// SaveImportJob overwrites an existing import job.
+	SaveImportJob(id string, job *ImportJob) error
+	// AddImportJobKeys records the keys of remote contacts which an import job has merged.
+	AddImportJobKeys(id string, keys []string) error
+	// GetImportJobKeys lists the keys recorded for an import job.
+	GetImportJobKeys(id string) (map[string]bool, error)
+}
+
+// WriteFailure is a contact which WriteAll couldn't write. ContactID is the document ID it would
+// have had, for creates.
+type WriteFailure struct {
+	ContactID string `json:"contactId" firestore:"contactId"`
+	Call      string `json:"call" firestore:"call"`
+	TimeOn    string `json:"timeOn" firestore:"timeOn"`
+	Error     string `json:"error" firestore:"error"`
+}
+
+func newWriteFailure(contactID string, qso *adifpb.Qso, err error) WriteFailure {
+	diff := describeQso(qso)
+	return WriteFailure{contactID, diff.Call, diff.TimeOn, err.Error()}
+}
+
+// writeEach implements WriteAll with one Create or Update at a time.
+func writeEach(store QsoStore, creates []*adifpb.Qso, updates []FirestoreQso) []WriteFailure {
+	var failures []WriteFailure
+	for _, qso := range creates {
+		err := store.Create(qso)
+		if err != nil {
+			failures = append(failures, newWriteFailure("", qso, err))
+		}
+	}
+	for _, qso := range updates {
+		err := store.Update(qso)
+		if err != nil {
+			failures = append(failures, newWriteFailure(qso.id, qso.qsopb, err))
+		}
+	}
+	return failures
+}
+
+type firestoreQsoStore struct {
+	ctx         context.Context
+	client      *firestore.Client
+	logbookDoc  *firestore.DocumentRef
+	contactsCol *firestore.CollectionRef
+	jobsCol     *firestore.CollectionRef
+}
+
+// NewFirestoreQsoStore makes a QsoStore backed by the given logbook in Firestore.
+func NewFirestoreQsoStore(ctx context.Context, client *firestore.Client, logbookID string) QsoStore {
+	logbookDoc := client.Collection("logbooks").Doc(logbookID)
+	return &firestoreQsoStore{
+		ctx,
+		client,
+		logbookDoc,
+		logbookDoc.Collection("contacts"),
+		logbookDoc.Collection("importJobs"),
+	}
+}
+
+func (s *firestoreQsoStore) GetContacts() ([]FirestoreQso, error) {
+	docItr := s.contactsCol.Documents(s.ctx)
+	var retval = make([]FirestoreQso, 0, 100)
+	for i := 0; ; i++ {
+		qsoDoc, err := docItr.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		firestoreQso, err := ParseFirestoreQso(qsoDoc)
+		if err != nil {
+			log.Printf("Skipping qso %d: unmarshaling error: %v", i, err)
+			continue
+		}
+		retval = append(retval, firestoreQso)
+	}
+	return retval, nil
+}
+
+func (s *firestoreQsoStore) EachContact(fn func(FirestoreQso) error) error {
+	docItr := s.contactsCol.OrderBy("timeOn", firestore.Asc).Documents(s.ctx)
+	defer docItr.Stop()
+	for {
+		qsoDoc, err := docItr.Next()
+		if errors.Is(err, iterator.Done) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		firestoreQso, err := ParseFirestoreQso(qsoDoc)
+		if err != nil {
+			log.Printf("Skipping qso %v: unmarshaling error: %v", qsoDoc.Ref.ID, err)
+			continue
+		}
+		err = fn(firestoreQso)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *firestoreQsoStore) GetContact(id string) (FirestoreQso, error) {
+	snapshot, err := s.contactsCol.Doc(id).Get(s.ctx)
+	if err != nil {
+		return FirestoreQso{}, err
+	}
+	return ParseFirestoreQso(snapshot)
+}
+
+func (s *firestoreQsoStore) Create(qso *adifpb.Qso) error {
+	buf, err := qsoToJSON(qso)
+	if err != nil {
+		log.Printf("Problem unmarshaling for create: %v", err)
+		return err
+	}
+	_, err = s.contactsCol.NewDoc().Create(s.ctx, buf)
+	if err != nil {
+		log.Printf("Problem creating: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *firestoreQsoStore) Update(qso FirestoreQso) error {
+	buf, err := qsoToJSON(qso.qsopb)
+	if err != nil {
+		log.Printf("Problem unmarshaling for update: %v", err)
+		return err
+	}
+	_, err = s.docRef(qso).Set(s.ctx, buf)
+	if err != nil {
+		log.Printf("Problem updating: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *firestoreQsoStore) Delete(qso FirestoreQso) error {
+	_, err := s.docRef(qso).Delete(s.ctx)
+	if err != nil {
+		log.Printf("Problem deleting: %v", err)
+		return err
+	}
+	return nil
+}
+
+// WriteAll sends the writes through a BulkWriter, which batches them and limits how many requests
+// are in flight at once.
+func (s *firestoreQsoStore) WriteAll(creates []*adifpb.Qso, updates []FirestoreQso) []WriteFailure {
+	type pendingWrite struct {
+		job   *firestore.BulkWriterJob
+		docID string
+		qso   *adifpb.Qso
+	}
+	var pending []pendingWrite
+	var failures []WriteFailure
+	bw := s.client.BulkWriter(s.ctx)
+	enqueue := func(doc *firestore.DocumentRef, qso *adifpb.Qso, isCreate bool) {
+		buf, err := qsoToJSON(qso)
+		var job *firestore.BulkWriterJob
+		if err == nil {
+			if isCreate {
+				job, err = bw.Create(doc, buf)
+			} else {
+				job, err = bw.Set(doc, buf)
+			}
+		}
+		if err != nil {
+			failures = append(failures, newWriteFailure(doc.ID, qso, err))
+			return
+		}
+		pending = append(pending, pendingWrite{job, doc.ID, qso})
+	}
+	for _, qso := range creates {
+		enqueue(s.contactsCol.NewDoc(), qso, true)
+	}
+	for _, qso := range updates {
+		enqueue(s.docRef(qso), qso.qsopb, false)
+	}
+	bw.End()
+
+	for _, p := range pending {
+		_, err := p.job.Results()
+		if err != nil {
+			log.Printf("Problem writing %v: %v", p.docID, err)
+			failures = append(failures, newWriteFailure(p.docID, p.qso, err))
+		}
+	}
+	return failures
+}
+
+func (s *firestoreQsoStore) docRef(qso FirestoreQso) *firestore.DocumentRef {
+	if qso.docref != nil {
+		return qso.docref
+	}
+	return s.contactsCol.Doc(qso.id)
+}
+
+func (s *firestoreQsoStore) GetLogbookProperty(key string) (string, error) {
+	return getDocProperty(s.ctx, s.logbookDoc, key)
+}
+
+func (s *firestoreQsoStore) SetLogbookProperty(key string, value string) error {
+	_, err := s.logbookDoc.Update(s.ctx, []firestore.Update{{Path: key, Value: value}})
+	return err
+}
+
+func (s *firestoreQsoStore) CreateImportJob(job *ImportJob) (string, error) {
+	doc := s.jobsCol.NewDoc()
+	_, err := doc.Create(s.ctx, job)
+	if err != nil {
+		return "", err
+	}
+	return doc.ID, nil
+}
+
+func (s *firestoreQsoStore) GetImportJob(id string) (*ImportJob, error) {
+	snapshot, err := s.jobsCol.Doc(id).Get(s.ctx)
+	if err != nil {
+		return nil, err
+	}
+	var job ImportJob
+	err = snapshot.DataTo(&job)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (s *firestoreQsoStore) SaveImportJob(id string, job *ImportJob) error {
+	_, err := s.jobsCol.Doc(id).Set(s.ctx, job)
+	return err
+}
+
+// AddImportJobKeys stores each batch of keys in its own document under the job, so the job's
+// document doesn't grow with them.
+func (s *firestoreQsoStore) AddImportJobKeys(id string, keys []string) error {
+	_, err := s.jobsCol.Doc(id).Collection("mergedKeys").NewDoc().Create(s.ctx, importJobKeys{keys})
+	return err
+}
+
+func (s *firestoreQsoStore) GetImportJobKeys(id string) (map[string]bool, error) {
+	docItr := s.jobsCol.Doc(id).Collection("mergedKeys").Documents(s.ctx)
+	defer docItr.Stop()
+	keys := map[string]bool{}
+	for {
+		doc, err := docItr.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var batch importJobKeys
+		err = doc.DataTo(&batch)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range batch.Keys {
+			keys[key] = true
+		}
+	}
+	return keys, nil
+}
+
+type importJobKeys struct {
+	Keys []string `firestore:"keys"`
+}
+
+func getDocProperty(ctx context.Context, doc *firestore.DocumentRef, key string) (string, error) {
+	// This could be memoized, but I think the Firestore client does that anyway
+	docSnapshot, err := doc.Get(ctx)
+	if err != nil {
+		return "", err
+	}
+	if !docSnapshot.Exists() {
+		return "", nil
+	}
+	return fmt.Sprint(docSnapshot.Data()[key]), nil
+}
@@ -0,0 +1,180 @@
+package forester
+
+import (
+	"fmt"
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	"google.golang.org/protobuf/proto"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// MemoryQsoStore is a QsoStore which keeps everything in memory. It stores copies, so callers
+// can't accidentally modify stored contacts without calling Update.
+type MemoryQsoStore struct {
+	mu         sync.Mutex
+	nextID     int
+	contacts   map[string]*adifpb.Qso
+	properties map[string]string
+	jobs       map[string]ImportJob
+	jobKeys    map[string]map[string]bool
+}
+
+// NewMemoryQsoStore makes an empty MemoryQsoStore.
+func NewMemoryQsoStore() *MemoryQsoStore {
+	return &MemoryQsoStore{
+		contacts:   map[string]*adifpb.Qso{},
+		properties: map[string]string{},
+		jobs:       map[string]ImportJob{},
+		jobKeys:    map[string]map[string]bool{},
+	}
+}
+
+func (s *MemoryQsoStore) GetContacts() ([]FirestoreQso, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0, len(s.contacts))
+	for id := range s.contacts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	retval := make([]FirestoreQso, 0, len(ids))
+	for _, id := range ids {
+		retval = append(retval, FirestoreQso{qsopb: cloneQso(s.contacts[id]), id: id})
+	}
+	return retval, nil
+}
+
+func (s *MemoryQsoStore) EachContact(fn func(FirestoreQso) error) error {
+	contacts, _ := s.GetContacts()
+	sort.SliceStable(contacts, func(i, j int) bool {
+		return contacts[i].qsopb.TimeOn.AsTime().Before(contacts[j].qsopb.TimeOn.AsTime())
+	})
+	for _, contact := range contacts {
+		if contact.qsopb.TimeOn == nil {
+			continue
+		}
+		err := fn(contact)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryQsoStore) GetContact(id string) (FirestoreQso, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	qso, ok := s.contacts[id]
+	if !ok {
+		return FirestoreQso{}, fmt.Errorf("no contact with ID %v", id)
+	}
+	return FirestoreQso{qsopb: cloneQso(qso), id: id}, nil
+}
+
+func (s *MemoryQsoStore) Create(qso *adifpb.Qso) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	s.contacts[strconv.Itoa(s.nextID)] = cloneQso(qso)
+	return nil
+}
+
+func (s *MemoryQsoStore) Update(qso FirestoreQso) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.contacts[qso.id]; !ok {
+		return fmt.Errorf("no contact with ID %v", qso.id)
+	}
+	s.contacts[qso.id] = cloneQso(qso.qsopb)
+	return nil
+}
+
+func (s *MemoryQsoStore) Delete(qso FirestoreQso) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.contacts[qso.id]; !ok {
+		return fmt.Errorf("no contact with ID %v", qso.id)
+	}
+	delete(s.contacts, qso.id)
+	return nil
+}
+
+func (s *MemoryQsoStore) WriteAll(creates []*adifpb.Qso, updates []FirestoreQso) []WriteFailure {
+	return writeEach(s, creates, updates)
+}
+
+func (s *MemoryQsoStore) GetLogbookProperty(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.properties[key]
+	if !ok {
+		return "<nil>", nil
+	}
+	return value, nil
+}
+
+func (s *MemoryQsoStore) SetLogbookProperty(key string, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.properties[key] = value
+	return nil
+}
+
+func (s *MemoryQsoStore) CreateImportJob(job *ImportJob) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	id := "job" + strconv.Itoa(s.nextID)
+	s.jobs[id] = *job
+	return id, nil
+}
+
+func (s *MemoryQsoStore) GetImportJob(id string) (*ImportJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("no import job with ID %v", id)
+	}
+	return &job, nil
+}
+
+func (s *MemoryQsoStore) SaveImportJob(id string, job *ImportJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.jobs[id]; !ok {
+		return fmt.Errorf("no import job with ID %v", id)
+	}
+	s.jobs[id] = *job
+	return nil
+}
+
+func (s *MemoryQsoStore) AddImportJobKeys(id string, keys []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.jobs[id]; !ok {
+		return fmt.Errorf("no import job with ID %v", id)
+	}
+	if s.jobKeys[id] == nil {
+		s.jobKeys[id] = map[string]bool{}
+	}
+	for _, key := range keys {
+		s.jobKeys[id][key] = true
+	}
+	return nil
+}
+
+func (s *MemoryQsoStore) GetImportJobKeys(id string) (map[string]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make(map[string]bool, len(s.jobKeys[id]))
+	for key := range s.jobKeys[id] {
+		keys[key] = true
+	}
+	return keys, nil
+}
+
+func cloneQso(qso *adifpb.Qso) *adifpb.Qso {
+	return proto.Clone(qso).(*adifpb.Qso)
+}
@@ -0,0 +1,190 @@
+package forester
+
+import (
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/pubsub"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// autoSyncProperty is the logbook property which opts the logbook into scheduled syncs.
+const autoSyncProperty = "autoSync"
+
+// autoSyncLastRunProperty records when a scheduled sync last queued imports for the logbook.
+const autoSyncLastRunProperty = "autoSyncLastRun"
+
+// syncLogbooksPerRun limits how many logbooks each scheduled run syncs, so the providers aren't
+// sent too many requests at once. The logbooks synced longest ago go first, so every logbook gets a
+// turn over several runs.
+const syncLogbooksPerRun = 10
+
+// autoSyncStaleAfter is how long a scheduled import can go without progress before it's taken to be
+// abandoned, and another is queued in its place.
+const autoSyncStaleAfter = 24 * time.Hour
+
+// autoSyncSources are the remote logbooks which scheduled syncs import from.
+var autoSyncSources = []ImportSource{SourceLotw, SourceQrz}
+
+// autoSyncOutcome is the outcome of a scheduled import, stored on the logbook as JSON.
+type autoSyncOutcome struct {
+	JobID      string   `json:"jobId"`
+	Status     string   `json:"status"`
+	Created    int      `json:"created"`
+	Modified   int      `json:"modified"`
+	NoDiff     int      `json:"noDiff"`
+	Failed     int      `json:"failed"`
+	Errors     []string `json:"errors,omitempty"`
+	FinishedAt string   `json:"finishedAt"`
+}
+
+// ScheduledSync listens to Pub/Sub for ticks from Cloud Scheduler, and queues LotW and QRZ.com
+// import jobs for logbooks with automatic sync turned on. The jobs run under the service
+// credentials, like any other import job.
+func ScheduledSync(ctx context.Context, _ pubsub.Message) error {
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	docs, err := client.Collection("logbooks").Where(autoSyncProperty, "==", true).Documents(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+	lastRuns := make(map[string]string, len(docs))
+	for _, doc := range docs {
+		lastRun, _ := doc.Data()[autoSyncLastRunProperty].(string)
+		lastRuns[doc.Ref.ID] = lastRun
+	}
+	logbookIDs := pickLogbooksToSync(lastRuns, syncLogbooksPerRun)
+	log.Printf("Syncing %d of %d logbooks", len(logbookIDs), len(lastRuns))
+	for _, logbookID := range logbookIDs {
+		store := NewFirestoreQsoStore(ctx, client, logbookID)
+		err := queueAutoSync(ctx, store, logbookID)
+		if err != nil {
+			log.Printf("Couldn't sync logbook %v: %v", logbookID, err)
+		}
+	}
+	return nil
+}
+
+// pickLogbooksToSync picks up to limit logbooks, the ones synced longest ago first. lastRuns maps
+// logbook ID to the RFC 3339 time of its last sync, or "" if it was never synced.
+func pickLogbooksToSync(lastRuns map[string]string, limit int) []string {
+	ids := make([]string, 0, len(lastRuns))
+	for id := range lastRuns {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if lastRuns[ids[i]] != lastRuns[ids[j]] {
+			return lastRuns[ids[i]] < lastRuns[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids
+}
+
+// queueAutoSync queues an import job for each remote logbook which the logbook has credentials for,
+// unless the last scheduled job for it hasn't finished yet.
+func queueAutoSync(ctx context.Context, store QsoStore, logbookID string) error {
+	for _, source := range autoSyncSources {
+		lastSet, err := store.GetLogbookProperty(importSpecs[source].credentialKey + "_last_set")
+		if err != nil {
+			return err
+		}
+		if lastSet == "<nil>" {
+			log.Printf("Logbook %v has no %v credentials; not syncing it", logbookID, source)
+			continue
+		}
+		pending, err := pendingAutoSyncJob(store, source)
+		if err != nil {
+			return err
+		}
+		if pending != "" {
+			log.Printf("Logbook %v's %v import job %v hasn't finished; not syncing it", logbookID, source, pending)
+			continue
+		}
+		jobID, err := queueImportJob(ctx, store, logbookID, &ImportJob{Source: source, Scheduled: true})
+		if err != nil {
+			return err
+		}
+		log.Printf("Queued %v import job %v for logbook %v", source, jobID, logbookID)
+		err = store.SetLogbookProperty(autoSyncJobProperty(source), jobID)
+		if err != nil {
+			return err
+		}
+	}
+	return store.SetLogbookProperty(autoSyncLastRunProperty, time.Now().UTC().Format(time.RFC3339))
+}
+
+// pendingAutoSyncJob returns the ID of the last scheduled import job from the source if it's still
+// queued or running, or "" if it isn't.
+func pendingAutoSyncJob(store QsoStore, source ImportSource) (string, error) {
+	jobID, err := store.GetLogbookProperty(autoSyncJobProperty(source))
+	if err != nil || jobID == "<nil>" || jobID == "" {
+		return "", err
+	}
+	job, err := store.GetImportJob(jobID)
+	if err != nil {
+		// Don't let a job which can't be read hold up syncing for good
+		log.Printf("Couldn't read %v import job %v: %v", source, jobID, err)
+		return "", nil
+	}
+	if job.Status == jobDone || job.Status == jobFailed || time.Since(job.UpdatedAt) > autoSyncStaleAfter {
+		return "", nil
+	}
+	return jobID, nil
+}
+
+// recordAutoSyncOutcome stores how a scheduled import went on the logbook, e.g. in the
+// autoSyncLotwResult property. Other imports aren't recorded.
+func recordAutoSyncOutcome(store QsoStore, jobID string, job *ImportJob) error {
+	if !job.Scheduled {
+		return nil
+	}
+	outcome, err := json.Marshal(autoSyncOutcome{
+		JobID:      jobID,
+		Status:     job.Status,
+		Created:    job.Result.Created,
+		Modified:   job.Result.Modified,
+		NoDiff:     job.Result.NoDiff,
+		Failed:     job.Result.failed(),
+		Errors:     job.Errors,
+		FinishedAt: time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+	return store.SetLogbookProperty(autoSyncResultProperty(job.Source), string(outcome))
+}
+
+// autoSyncJobProperty is the logbook property holding the ID of the last scheduled import job from
+// the source, e.g. autoSyncLotwJob.
+func autoSyncJobProperty(source ImportSource) string {
+	switch source {
+	case SourceQrz:
+		return "autoSyncQrzJob"
+	case SourceLotw:
+		return "autoSyncLotwJob"
+	default:
+		return fmt.Sprintf("autoSync%vJob", source)
+	}
+}
+
+func autoSyncResultProperty(source ImportSource) string {
+	switch source {
+	case SourceQrz:
+		return "autoSyncQrzResult"
+	case SourceLotw:
+		return "autoSyncLotwResult"
+	default:
+		return fmt.Sprintf("autoSync%vResult", source)
+	}
+}
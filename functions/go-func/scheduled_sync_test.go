@@ -0,0 +1,71 @@
+package forester
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func Test_pickLogbooksToSync(t *testing.T) {
+	lastRuns := map[string]string{
+		"K0SWE":  "2020-11-01T06:00:00Z",
+		"KE0RCW": "",
+		"N6DN":   "2020-11-01T00:00:00Z",
+		"K9IJ":   "2020-11-01T12:00:00Z",
+	}
+	got := pickLogbooksToSync(lastRuns, 3)
+	want := []string{"KE0RCW", "N6DN", "K0SWE"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("pickLogbooksToSync() got = %v, want %v", got, want)
+	}
+}
+
+func Test_queueAutoSync(t *testing.T) {
+	fakeImportSource(t, remoteContacts("N6DN"), nil)
+	var published []string
+	publishImportJob = func(_ context.Context, _ string, jobID string) error {
+		published = append(published, jobID)
+		return nil
+	}
+	store := NewMemoryQsoStore()
+	_ = store.SetLogbookProperty(qrzLogbookAPIKey+"_last_set", "2020-11-01T00:00:00Z")
+
+	err := queueAutoSync(context.Background(), store, "K0SWE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only QRZ.com has credentials
+	if len(published) != 1 {
+		t.Fatalf("queueAutoSync() published %v, want 1 job", published)
+	}
+	if lastRun, _ := store.GetLogbookProperty(autoSyncLastRunProperty); lastRun == "<nil>" {
+		t.Errorf("queueAutoSync() didn't record the last run")
+	}
+
+	// The job hasn't run yet, so the next sync doesn't queue another
+	err = queueAutoSync(context.Background(), store, "K0SWE")
+	if err != nil || len(published) != 1 {
+		t.Fatalf("queueAutoSync() again got = %v, published %v, want 1 job", err, published)
+	}
+
+	err = runImportJob(context.Background(), store, "K0SWE", published[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	prop, _ := store.GetLogbookProperty("autoSyncQrzResult")
+	var outcome autoSyncOutcome
+	err = json.Unmarshal([]byte(prop), &outcome)
+	if err != nil {
+		t.Fatalf("autoSyncQrzResult got = %q: %v", prop, err)
+	}
+	if outcome.JobID != published[0] || outcome.Status != jobDone || outcome.Created != 1 {
+		t.Errorf("autoSyncQrzResult got = %+v", outcome)
+	}
+
+	// Now that it's done, the next sync queues another
+	err = queueAutoSync(context.Background(), store, "K0SWE")
+	if err != nil || len(published) != 2 {
+		t.Errorf("queueAutoSync() after the job got = %v, published %v, want 2 jobs", err, published)
+	}
+}
@@ -12,6 +12,15 @@ const lotwPassword = "lotw_password"
 const qrzUsername = "qrz_username"
 const qrzPassword = "qrz_password"
 const qrzLogbookAPIKey = "qrz_logbook_api_key"
+const eqslUsername = "eqsl_username"
+const eqslPassword = "eqsl_password"
+const clublogEmail = "clublog_email"
+const clublogPassword = "clublog_password"
+const clublogCallsign = "clublog_callsign"
+const clublogAPIKey = "clublog_api_key"
+
+// appSecretScope is used in place of a logbook ID for secrets which belong to the application.
+const appSecretScope = "forester"
 
 type SecretStore struct {
 	ctx    context.Context
@@ -3,14 +3,16 @@ package forester
 import (
 	"cloud.google.com/go/pubsub"
 	"context"
+	"errors"
 )
 
 // SyncNewQso listens to Pub/Sub for new contacts in Firestore, fills missing details from the
-// QRZ.com database, and uploads them to the QRZ.com Logbook.
+// QRZ.com database, and uploads them to the QRZ.com Logbook and Club Log. Each step runs even if
+// another fails, so one service being down doesn't hold up the others.
 func SyncNewQso(ctx context.Context, m pubsub.Message) error {
-	err := FillNewQsoFromQrz(ctx, m)
-	if err != nil {
-		return err
-	}
-	return UploadNewQsoToQrz(ctx, m)
+	return errors.Join(
+		FillNewQsoFromQrz(ctx, m),
+		UploadNewQsoToQrz(ctx, m),
+		UploadNewQsoToClublog(ctx, m),
+	)
 }
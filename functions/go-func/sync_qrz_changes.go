@@ -0,0 +1,230 @@
+package forester
+
+import (
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/pubsub"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	qrzlog "github.com/k0swe/qrz-logbook"
+	"golang.org/x/time/rate"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"log"
+	"strings"
+	"time"
+)
+
+// qrzSyncedHashKey records a hash of the contact as it was last sent to QRZ.com.
+const qrzSyncedHashKey = "app_forester_qrzlog_synced_hash"
+
+// qrzDelete is a var so tests can fake QRZ.com.
+var qrzDelete = qrzlog.Delete
+
+// qrzReplaceLimiter spaces out REPLACE calls, so a burst of edits doesn't flood QRZ.com. The
+// function is deployed with a single instance, so this limits them all.
+var qrzReplaceLimiter = rate.NewLimiter(rate.Every(time.Second), 5)
+
+// UploadUpdatedQsoToQrz listens to Pub/Sub for edited contacts in Firestore, and replaces the
+// matching record in the QRZ.com Logbook. Contacts which were never uploaded are left alone.
+func UploadUpdatedQsoToQrz(ctx context.Context, m pubsub.Message) error {
+	var psMap map[string]string
+	err := json.Unmarshal(m.Data, &psMap)
+	if err != nil {
+		return err
+	}
+	logbookID := psMap["logbookId"]
+	contactID := psMap["contactId"]
+	firebasePath := fmt.Sprintf("logbooks/%s/contacts/%s", logbookID, contactID)
+	log.Printf("Got an updated Firebase QSO at path %s", firebasePath)
+
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	store := NewFirestoreQsoStore(ctx, client, logbookID)
+	return replaceQsoInQrz(ctx, store, func() (string, error) {
+		secretStore := NewSecretStore(ctx)
+		return secretStore.FetchSecret(logbookID, qrzLogbookAPIKey)
+	}, contactID)
+}
+
+// DeleteQsoFromQrz listens to Pub/Sub for deleted contacts in Firestore, and deletes the matching
+// record from the QRZ.com Logbook. The contact is already gone, so the message carries its QRZ.com
+// log ID and there is no upload status left to update.
+func DeleteQsoFromQrz(ctx context.Context, m pubsub.Message) error {
+	var psMap map[string]string
+	err := json.Unmarshal(m.Data, &psMap)
+	if err != nil {
+		return err
+	}
+	logbookID := psMap["logbookId"]
+	contactID := psMap["contactId"]
+	qrzLogID := psMap["qrzLogId"]
+	firebasePath := fmt.Sprintf("logbooks/%s/contacts/%s", logbookID, contactID)
+	log.Printf("Got a deleted Firebase QSO at path %s", firebasePath)
+	if qrzLogID == "" {
+		log.Printf("Contact was never uploaded to QRZ.com; nothing to delete")
+		return nil
+	}
+
+	secretStore := NewSecretStore(ctx)
+	qrzAPIKey, err := secretStore.FetchSecret(logbookID, qrzLogbookAPIKey)
+	if err != nil {
+		return err
+	}
+	return deleteQsoFromQrz(ctx, qrzAPIKey, qrzLogID)
+}
+
+// replaceQsoInQrz sends the contact to QRZ.com if it's changed since it was last sent. The API key
+// is only fetched when it will be used, so logbooks which don't sync with QRZ.com don't need one.
+// Contacts with a log ID but no synced hash were uploaded or imported before the hash was kept, so
+// their hash is recorded as they are instead of replacing them all at once.
+func replaceQsoInQrz(ctx context.Context, store QsoStore, fetchAPIKey func() (string, error), contactID string) error {
+	qso, err := store.GetContact(contactID)
+	if err != nil {
+		return err
+	}
+	if qso.qsopb.AppDefined[qrzLogIDKey] == "" {
+		log.Printf("Contact was never uploaded to QRZ.com; nothing to replace")
+		return nil
+	}
+	if qso.qsopb.Qrzcom.GetUploadStatus() == adifpb.UploadStatus_DO_NOT_UPLOAD {
+		log.Printf("Contact is marked not to upload to QRZ.com")
+		return nil
+	}
+	hash, err := qrzContentHash(qso.qsopb)
+	if err != nil {
+		return err
+	}
+	switch qso.qsopb.AppDefined[qrzSyncedHashKey] {
+	case hash:
+		log.Printf("QRZ.com already has this version of the contact")
+		return nil
+	case "":
+		log.Printf("Contact has no synced hash; recording it without replacing")
+		qso.qsopb.AppDefined[qrzSyncedHashKey] = hash
+		return store.Update(qso)
+	}
+
+	qrzAPIKey, err := fetchAPIKey()
+	if err != nil {
+		return err
+	}
+	adifProto := &adifpb.Adif{Qsos: []*adifpb.Qso{withoutUploadBookkeeping(qso.qsopb)}}
+	adif, err := protoToAdif(adifProto)
+	if err != nil {
+		return err
+	}
+
+	qso.qsopb.Qrzcom = &adifpb.Upload{
+		UploadStatus: adifpb.UploadStatus_MODIFIED_AFTER_UPLOAD,
+		UploadDate:   qso.qsopb.Qrzcom.GetUploadDate(),
+	}
+	err = store.Update(qso)
+	if err != nil {
+		return err
+	}
+
+	err = qrzReplaceLimiter.Wait(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("Replacing contact in QRZ.com...")
+	insert, err := qrzInsert(ctx, &qrzAPIKey, adif, true)
+	if err != nil {
+		return err
+	}
+	log.Printf("Replaced contact in QRZ.com")
+	logID := qso.qsopb.AppDefined[qrzLogIDKey]
+	if insert.LogId != "" {
+		logID = insert.LogId
+	}
+	return recordQrzLogID(store, qso, logID)
+}
+
+func deleteQsoFromQrz(ctx context.Context, qrzAPIKey string, qrzLogID string) error {
+	log.Printf("Deleting contact %v from QRZ.com...", qrzLogID)
+	resp, err := qrzDelete(ctx, &qrzAPIKey, []string{qrzLogID})
+	if err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "not found") {
+			// Already deleted, e.g. by an earlier delivery of this message
+			log.Printf("QRZ.com doesn't have contact %v: %v", qrzLogID, err)
+			return nil
+		}
+		return err
+	}
+	if resp.Result == "PARTIAL" {
+		return fmt.Errorf("QRZ.com didn't delete log IDs %v", resp.LogIds)
+	}
+	log.Printf("Deleted contact from QRZ.com")
+	return nil
+}
+
+// qrzContentHash hashes the parts of the contact which are sent to QRZ.com and are worth replacing
+// it for, ignoring the bookkeeping recorded about the upload itself; see clearQrzSyncNoise.
+func qrzContentHash(qso *adifpb.Qso) (string, error) {
+	stripped := withoutUploadBookkeeping(qso)
+	delete(stripped.AppDefined, qrzLogIDKey)
+	clearQrzSyncNoise(stripped)
+	buf, err := proto.MarshalOptions{Deterministic: true}.Marshal(stripped)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(buf)), nil
+}
+
+// clearQrzSyncNoise clears what changes without being worth a REPLACE: QSL and award
+// confirmations, which LoTW and eQSL imports and QSL card tracking record, and the details which
+// enrichQso works out. The functions' onUpdateContact trigger ignores the same fields.
+func clearQrzSyncNoise(qso *adifpb.Qso) {
+	qso.Lotw, qso.Eqsl, qso.Card = nil, nil, nil
+	qso.AwardGranted, qso.CreditGranted = nil, nil
+	for k := range qso.AppDefined {
+		if strings.HasPrefix(k, "app_lotw_") || strings.HasPrefix(k, "app_eqsl_") {
+			delete(qso.AppDefined, k)
+		}
+	}
+	qso.DistanceKm = 0
+	for _, station := range []*adifpb.Station{qso.LoggingStation, qso.ContactedStation} {
+		if station != nil {
+			station.Latitude, station.Longitude = 0, 0
+		}
+	}
+	if c := qso.ContactedStation; c != nil {
+		c.Dxcc, c.CqZone, c.ItuZone = 0, 0, 0
+		c.Country, c.Continent = "", ""
+	}
+}
+
+// seedQrzSyncedHash records that QRZ.com has the current version of a contact which was just merged
+// from it, so the update doesn't send QRZ.com's own data back. Contacts which aren't in QRZ.com are
+// left alone.
+func seedQrzSyncedHash(qso *adifpb.Qso) {
+	if qso.AppDefined[qrzLogIDKey] == "" {
+		return
+	}
+	hash, err := qrzContentHash(qso)
+	if err != nil {
+		log.Printf("Couldn't hash contact: %v", err)
+		return
+	}
+	qso.AppDefined[qrzSyncedHashKey] = hash
+}
+
+// markQrzSynced records that QRZ.com has the current version of the contact.
+func markQrzSynced(qso *adifpb.Qso) {
+	hash, err := qrzContentHash(qso)
+	if err != nil {
+		log.Printf("Couldn't hash contact: %v", err)
+		return
+	}
+	qso.AppDefined[qrzSyncedHashKey] = hash
+	qso.Qrzcom = &adifpb.Upload{
+		UploadStatus: adifpb.UploadStatus_UPLOAD_COMPLETE,
+		UploadDate:   timestamppb.Now(),
+	}
+}
@@ -0,0 +1,137 @@
+package forester
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	qrzlog "github.com/k0swe/qrz-logbook"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func Test_replaceQsoInQrz(t *testing.T) {
+	var replaced []string
+	originalInsert := qrzInsert
+	defer func() { qrzInsert = originalInsert }()
+	qrzInsert = func(ctx context.Context, key *string, adif string, replace bool) (*qrzlog.InsertResponse, error) {
+		if !replace {
+			t.Errorf("qrzInsert() called without REPLACE")
+		}
+		replaced = append(replaced, adif)
+		return &qrzlog.InsertResponse{Result: "REPLACE", LogId: "488692380", Count: 1}, nil
+	}
+
+	store := NewMemoryQsoStore()
+	_ = store.Create(&adifpb.Qso{
+		TimeOn:           timestamppb.New(time.Date(2020, 3, 29, 0, 34, 0, 0, time.UTC)),
+		LoggingStation:   &adifpb.Station{StationCall: "K0SWE"},
+		ContactedStation: &adifpb.Station{StationCall: "KK9A"},
+	})
+	_ = store.Create(&adifpb.Qso{
+		Band:             "20m",
+		TimeOn:           timestamppb.New(time.Date(2020, 3, 29, 0, 34, 0, 0, time.UTC)),
+		LoggingStation:   &adifpb.Station{StationCall: "K0SWE"},
+		ContactedStation: &adifpb.Station{StationCall: "KK9A"},
+		AppDefined:       map[string]string{qrzLogIDKey: "488692380"},
+		Qrzcom:           &adifpb.Upload{UploadStatus: adifpb.UploadStatus_UPLOAD_COMPLETE},
+	})
+	contacts, _ := store.GetContacts()
+	neverUploaded, uploaded := contacts[0].id, contacts[1].id
+	keyFetches := 0
+	fetchAPIKey := func() (string, error) {
+		keyFetches++
+		return "key", nil
+	}
+
+	err := replaceQsoInQrz(context.Background(), store, fetchAPIKey, neverUploaded)
+	if err != nil || len(replaced) != 0 || keyFetches != 0 {
+		t.Fatalf("replaceQsoInQrz() of never-uploaded contact err = %v, replaced %d, fetched key %d times",
+			err, len(replaced), keyFetches)
+	}
+
+	// A contact uploaded before the hash was kept only has its hash recorded
+	err = replaceQsoInQrz(context.Background(), store, fetchAPIKey, uploaded)
+	got, _ := store.GetContact(uploaded)
+	if err != nil || len(replaced) != 0 || got.qsopb.AppDefined[qrzSyncedHashKey] == "" {
+		t.Fatalf("replaceQsoInQrz() of legacy contact err = %v, replaced %d, contact %v", err, len(replaced), got.qsopb)
+	}
+
+	// Redelivery, or a write which only touched bookkeeping, confirmations or worked out details,
+	// shouldn't replace
+	got.qsopb.Lotw = &adifpb.Qsl{ReceivedStatus: "Y"}
+	got.qsopb.ContactedStation.Dxcc = 291
+	got.qsopb.Qrzcom = &adifpb.Upload{UploadStatus: adifpb.UploadStatus_MODIFIED_AFTER_UPLOAD}
+	_ = store.Update(got)
+	err = replaceQsoInQrz(context.Background(), store, fetchAPIKey, uploaded)
+	if err != nil || len(replaced) != 0 || keyFetches != 0 {
+		t.Errorf("replaceQsoInQrz() of unchanged contact err = %v, replaced %d", err, len(replaced))
+	}
+
+	got.qsopb.Band = "40m"
+	_ = store.Update(got)
+	err = replaceQsoInQrz(context.Background(), store, fetchAPIKey, uploaded)
+	if err != nil || len(replaced) != 1 || keyFetches != 1 {
+		t.Errorf("replaceQsoInQrz() of edited contact err = %v, replaced %d", err, len(replaced))
+	}
+	got, _ = store.GetContact(uploaded)
+	if got.qsopb.Qrzcom.GetUploadStatus() != adifpb.UploadStatus_UPLOAD_COMPLETE {
+		t.Errorf("replaceQsoInQrz() contact got = %v", got.qsopb)
+	}
+}
+
+func Test_MergeQsos_seedsQrzSyncedHash(t *testing.T) {
+	local := &adifpb.Qso{
+		Band:             "20m",
+		TimeOn:           timestamppb.New(time.Date(2020, 3, 29, 0, 34, 0, 0, time.UTC)),
+		LoggingStation:   &adifpb.Station{StationCall: "K0SWE"},
+		ContactedStation: &adifpb.Station{StationCall: "KK9A"},
+		AppDefined:       map[string]string{qrzLogIDKey: "488692380"},
+	}
+	remote := cloneQso(local)
+	remote.ContactedStation.OpName = "John"
+	for _, tt := range []struct {
+		source   ImportSource
+		wantHash bool
+	}{{SourceQrz, true}, {SourceLotw, false}} {
+		t.Run(string(tt.source), func(t *testing.T) {
+			store := NewMemoryQsoStore()
+			_ = store.Create(cloneQso(local))
+			contacts, _ := store.GetContacts()
+			MergeQsos(store, tt.source, contacts, &adifpb.Adif{Qsos: []*adifpb.Qso{cloneQso(remote)}})
+			got, _ := store.GetContact(contacts[0].id)
+			hash, _ := qrzContentHash(got.qsopb)
+			if (got.qsopb.AppDefined[qrzSyncedHashKey] == hash) != tt.wantHash {
+				t.Errorf("MergeQsos() synced hash got = %q, want it set %v", got.qsopb.AppDefined[qrzSyncedHashKey], tt.wantHash)
+			}
+		})
+	}
+}
+
+func Test_deleteQsoFromQrz(t *testing.T) {
+	originalDelete := qrzDelete
+	defer func() { qrzDelete = originalDelete }()
+	tests := []struct {
+		name    string
+		resp    *qrzlog.DeleteResponse
+		err     error
+		wantErr bool
+	}{
+		{name: "deleted", resp: &qrzlog.DeleteResponse{Result: "OK", Count: 1}},
+		{name: "already gone", resp: &qrzlog.DeleteResponse{Result: "FAIL"}, err: errors.New("logid not found")},
+		{name: "partial", resp: &qrzlog.DeleteResponse{Result: "PARTIAL", LogIds: []string{"1"}}, wantErr: true},
+		{name: "auth", resp: &qrzlog.DeleteResponse{Result: "FAIL"}, err: errors.New("invalid api key"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qrzDelete = func(ctx context.Context, key *string, ids []string) (*qrzlog.DeleteResponse, error) {
+				return tt.resp, tt.err
+			}
+			err := deleteQsoFromQrz(context.Background(), "key", "1")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("deleteQsoFromQrz() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -32,7 +32,14 @@ func UpdateSecret(w http.ResponseWriter, r *http.Request) {
 	qrzUser := r.PostFormValue(qrzUsername)
 	qrzPass := r.PostFormValue(qrzPassword)
 	qrzKey := r.PostFormValue(qrzLogbookAPIKey)
-	if lotwUser == "" && lotwPass == "" && qrzUser == "" && qrzPass == "" && qrzKey == "" {
+	eqslUser := r.PostFormValue(eqslUsername)
+	eqslPass := r.PostFormValue(eqslPassword)
+	clublogMail := r.PostFormValue(clublogEmail)
+	clublogPass := r.PostFormValue(clublogPassword)
+	clublogCall := r.PostFormValue(clublogCallsign)
+	if lotwUser == "" && lotwPass == "" && qrzUser == "" && qrzPass == "" && qrzKey == "" &&
+		eqslUser == "" && eqslPass == "" &&
+		clublogMail == "" && clublogPass == "" && clublogCall == "" {
 		log.Print("Nothing to do")
 		w.WriteHeader(204)
 		return
@@ -79,6 +86,47 @@ func UpdateSecret(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	if eqslUser != "" {
+		log.Printf("Updating %v", eqslUsername)
+		_, err = checkAndSetSecret(secretStore, fb, eqslUsername, eqslUser)
+		if err != nil {
+			writeError(500, "Error storing a secret", err, w)
+			return
+		}
+	}
+	if eqslPass != "" {
+		log.Printf("Updating %v", eqslPassword)
+		_, err = checkAndSetSecret(secretStore, fb, eqslPassword, eqslPass)
+		if err != nil {
+			writeError(500, "Error storing a secret", err, w)
+			return
+		}
+	}
+	if clublogPass != "" {
+		log.Printf("Updating %v", clublogPassword)
+		_, err = checkAndSetSecret(secretStore, fb, clublogPassword, clublogPass)
+		if err != nil {
+			writeError(500, "Error storing a secret", err, w)
+			return
+		}
+	}
+	if clublogCall != "" {
+		log.Printf("Updating %v", clublogCallsign)
+		_, err = checkAndSetSecret(secretStore, fb, clublogCallsign, clublogCall)
+		if err != nil {
+			writeError(500, "Error storing a secret", err, w)
+			return
+		}
+	}
+	if clublogMail != "" {
+		// Stored last; its _last_set property is what enables Club Log uploads
+		log.Printf("Updating %v", clublogEmail)
+		_, err = checkAndSetSecret(secretStore, fb, clublogEmail, clublogMail)
+		if err != nil {
+			writeError(500, "Error storing a secret", err, w)
+			return
+		}
+	}
 	w.WriteHeader(204)
 }
 
@@ -0,0 +1,158 @@
+package forester
+
+import (
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/pubsub"
+	"context"
+	"encoding/json"
+	"fmt"
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// clublogBaseURL is a var so tests can point it at a fake Club Log server.
+var clublogBaseURL = "https://clublog.org/"
+
+type clublogCreds struct {
+	apiKey   string
+	email    string
+	password string
+	callsign string
+}
+
+// UploadNewQsoToClublog listens to Pub/Sub for new contacts in Firestore, and uploads them to
+// Club Log with its real-time API.
+func UploadNewQsoToClublog(ctx context.Context, m pubsub.Message) error {
+	var psMap map[string]string
+	err := json.Unmarshal(m.Data, &psMap)
+	if err != nil {
+		return err
+	}
+	logbookID := psMap["logbookId"]
+	contactID := psMap["contactId"]
+	firebasePath := fmt.Sprintf("logbooks/%s/contacts/%s", logbookID, contactID)
+	log.Printf("Got a new Firebase QSO at path %s", firebasePath)
+
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	store := NewFirestoreQsoStore(ctx, client, logbookID)
+	lastSet, err := store.GetLogbookProperty(clublogEmail + "_last_set")
+	if err != nil {
+		return err
+	}
+	if lastSet == "<nil>" || lastSet == "" {
+		log.Printf("Logbook has no Club Log credentials; skipping upload")
+		return nil
+	}
+	creds, err := getClublogCreds(ctx, logbookID)
+	if err != nil {
+		return err
+	}
+	return uploadQsoToClublog(ctx, store, creds, contactID)
+}
+
+func uploadQsoToClublog(ctx context.Context, store QsoStore, creds clublogCreds, contactID string) error {
+	qso, err := store.GetContact(contactID)
+	if err != nil {
+		return err
+	}
+
+	contactedStationCall := qso.qsopb.ContactedStation.StationCall
+	if contactedStationCall == "T3ST" {
+		log.Printf("Contacted station is special value T3ST; aborting upload")
+		return nil
+	}
+	if qso.qsopb.Clublog.GetUploadStatus() == adifpb.UploadStatus_DO_NOT_UPLOAD {
+		log.Printf("Contact is marked not to upload to Club Log")
+		return nil
+	}
+	if qso.qsopb.Clublog != nil &&
+		qso.qsopb.Clublog.UploadStatus == adifpb.UploadStatus_UPLOAD_COMPLETE {
+		log.Printf("Contact was already uploaded to Club Log")
+		return nil
+	}
+
+	stripped := withoutUploadBookkeeping(qso.qsopb)
+	delete(stripped.AppDefined, qrzLogIDKey)
+	adifProto := &adifpb.Adif{Qsos: []*adifpb.Qso{stripped}}
+	adif, err := protoToAdif(adifProto)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Uploading contact to Club Log...")
+	err = clublogRealtimeUpload(ctx, creds, adif)
+	if err != nil {
+		return err
+	}
+	log.Printf("Uploaded contact to Club Log")
+
+	qso.qsopb.Clublog = &adifpb.Upload{
+		UploadStatus: adifpb.UploadStatus_UPLOAD_COMPLETE,
+		UploadDate:   timestamppb.Now(),
+	}
+	err = store.Update(qso)
+	if err != nil {
+		return err
+	}
+	log.Printf("Updated contact with Club Log upload status")
+	return nil
+}
+
+// clublogRealtimeUpload sends a single ADIF record to Club Log. Club Log responds 200 for both new
+// and duplicate QSOs, and describes any problem in the body of other responses.
+func clublogRealtimeUpload(ctx context.Context, creds clublogCreds, adif string) error {
+	form := url.Values{}
+	form.Set("email", creds.email)
+	form.Set("password", creds.password)
+	form.Set("callsign", creds.callsign)
+	form.Set("api", creds.apiKey)
+	form.Set("adif", adif)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, clublogBaseURL+"realtime.php",
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Club Log responded %v: %v", resp.Status, strings.TrimSpace(string(body)))
+	}
+	log.Printf("Club Log response: %v", strings.TrimSpace(string(body)))
+	return nil
+}
+
+func getClublogCreds(ctx context.Context, logbookID string) (clublogCreds, error) {
+	secretStore := NewSecretStore(ctx)
+	// The API key identifies this application to Club Log, so it isn't specific to any logbook
+	apiKey, err := secretStore.FetchSecret(appSecretScope, clublogAPIKey)
+	if err != nil {
+		return clublogCreds{}, err
+	}
+	email, err := secretStore.FetchSecret(logbookID, clublogEmail)
+	if err != nil {
+		return clublogCreds{}, err
+	}
+	password, err := secretStore.FetchSecret(logbookID, clublogPassword)
+	if err != nil {
+		return clublogCreds{}, err
+	}
+	callsign, err := secretStore.FetchSecret(logbookID, clublogCallsign)
+	if err != nil {
+		return clublogCreds{}, err
+	}
+	return clublogCreds{apiKey, email, password, callsign}, nil
+}
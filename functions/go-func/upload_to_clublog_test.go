@@ -0,0 +1,91 @@
+package forester
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func Test_uploadQsoToClublog(t *testing.T) {
+	var uploads []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/realtime.php" || r.PostFormValue("password") != "hunter2" ||
+			r.PostFormValue("api") != "key" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte("Login rejected"))
+			return
+		}
+		uploads = append(uploads, r.PostFormValue("adif"))
+		_, _ = w.Write([]byte("OK"))
+	}))
+	defer server.Close()
+	originalURL := clublogBaseURL
+	clublogBaseURL = server.URL + "/"
+	defer func() { clublogBaseURL = originalURL }()
+
+	store := NewMemoryQsoStore()
+	_ = store.Create(&adifpb.Qso{
+		Band:             "20m",
+		Mode:             "SSB",
+		TimeOn:           timestamppb.New(time.Date(2020, 3, 29, 0, 34, 0, 0, time.UTC)),
+		LoggingStation:   &adifpb.Station{StationCall: "K0SWE"},
+		ContactedStation: &adifpb.Station{StationCall: "KK9A"},
+		Qrzcom:           &adifpb.Upload{UploadStatus: adifpb.UploadStatus_UPLOAD_COMPLETE},
+		AppDefined:       map[string]string{qrzLogIDKey: "488692380", qrzSyncedHashKey: "abc"},
+	})
+	_ = store.Create(&adifpb.Qso{
+		Band:             "20m",
+		Mode:             "SSB",
+		TimeOn:           timestamppb.New(time.Date(2020, 3, 29, 0, 40, 0, 0, time.UTC)),
+		LoggingStation:   &adifpb.Station{StationCall: "K0SWE"},
+		ContactedStation: &adifpb.Station{StationCall: "N6DN"},
+		Clublog:          &adifpb.Upload{UploadStatus: adifpb.UploadStatus_DO_NOT_UPLOAD},
+	})
+	contacts, _ := store.GetContacts()
+	id, doNotUpload := contacts[0].id, contacts[1].id
+
+	badCreds := clublogCreds{apiKey: "key", email: "k0swe@example.com", password: "wrong", callsign: "K0SWE"}
+	err := uploadQsoToClublog(context.Background(), store, badCreds, id)
+	if err == nil {
+		t.Error("uploadQsoToClublog() with bad creds didn't fail")
+	}
+
+	creds := clublogCreds{apiKey: "key", email: "k0swe@example.com", password: "hunter2", callsign: "K0SWE"}
+	err = uploadQsoToClublog(context.Background(), store, creds, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uploads) != 1 || !strings.Contains(uploads[0], "KK9A") {
+		t.Errorf("uploadQsoToClublog() uploads got = %v", uploads)
+	}
+	for _, bookkeeping := range []string{"APP_QRZLOG_LOGID", "APP_FORESTER_", "QRZCOM_QSO_UPLOAD_STATUS"} {
+		if strings.Contains(strings.ToUpper(uploads[0]), bookkeeping) {
+			t.Errorf("uploadQsoToClublog() uploaded %v: %v", bookkeeping, uploads[0])
+		}
+	}
+	got, _ := store.GetContact(id)
+	if got.qsopb.Clublog.GetUploadStatus() != adifpb.UploadStatus_UPLOAD_COMPLETE ||
+		got.qsopb.Clublog.GetUploadDate() == nil {
+		t.Errorf("uploadQsoToClublog() status got = %v", got.qsopb.Clublog)
+	}
+
+	// Redelivery shouldn't upload again
+	err = uploadQsoToClublog(context.Background(), store, creds, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uploads) != 1 {
+		t.Errorf("uploadQsoToClublog() uploaded %d times, want 1", len(uploads))
+	}
+
+	err = uploadQsoToClublog(context.Background(), store, creds, doNotUpload)
+	if err != nil || len(uploads) != 1 {
+		t.Errorf("uploadQsoToClublog() of DO_NOT_UPLOAD contact err = %v, uploaded %d times", err, len(uploads))
+	}
+}
@@ -5,14 +5,25 @@ import (
 	"cloud.google.com/go/pubsub"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	adifpb "github.com/k0swe/adif-json-protobuf/go"
 	qrzlog "github.com/k0swe/qrz-logbook"
 	"log"
+	"strings"
+	"time"
 )
 
+const qrzLogIDKey = "app_qrzlog_logid"
+
+// qrzUploadStartedKey marks a contact whose QRZ.com upload began but wasn't recorded as finished.
+const qrzUploadStartedKey = "app_forester_qrzlog_upload_started"
+
+// qrzInsert is a var so tests can fake QRZ.com.
+var qrzInsert = qrzlog.Insert
+
 // UploadNewQsoToQrz listens to Pub/Sub for new contacts in Firestore, and uploads them to the
-// QRZ.com Logbook.
+// QRZ.com Logbook. It's safe for Pub/Sub to redeliver the message; a contact is only inserted once.
 func UploadNewQsoToQrz(ctx context.Context, m pubsub.Message) error {
 	var psMap map[string]string
 	err := json.Unmarshal(m.Data, &psMap)
@@ -29,12 +40,18 @@ func UploadNewQsoToQrz(ctx context.Context, m pubsub.Message) error {
 		return err
 	}
 	defer client.Close()
-	doc := client.Doc(firebasePath)
-	snapshot, err := doc.Get(ctx)
+	store := NewFirestoreQsoStore(ctx, client, logbookID)
+
+	secretStore := NewSecretStore(ctx)
+	qrzAPIKey, err := secretStore.FetchSecret(logbookID, qrzLogbookAPIKey)
 	if err != nil {
 		return err
 	}
-	qso, err := ParseFirestoreQso(snapshot)
+	return uploadQsoToQrz(ctx, store, qrzAPIKey, contactID)
+}
+
+func uploadQsoToQrz(ctx context.Context, store QsoStore, qrzAPIKey string, contactID string) error {
+	qso, err := store.GetContact(contactID)
 	if err != nil {
 		return err
 	}
@@ -44,35 +61,109 @@ func UploadNewQsoToQrz(ctx context.Context, m pubsub.Message) error {
 		log.Printf("Contacted station is special value T3ST; aborting upload")
 		return nil
 	}
+	if qso.qsopb.Qrzcom.GetUploadStatus() == adifpb.UploadStatus_DO_NOT_UPLOAD {
+		log.Printf("Contact is marked not to upload to QRZ.com")
+		return nil
+	}
+	if qso.qsopb.AppDefined[qrzLogIDKey] != "" {
+		log.Printf("Contact already has a QRZ.com log ID")
+		return recordQrzLogID(store, qso, qso.qsopb.AppDefined[qrzLogIDKey])
+	}
+	if qso.qsopb.AppDefined[qrzUploadStartedKey] != "" {
+		// A previous attempt may have inserted the contact before failing
+		logID, err := findQrzLogID(ctx, qrzAPIKey, qso.qsopb)
+		if err != nil {
+			return err
+		}
+		if logID != "" {
+			log.Printf("Found contact from a previous attempt in QRZ.com")
+			return recordQrzLogID(store, qso, logID)
+		}
+	}
 
-	adifProto := &adifpb.Adif{Qsos: []*adifpb.Qso{qso.qsopb}}
+	adifProto := &adifpb.Adif{Qsos: []*adifpb.Qso{withoutUploadBookkeeping(qso.qsopb)}}
 	adif, err := protoToAdif(adifProto)
 	if err != nil {
 		return err
 	}
 
-	secretStore := NewSecretStore(ctx)
-	qrzAPIKey, err := secretStore.FetchSecret(logbookID, qrzLogbookAPIKey)
+	if qso.qsopb.AppDefined == nil {
+		qso.qsopb.AppDefined = map[string]string{}
+	}
+	qso.qsopb.AppDefined[qrzUploadStartedKey] = time.Now().UTC().Format(time.RFC3339)
+	err = store.Update(qso)
 	if err != nil {
 		return err
 	}
 
 	log.Printf("Uploading contact to QRZ.com...")
-	insert, err := qrzlog.Insert(ctx, &qrzAPIKey, adif, false)
+	var logID string
+	insert, err := qrzInsert(ctx, &qrzAPIKey, adif, false)
 	if err != nil {
-		return err
+		if !strings.Contains(strings.ToLower(err.Error()), "duplicate") {
+			return err
+		}
+		log.Printf("QRZ.com already has this contact: %v", err)
+		logID, err = findQrzLogID(ctx, qrzAPIKey, qso.qsopb)
+		if err != nil {
+			return err
+		}
+		if logID == "" {
+			return errors.New("QRZ.com reported a duplicate, but the contact wasn't found")
+		}
+	} else {
+		logID = insert.LogId
+		log.Printf("Uploaded contact to QRZ.com")
 	}
-	log.Printf("Uploaded contact to QRZ.com")
+	return recordQrzLogID(store, qso, logID)
+}
 
+// findQrzLogID looks for the given contact in the QRZ.com logbook, returning its log ID or "" if
+// it's not there.
+func findQrzLogID(ctx context.Context, qrzAPIKey string, qso *adifpb.Qso) (string, error) {
+	qrzAdif, err := qrzFetchAll(ctx, qrzAPIKey, "CALL:"+qso.ContactedStation.StationCall)
+	if err != nil {
+		return "", err
+	}
+	qrzAdi, _, err := adifToProto(qrzAdif, time.Now())
+	if err != nil {
+		return "", err
+	}
+	var candidates []FirestoreQso
+	for _, qrzQso := range qrzAdi.Qsos {
+		if qrzQso.AppDefined[qrzLogIDKey] != "" {
+			candidates = append(candidates, FirestoreQso{qsopb: qrzQso, id: qrzQso.AppDefined[qrzLogIDKey]})
+		}
+	}
+	match, ambiguous, ok := newQsoMatcher(candidates, defaultMatchWindow).match(qso)
+	if len(ambiguous) > 0 {
+		return "", fmt.Errorf("contact matches several QRZ.com records: %v",
+			describeAmbiguous(qso, ambiguous).ContactIDs)
+	}
+	if !ok {
+		return "", nil
+	}
+	return match.id, nil
+}
+
+func recordQrzLogID(store QsoStore, qso FirestoreQso, logID string) error {
 	if qso.qsopb.AppDefined == nil {
 		qso.qsopb.AppDefined = map[string]string{}
 	}
-	qso.qsopb.AppDefined["app_qrzlog_logid"] = insert.LogId
-	j, err := qsoToJSON(qso.qsopb)
+	hash, err := qrzContentHash(qso.qsopb)
 	if err != nil {
 		return err
 	}
-	_, err = doc.Set(ctx, j)
+	if qso.qsopb.AppDefined[qrzLogIDKey] == logID &&
+		qso.qsopb.AppDefined[qrzUploadStartedKey] == "" &&
+		qso.qsopb.AppDefined[qrzSyncedHashKey] == hash &&
+		qso.qsopb.Qrzcom.GetUploadStatus() == adifpb.UploadStatus_UPLOAD_COMPLETE {
+		return nil
+	}
+	qso.qsopb.AppDefined[qrzLogIDKey] = logID
+	delete(qso.qsopb.AppDefined, qrzUploadStartedKey)
+	markQrzSynced(qso.qsopb)
+	err = store.Update(qso)
 	if err != nil {
 		return err
 	}
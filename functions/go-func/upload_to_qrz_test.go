@@ -0,0 +1,94 @@
+package forester
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	adifpb "github.com/k0swe/adif-json-protobuf/go"
+	qrzlog "github.com/k0swe/qrz-logbook"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func Test_uploadQsoToQrz(t *testing.T) {
+	const qrzAdif = `<EOH>
+<CALL:4>KK9A<QSO_DATE:8>20200329<TIME_ON:4>0034<STATION_CALLSIGN:5>K0SWE<APP_QRZLOG_LOGID:9>488692380<EOR>
+`
+	tests := []struct {
+		name        string
+		appDefined  map[string]string
+		insertErr   error
+		wantInserts int
+		wantLogID   string
+		wantErr     bool
+	}{
+		{name: "new", wantInserts: 1, wantLogID: "111"},
+		{name: "already uploaded", appDefined: map[string]string{qrzLogIDKey: "222"}, wantLogID: "222"},
+		{
+			name:       "in flight and found",
+			appDefined: map[string]string{qrzUploadStartedKey: "2020-03-29T00:35:00Z"},
+			wantLogID:  "488692380",
+		},
+		{
+			name:        "duplicate",
+			insertErr:   errors.New("Unable to add QSO to database: duplicate"),
+			wantInserts: 1,
+			wantLogID:   "488692380",
+		},
+		{name: "other failure", insertErr: errors.New("invalid api key"), wantInserts: 1, wantErr: true},
+	}
+	originalInsert, originalFetch := qrzInsert, qrzFetchAll
+	defer func() { qrzInsert, qrzFetchAll = originalInsert, originalFetch }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inserts := 0
+			qrzInsert = func(ctx context.Context, key *string, adif string, replace bool) (*qrzlog.InsertResponse, error) {
+				inserts++
+				if tt.insertErr != nil {
+					return &qrzlog.InsertResponse{Result: "FAIL"}, tt.insertErr
+				}
+				return &qrzlog.InsertResponse{Result: "OK", LogId: "111", Count: 1}, nil
+			}
+			qrzFetchAll = func(ctx context.Context, key string, option string) (string, error) {
+				return qrzAdif, nil
+			}
+
+			store := NewMemoryQsoStore()
+			_ = store.Create(&adifpb.Qso{
+				TimeOn:           timestamppb.New(time.Date(2020, 3, 29, 0, 34, 0, 0, time.UTC)),
+				LoggingStation:   &adifpb.Station{StationCall: "K0SWE"},
+				ContactedStation: &adifpb.Station{StationCall: "KK9A"},
+				AppDefined:       tt.appDefined,
+			})
+			contacts, _ := store.GetContacts()
+			id := contacts[0].id
+
+			err := uploadQsoToQrz(context.Background(), store, "key", id)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("uploadQsoToQrz() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if inserts != tt.wantInserts {
+				t.Errorf("uploadQsoToQrz() inserts got = %d, want %d", inserts, tt.wantInserts)
+			}
+			got, _ := store.GetContact(id)
+			if tt.wantErr {
+				if got.qsopb.AppDefined[qrzUploadStartedKey] == "" {
+					t.Errorf("uploadQsoToQrz() didn't leave an in-flight marker")
+				}
+				return
+			}
+			if got.qsopb.AppDefined[qrzLogIDKey] != tt.wantLogID {
+				t.Errorf("uploadQsoToQrz() log ID got = %v, want %v",
+					got.qsopb.AppDefined[qrzLogIDKey], tt.wantLogID)
+			}
+			if got.qsopb.AppDefined[qrzUploadStartedKey] != "" {
+				t.Errorf("uploadQsoToQrz() left an in-flight marker")
+			}
+			if got.qsopb.Qrzcom.GetUploadStatus() != adifpb.UploadStatus_UPLOAD_COMPLETE ||
+				got.qsopb.Qrzcom.GetUploadDate() == nil {
+				t.Errorf("uploadQsoToQrz() status got = %v", got.qsopb.Qrzcom)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package forester
+
+import (
+	"os"
+	"runtime/debug"
+	"strings"
+)
+
+// programID identifies this program in the ADIF it writes.
+const programID = "forester-func"
+
+const modulePath = "github.com/k0swe/forester-func"
+
+// buildVersionEnv names the environment variable the deploy sets to the commit it deployed, since
+// Cloud Functions builds from source without the module version or VCS info.
+const buildVersionEnv = "BUILD_VERSION"
+
+// programVersion is the version of this build; see buildVersion.
+var programVersion = buildVersion()
+
+// buildVersion is the version the deploy set in buildVersionEnv, or the version of this module in
+// the build, or else the VCS revision it was built from. Revisions are shortened to 7 characters.
+// Builds without any of them are "dev".
+func buildVersion() string {
+	if version := os.Getenv(buildVersionEnv); version != "" {
+		return shortRevision(version)
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "dev"
+	}
+	module := &info.Main
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			module = dep
+		}
+	}
+	if module.Version != "" && module.Version != "(devel)" {
+		return module.Version
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" && len(setting.Value) >= 7 {
+			return shortRevision(setting.Value)
+		}
+	}
+	return "dev"
+}
+
+// shortRevision shortens a full git commit hash to 7 characters, like git does. Other versions are
+// kept as they are.
+func shortRevision(version string) string {
+	if len(version) == 40 && strings.Trim(version, "0123456789abcdef") == "" {
+		return version[:7]
+	}
+	return version
+}
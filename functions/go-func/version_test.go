@@ -0,0 +1,22 @@
+package forester
+
+import "testing"
+
+func Test_buildVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		env  string
+		want string
+	}{
+		{name: "commit", env: "0123456789abcdef0123456789abcdef01234567", want: "0123456"},
+		{name: "tag", env: "v1.2.3", want: "v1.2.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(buildVersionEnv, tt.env)
+			if got := buildVersion(); got != tt.want {
+				t.Errorf("buildVersion() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
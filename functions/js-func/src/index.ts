@@ -1,14 +1,117 @@
-import { onDocumentCreated } from 'firebase-functions/v2/firestore';
+import {
+  onDocumentCreated,
+  onDocumentDeleted,
+  onDocumentUpdated,
+} from 'firebase-functions/v2/firestore';
 import { PubSub } from '@google-cloud/pubsub';
 import { log } from 'firebase-functions/logger';
 
 const documentId: string = 'logbooks/{logbookId}/contacts/{contactId}';
 const projectId: string = 'k0swe-kellog';
-const topicName: string = 'contact-created';
+const createdTopicName: string = 'contact-created';
+const updatedTopicName: string = 'contact-updated';
+const deletedTopicName: string = 'contact-deleted';
+
+// Fields which the sync functions write themselves, and confirmations and details which are filled
+// in without the contact being edited; changing only these isn't worth sending to QRZ.com. The Go
+// functions' clearQrzSyncNoise ignores the same fields.
+const bookkeepingFields: string[] = [
+  'qrzcom',
+  'clublog',
+  'hrdlog',
+  'lotw',
+  'eqsl',
+  'card',
+  'awardGranted',
+  'creditGranted',
+  'distanceKm',
+];
+const bookkeepingAppFields: RegExp = /^app_(forester_|qrzlog_logid$|lotw_|eqsl_)/;
+const derivedStationFields: { [station: string]: string[] } = {
+  loggingStation: ['latitude', 'longitude'],
+  contactedStation: [
+    'latitude',
+    'longitude',
+    'dxcc',
+    'cqZone',
+    'ituZone',
+    'country',
+    'continent',
+  ],
+};
+const qrzSyncedHashField: string = 'app_forester_qrzlog_synced_hash';
 
 // noinspection JSUnusedGlobalSymbols
 export const onCreateContact = onDocumentCreated(documentId, async (event) => {
   log('Contact was created', event.data?.ref.path);
   const pubsub = new PubSub({ projectId });
-  await pubsub.topic(topicName).publishMessage({ json: event.params });
+  await pubsub.topic(createdTopicName).publishMessage({ json: event.params });
+});
+
+// noinspection JSUnusedGlobalSymbols
+export const onUpdateContact = onDocumentUpdated(documentId, async (event) => {
+  const beforeData = event.data?.before.data();
+  const afterData = event.data?.after.data();
+  if (
+    afterData?.appDefined?.[qrzSyncedHashField] !==
+    beforeData?.appDefined?.[qrzSyncedHashField]
+  ) {
+    // The writer recorded this version as synced, like a QRZ.com import does
+    return;
+  }
+  const before = withoutBookkeeping(beforeData);
+  const after = withoutBookkeeping(afterData);
+  if (canonicalJson(before) === canonicalJson(after)) {
+    return;
+  }
+  log('Contact was updated', event.data?.after.ref.path);
+  const pubsub = new PubSub({ projectId });
+  await pubsub.topic(updatedTopicName).publishMessage({ json: event.params });
 });
+
+// noinspection JSUnusedGlobalSymbols
+export const onDeleteContact = onDocumentDeleted(documentId, async (event) => {
+  log('Contact was deleted', event.data?.ref.path);
+  // The contact is gone by the time the message is handled, so send along its QRZ.com log ID
+  const qrzLogId = event.data?.data()?.appDefined?.app_qrzlog_logid ?? '';
+  const pubsub = new PubSub({ projectId });
+  await pubsub
+    .topic(deletedTopicName)
+    .publishMessage({ json: { ...event.params, qrzLogId } });
+});
+
+function withoutBookkeeping(contact: any): any {
+  if (!contact) {
+    return contact;
+  }
+  const stripped = { ...contact };
+  bookkeepingFields.forEach((field) => delete stripped[field]);
+  if (stripped.appDefined) {
+    stripped.appDefined = { ...stripped.appDefined };
+    Object.keys(stripped.appDefined)
+      .filter((key) => bookkeepingAppFields.test(key))
+      .forEach((key) => delete stripped.appDefined[key]);
+  }
+  Object.entries(derivedStationFields).forEach(([station, fields]) => {
+    if (stripped[station]) {
+      stripped[station] = { ...stripped[station] };
+      fields.forEach((field) => delete stripped[station][field]);
+    }
+  });
+  return stripped;
+}
+
+// JSON with object keys sorted, so equal documents always compare equal.
+function canonicalJson(value: any): string {
+  return JSON.stringify(value, (_key, v) => {
+    if (!v || typeof v !== 'object' || Array.isArray(v)) {
+      return v;
+    }
+    return Object.keys(v)
+      .sort()
+      .reduce((sorted: any, key) => {
+        sorted[key] = v[key];
+        return sorted;
+      }, {});
+  });
+}
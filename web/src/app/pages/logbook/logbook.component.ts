@@ -8,6 +8,7 @@ import {
   inject,
 } from '@angular/core';
 import { Auth, user } from '@angular/fire/auth';
+import { Firestore, doc, onSnapshot } from '@angular/fire/firestore';
 import { MatIconButton } from '@angular/material/button';
 import { MatDialog } from '@angular/material/dialog';
 import { MatDivider } from '@angular/material/divider';
@@ -51,6 +52,7 @@ import { LogbookSettingsComponent } from '../../shared/logbook-settings/logbook-
 export class LogbookComponent implements OnInit {
   auth = inject(Auth);
   private dialog = inject(MatDialog);
+  private firestore = inject(Firestore);
   private http = inject(HttpClient);
   private importExportService = inject(ImportExportService);
   private snackBar = inject(MatSnackBar);
@@ -119,17 +121,7 @@ export class LogbookComponent implements OnInit {
         headers: { Authorization: 'Bearer ' + this.userJwt$.getValue() },
       })
       .subscribe(
-        (response) => {
-          const created = response.created;
-          const modified = response.modified;
-          const noDiff = response.noDiff;
-          this.snackBar.open(
-            `Finished ${provider} import: ` +
-              `${created} QSOs created, ${modified} modified and ${noDiff} with no difference`,
-            null,
-            { duration: 5000 },
-          );
-        },
+        (response) => this.watchImportJob(provider, response.jobId),
         (error) => {
           this.snackBar.open(`Error importing from ${provider}`, null, {
             duration: 5000,
@@ -139,6 +131,43 @@ export class LogbookComponent implements OnInit {
       );
   }
 
+  private watchImportJob(provider: string, jobId: string): void {
+    const jobRef = doc(
+      this.firestore,
+      'logbooks',
+      this.logbookService.logbookId$.getValue(),
+      'importJobs',
+      jobId,
+    );
+    const unsubscribe = onSnapshot(jobRef, (snapshot) => {
+      const job = snapshot.data() as ImportJob;
+      if (!job) {
+        return;
+      }
+      if (job.status === 'failed') {
+        unsubscribe();
+        this.snackBar.open(`Error importing from ${provider}`, null, {
+          duration: 5000,
+        });
+        console.warn(`Error importing from ${provider}:`, job.errors);
+      } else if (job.status === 'done') {
+        unsubscribe();
+        const result = job.result;
+        this.snackBar.open(
+          `Finished ${provider} import: ` +
+            `${result.created} QSOs created, ${result.modified} modified and ${result.noDiff} with no difference`,
+          null,
+          { duration: 5000 },
+        );
+      } else if (job.phase === 'merging') {
+        this.snackBar.open(
+          `Importing from ${provider}... ${job.processed} of ${job.total}`,
+          null,
+        );
+      }
+    });
+  }
+
   importAdi($event: any): void {
     const file = $event.target.files[0] as File;
     this.importExportService.importAdi(file);
@@ -155,7 +184,18 @@ export class LogbookComponent implements OnInit {
 }
 
 interface ImportResponse {
-  created: number;
-  modified: number;
-  noDiff: number;
+  jobId: string;
+}
+
+interface ImportJob {
+  status: 'queued' | 'running' | 'done' | 'failed';
+  phase: 'queued' | 'fetching' | 'merging' | 'done';
+  processed: number;
+  total: number;
+  errors: string[];
+  result: {
+    created: number;
+    modified: number;
+    noDiff: number;
+  };
 }
@@ -1,69 +1,69 @@
+import { HttpClient } from '@angular/common/http';
 import { Injectable, inject } from '@angular/core';
+import { Auth, user } from '@angular/fire/auth';
 import { MatSnackBar } from '@angular/material/snack-bar';
-import { AdifFormatter, AdifParser } from 'adif-parser-ts';
-import { Observable, forkJoin } from 'rxjs';
-import { map, take } from 'rxjs/operators';
+import { AdifFormatter } from 'adif-parser-ts';
+import { Observable } from 'rxjs';
+import { map, mergeMap, take } from 'rxjs/operators';
 
-import { Qso } from '../qso';
-import { Adif2Proto } from '../shared/adif2proto';
+import { environment } from '../../environments/environment';
 import { Proto2Adif } from '../shared/proto2adif';
+import { LogbookService } from './logbook.service';
 import { FirebaseQso, QsoService } from './qso.service';
 
 @Injectable({
   providedIn: 'root',
 })
 export class ImportExportService {
+  private auth = inject(Auth);
+  private http = inject(HttpClient);
+  private logbookService = inject(LogbookService);
   private qsoService = inject(QsoService);
   private snackBar = inject(MatSnackBar);
 
-  public importAdi(file: File): void {
-    const fileReader = new FileReader();
-    fileReader.onload = () => {
-      const content = fileReader.result as string;
-      try {
-        const adiObj = AdifParser.parseAdi(content);
-        const adi = Adif2Proto.translateAdi(adiObj);
-        this.addQsos(adi.qsos);
-      } catch (e) {
-        this.snackBar.open(
-          'There was a problem importing the ADIF file',
-          null,
-          { duration: 10000 },
-        );
-        console.log('There was a problem importing the ADIF file. ', e);
-      }
-    };
-    fileReader.readAsText(file);
-  }
-
-  private addQsos(qsos: Qso[]): void {
-    const toSave: Qso[] = this.deduplicate(qsos);
-    if (toSave.length === 0) {
-      this.snackBar.open(`All QSOs already present; nothing to do`, null, {
-        duration: 5000,
-      });
-    } else {
-      this.snackBar.open(`Adding ${toSave.length} QSOs`, null, {
-        duration: 10000,
-      });
-    }
-    const upsertObservables = toSave.map((qso) =>
-      this.qsoService.addOrUpdate({ qso }).pipe(take(1)),
-    );
-    forkJoin(upsertObservables).subscribe(() =>
-      this.snackBar.open('Finished import', null, { duration: 5000 }),
-    );
-  }
+  readonly importAdifUrl = environment.functionsBase + 'ImportAdif';
 
-  private deduplicate(qsos: Qso[]): Qso[] {
-    // TODO: merge existing QSO details
-    const toSave: Qso[] = [];
-    for (const qso of qsos) {
-      if (!this.qsoService.findMatch(qso)) {
-        toSave.push(qso);
-      }
-    }
-    return toSave;
+  public importAdi(file: File): void {
+    this.snackBar.open('Importing ADIF...', null);
+    const formData = new FormData();
+    formData.append('file', file);
+    const url =
+      this.importAdifUrl +
+      '?logbookId=' +
+      this.logbookService.logbookId$.getValue();
+    user(this.auth)
+      .pipe(
+        take(1),
+        mergeMap((u) => u.getIdToken(false)),
+        mergeMap((jwt) =>
+          this.http.post<ImportAdifResponse>(url, formData, {
+            headers: { Authorization: 'Bearer ' + jwt },
+          }),
+        ),
+      )
+      .subscribe(
+        (response) => {
+          const skipped = (response.parseErrors || []).filter(
+            (e) => e.skipped,
+          );
+          let message =
+            `Finished import: ${response.created} QSOs created, ` +
+            `${response.modified} modified and ${response.noDiff} with no difference`;
+          if (skipped.length > 0) {
+            message += `; ${skipped.length} unreadable records were skipped`;
+            console.log('Skipped ADIF records', skipped);
+          }
+          this.snackBar.open(message, null, { duration: 5000 });
+        },
+        (error) => {
+          this.snackBar.open(
+            'There was a problem importing the ADIF file',
+            null,
+            { duration: 10000 },
+          );
+          console.log('There was a problem importing the ADIF file. ', error);
+        },
+      );
   }
 
   public exportAdi(): Observable<Blob> {
@@ -84,3 +84,18 @@ export class ImportExportService {
     );
   }
 }
+
+interface ImportAdifResponse {
+  created: number;
+  modified: number;
+  noDiff: number;
+  parseErrors: AdifRecordError[];
+}
+
+interface AdifRecordError {
+  record: number;
+  line: number;
+  skipped: boolean;
+  error?: string;
+  fields?: { field: string; value: string; line: number; error: string }[];
+}
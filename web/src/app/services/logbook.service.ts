@@ -84,6 +84,7 @@ export class LogbookService {
 }
 
 export interface LogbookSettings {
+  autoSync: boolean;
   lotwLastFetchedDate: Date;
   qrzLogbookApiKeyLastSet: Date;
   qthProfile: Station;
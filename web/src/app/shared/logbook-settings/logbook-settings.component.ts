@@ -16,6 +16,7 @@ import {
 } from '@angular/material/dialog';
 import { MatFormField, MatHint, MatLabel } from '@angular/material/form-field';
 import { MatInput } from '@angular/material/input';
+import { MatSlideToggle } from '@angular/material/slide-toggle';
 import { forkJoin } from 'rxjs';
 
 import { Station } from '../../qso';
@@ -44,6 +45,7 @@ import { StationDetailComponent } from '../station-detail/station-detail.compone
     MatHint,
     MatInput,
     MatLabel,
+    MatSlideToggle,
     ReactiveFormsModule,
     StationDetailComponent,
   ],
@@ -60,6 +62,7 @@ export class LogbookSettingsComponent implements OnInit {
 
   constructor() {
     this.logbookSettingsForm = this.fb.group({
+      autoSync: false,
       lotwUser: '',
       lotwPass: '',
       qrzLogbookApiKey: '',
@@ -74,6 +77,10 @@ export class LogbookSettingsComponent implements OnInit {
   ngOnInit(): void {
     this.logbookService.settings$.subscribe((settings) => {
       this.qthProfile = settings.qthProfile;
+      this.logbookSettingsForm.patchValue(
+        { autoSync: !!settings.autoSync },
+        { emitEvent: false },
+      );
     });
   }
 
@@ -85,11 +92,12 @@ export class LogbookSettingsComponent implements OnInit {
   }
 
   save(): void {
+    const formValue = this.logbookSettingsForm.value;
     const qthObs = this.logbookService.set({
+      autoSync: formValue.autoSync,
       qthProfile: this.qthProfile,
     } as LogbookSettings);
 
-    const formValue = this.logbookSettingsForm.value;
     const secretsObs = this.secretService.setSecrets(
       new Map([
         ['lotw_username', formValue.lotwUser],